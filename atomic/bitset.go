@@ -0,0 +1,185 @@
+// github.com/Vonng/gopher/atomic/bitset.go provides a concurrent growable bitset
+package atomic
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// bitsetSegmentWords : words per segment, 64 words = 4096 bits
+	bitsetSegmentWords = 64
+	bitsetSegmentBits  = bitsetSegmentWords * 64
+)
+
+// bitsetSegment is a fixed chunk of words, never moved once allocated
+type bitsetSegment [bitsetSegmentWords]uint64
+
+/**************************************************************
+* struct: Bitset
+**************************************************************/
+
+// Bitset is a concurrent set of non-negative ints, e.g. shard readiness
+// or feature flags. each bit costs 1 bit instead of 4 bytes of AtomicBool.
+// bits are updated with word-level CAS. storage is split into segments
+// which never move, growing only copies the segment directory,
+// so updates racing with growth are never lost.
+// zero value is an empty bitset ready to use
+type Bitset struct {
+	// dir : segment directory, replaced on growth
+	dir atomic.Pointer[[]*bitsetSegment]
+	// lock : serialize growth
+	lock sync.Mutex
+}
+
+// NewBitset create a bitset with capacity of at least n bits
+func NewBitset(n int) *Bitset {
+	b := &Bitset{}
+	b.Grow(n)
+	return b
+}
+
+// segments returns current segment directory
+func (b *Bitset) segments() []*bitsetSegment {
+	if dir := b.dir.Load(); dir != nil {
+		return *dir
+	}
+	return nil
+}
+
+// word returns address of word containing bit i, nil if out of capacity
+func (b *Bitset) word(i int) *uint64 {
+	if i < 0 {
+		return nil
+	}
+	dir := b.segments()
+	seg := i / bitsetSegmentBits
+	if seg >= len(dir) {
+		return nil
+	}
+	return &dir[seg][i%bitsetSegmentBits/64]
+}
+
+// Cap returns number of bits the set could hold without growing
+func (b *Bitset) Cap() int {
+	return len(b.segments()) * bitsetSegmentBits
+}
+
+// Grow makes sure capacity is at least n bits
+func (b *Bitset) Grow(n int) {
+	need := (n + bitsetSegmentBits - 1) / bitsetSegmentBits
+	if need <= len(b.segments()) {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	old := b.segments()
+	if need <= len(old) {
+		return
+	}
+	dir := make([]*bitsetSegment, need)
+	copy(dir, old)
+	for i := len(old); i < need; i++ {
+		dir[i] = new(bitsetSegment)
+	}
+	b.dir.Store(&dir)
+}
+
+// Set sets bit i, grows if i is beyond capacity. panics if i < 0
+func (b *Bitset) Set(i int) {
+	b.TestAndSet(i)
+}
+
+// TestAndSet sets bit i and reports whether it was already set,
+// only one of concurrent callers on the same bit get false
+func (b *Bitset) TestAndSet(i int) bool {
+	if i < 0 {
+		panic("atomic: negative bitset index")
+	}
+	w := b.word(i)
+	if w == nil {
+		b.Grow(i + 1)
+		w = b.word(i)
+	}
+	mask := uint64(1) << uint(i%64)
+	for {
+		old := atomic.LoadUint64(w)
+		if old&mask != 0 {
+			return true
+		}
+		if atomic.CompareAndSwapUint64(w, old, old|mask) {
+			return false
+		}
+	}
+}
+
+// Clear clears bit i, no-op if i is beyond capacity
+func (b *Bitset) Clear(i int) {
+	b.TestAndClear(i)
+}
+
+// TestAndClear clears bit i and reports whether it was set
+func (b *Bitset) TestAndClear(i int) bool {
+	w := b.word(i)
+	if w == nil {
+		return false
+	}
+	mask := uint64(1) << uint(i%64)
+	for {
+		old := atomic.LoadUint64(w)
+		if old&mask == 0 {
+			return false
+		}
+		if atomic.CompareAndSwapUint64(w, old, old&^mask) {
+			return true
+		}
+	}
+}
+
+// Test reports whether bit i is set
+func (b *Bitset) Test(i int) bool {
+	w := b.word(i)
+	return w != nil && atomic.LoadUint64(w)&(uint64(1)<<uint(i%64)) != 0
+}
+
+// Count returns number of set bits (population count)
+func (b *Bitset) Count() (n int) {
+	for _, seg := range b.segments() {
+		for i := range seg {
+			n += bits.OnesCount64(atomic.LoadUint64(&seg[i]))
+		}
+	}
+	return
+}
+
+// Range calls fn for each set bit in ascending order until fn returns
+// false. each word is loaded atomically once, bits changed during
+// iteration may or may not be visited
+func (b *Bitset) Range(fn func(i int) bool) {
+	for s, seg := range b.segments() {
+		for w := range seg {
+			word := atomic.LoadUint64(&seg[w])
+			for word != 0 {
+				bit := bits.TrailingZeros64(word)
+				if !fn(s*bitsetSegmentBits + w*64 + bit) {
+					return
+				}
+				word &= word - 1
+			}
+		}
+	}
+}
+
+// Snapshot copies all words out, each word is loaded atomically,
+// bit i is at words[i/64] & (1 << (i%64))
+func (b *Bitset) Snapshot() []uint64 {
+	dir := b.segments()
+	words := make([]uint64, 0, len(dir)*bitsetSegmentWords)
+	for _, seg := range dir {
+		for i := range seg {
+			words = append(words, atomic.LoadUint64(&seg[i]))
+		}
+	}
+	return words
+}
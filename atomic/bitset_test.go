@@ -0,0 +1,73 @@
+package atomic
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestBitset(t *testing.T) {
+	var b Bitset
+	if b.Test(3) || b.Cap() != 0 || b.TestAndClear(3) {
+		t.Fatal("zero Bitset should be empty")
+	}
+	for _, i := range []int{0, 63, 64, 5000, 9999} {
+		if b.TestAndSet(i) {
+			t.Fatalf("bit %d set before TestAndSet", i)
+		}
+		if !b.TestAndSet(i) || !b.Test(i) {
+			t.Fatalf("bit %d not set after TestAndSet", i)
+		}
+	}
+	if b.Cap() < 10000 || b.Count() != 5 {
+		t.Fatalf("Inconsistent cap or count: %d %d", b.Cap(), b.Count())
+	}
+	b.Clear(64)
+	b.Clear(1 << 20)
+	var got []int
+	b.Range(func(i int) bool {
+		got = append(got, i)
+		return true
+	})
+	want := []int{0, 63, 5000, 9999}
+	if len(got) != len(want) {
+		t.Fatalf("Inconsistent range: expected: %v, actual: %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Inconsistent range: expected: %v, actual: %v", want, got)
+		}
+	}
+	words := b.Snapshot()
+	if words[0] != 1|1<<63 || words[1] != 0 {
+		t.Fatalf("Inconsistent snapshot: %x %x", words[0], words[1])
+	}
+}
+
+// Set racing with growth must never be lost
+func TestBitsetParallel(t *testing.T) {
+	b := NewBitset(64)
+	var winners Int32
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 20000; i++ {
+				if i%8 == g {
+					b.Set(i)
+				}
+				if !b.TestAndSet(i % 100) {
+					winners.Inc()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if b.Count() != 20000 {
+		t.Fatalf("Inconsistent count: expected: %d, actual: %d", 20000, b.Count())
+	}
+	// each of bit 0~99 could be won by TestAndSet at most once
+	if winners.Load() > 100 {
+		t.Fatalf("TestAndSet won more than once per bit: %d", winners.Load())
+	}
+}
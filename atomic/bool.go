@@ -22,3 +22,31 @@ func (ab *AtomicBool) Get() bool {
 	}
 	return false
 }
+
+// CompareAndSwap set bool to new only if it's current value is old
+func (ab *AtomicBool) CompareAndSwap(old, new bool) (swapped bool) {
+	return atomic.CompareAndSwapInt32(&(ab.flag), boolToInt32(old), boolToInt32(new))
+}
+
+// Swap set bool to given value and return the old one
+func (ab *AtomicBool) Swap(value bool) (old bool) {
+	return atomic.SwapInt32(&(ab.flag), boolToInt32(value)) != 0
+}
+
+// Toggle flips bool value and return the old one
+func (ab *AtomicBool) Toggle() (old bool) {
+	for {
+		flag := atomic.LoadInt32(&(ab.flag))
+		if atomic.CompareAndSwapInt32(&(ab.flag), flag, boolToInt32(flag == 0)) {
+			return flag != 0
+		}
+	}
+}
+
+// boolToInt32 maps true to 1 and false to 0
+func boolToInt32(value bool) int32 {
+	if value {
+		return 1
+	}
+	return 0
+}
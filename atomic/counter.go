@@ -12,6 +12,12 @@ type Counter interface {
 	Get() int64
 	Inc() int64
 	Dec() int64
+	// CompareAndSwap set counter to new only if current value is old
+	CompareAndSwap(old, new int64) bool
+	// Swap set counter to given value and return the old one
+	Swap(value int64) int64
+	// AddClamped add delta but keep result in [min, max], return new value
+	AddClamped(delta, min, max int64) int64
 }
 
 /**************************************************************
@@ -52,3 +58,41 @@ func (self *myCounter) Inc() int64 {
 func (self *myCounter) Dec() int64 {
 	return atomic.AddInt64(&(self.Int), -1)
 }
+
+// CompareAndSwap set counter to new only if current value is old
+func (self *myCounter) CompareAndSwap(old, new int64) bool {
+	return atomic.CompareAndSwapInt64(&(self.Int), old, new)
+}
+
+// Swap set counter to given value and return the old one
+func (self *myCounter) Swap(value int64) int64 {
+	return atomic.SwapInt64(&(self.Int), value)
+}
+
+// AddClamped add delta to counter, result is clamped into [min, max]
+// a counter already out of range is pulled back into range as well
+func (self *myCounter) AddClamped(delta, min, max int64) int64 {
+	for {
+		old := atomic.LoadInt64(&(self.Int))
+		new := clamp(old, delta, min, max)
+		if new == old || atomic.CompareAndSwapInt64(&(self.Int), old, new) {
+			return new
+		}
+	}
+}
+
+// clamp returns value + delta limited in [min, max] without overflow
+func clamp(value, delta, min, max int64) int64 {
+	sum := value + delta
+	switch {
+	case delta > 0 && sum < value:
+		return max
+	case delta < 0 && sum > value:
+		return min
+	case sum > max:
+		return max
+	case sum < min:
+		return min
+	}
+	return sum
+}
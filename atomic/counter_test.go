@@ -0,0 +1,119 @@
+package atomic
+
+import (
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestAtomicBoolSwapAndToggle(t *testing.T) {
+	var ab AtomicBool
+	if ab.CompareAndSwap(true, false) {
+		t.Fatal("CompareAndSwap succeed with stale old value!")
+	}
+	if !ab.CompareAndSwap(false, true) || !ab.Get() {
+		t.Fatal("CompareAndSwap failed!")
+	}
+	if old := ab.Swap(false); !old || ab.Get() {
+		t.Fatalf("Inconsistent swap result: old: %v, new: %v", old, ab.Get())
+	}
+	if old := ab.Toggle(); old || !ab.Get() {
+		t.Fatalf("Inconsistent toggle result: old: %v, new: %v", old, ab.Get())
+	}
+}
+
+// only one goroutine could win the flip
+func TestAtomicBoolFirstWins(t *testing.T) {
+	var ab AtomicBool
+	var winners Int32
+	var wg sync.WaitGroup
+	for g := 0; g < 64; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ab.CompareAndSwap(false, true) {
+				winners.Inc()
+			}
+		}()
+	}
+	wg.Wait()
+	if winners.Load() != 1 {
+		t.Fatalf("Inconsistent winners: expected: %d, actual: %d", 1, winners.Load())
+	}
+}
+
+// even number of toggles in parallel leaves value unchanged
+func TestAtomicBoolToggleParallel(t *testing.T) {
+	var ab AtomicBool
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 1000; n++ {
+				ab.Toggle()
+			}
+		}()
+	}
+	wg.Wait()
+	if ab.Get() {
+		t.Fatal("Toggle lost updates!")
+	}
+}
+
+func TestCounterCompareAndSwap(t *testing.T) {
+	c := NewCounter()
+	c.Set(5)
+	if c.CompareAndSwap(4, 10) {
+		t.Fatal("CompareAndSwap succeed with stale old value!")
+	}
+	if !c.CompareAndSwap(5, 10) || c.Get() != 10 {
+		t.Fatalf("CompareAndSwap failed: %d", c.Get())
+	}
+	if old := c.Swap(1); old != 10 || c.Get() != 1 {
+		t.Fatalf("Inconsistent swap result: old: %d, new: %d", old, c.Get())
+	}
+}
+
+func TestCounterAddClamped(t *testing.T) {
+	table := []struct {
+		init, delta, min, max int64
+		out                   int64
+	}{
+		{0, 5, 0, 10, 5},
+		{8, 5, 0, 10, 10},
+		{2, -5, 0, 10, 0},
+		{20, 1, 0, 10, 10},
+		{-20, -1, 0, 10, 0},
+		{math.MaxInt64 - 1, 10, 0, math.MaxInt64, math.MaxInt64},
+		{math.MinInt64 + 1, -10, math.MinInt64, 0, math.MinInt64},
+	}
+	for _, tt := range table {
+		c := NewCounter()
+		c.Set(tt.init)
+		if out := c.AddClamped(tt.delta, tt.min, tt.max); out != tt.out || c.Get() != tt.out {
+			t.Errorf("AddClamped(%d, %d, %d) on %d => %d, want %d",
+				tt.delta, tt.min, tt.max, tt.init, out, tt.out)
+		}
+	}
+}
+
+func TestCounterAddClampedParallel(t *testing.T) {
+	c := NewCounter()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 1000; n++ {
+				if v := c.AddClamped(1, 0, 100); v < 0 || v > 100 {
+					t.Errorf("counter out of range: %d", v)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if c.Get() != 100 {
+		t.Fatalf("Inconsistent counter: expected: %d, actual: %d", 100, c.Get())
+	}
+}
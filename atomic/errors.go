@@ -0,0 +1,42 @@
+package atomic
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// Error returned by package atomic
+var (
+	// ErrInvalidWindow occurs when init a rolling counter with invalid window or buckets
+	ErrInvalidWindow = errors.New("invalid rolling window")
+
+	// ErrInvalidPoolSize occurs when init or resize a worker pool with invalid limit
+	ErrInvalidPoolSize = errors.New("invalid pool size")
+)
+
+// PanicError wraps a recovered panic value so it can be returned as error
+type PanicError struct {
+	// Value : value passed to panic
+	Value interface{}
+	// Stack : stack trace of the panicking goroutine
+	Stack []byte
+}
+
+// Error implements error
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v\n\n%s", e.Value, e.Stack)
+}
+
+// Unwrap returns the panic value if it is an error
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+	return nil
+}
+
+// newPanicError should be called in deferred function with recovered value
+func newPanicError(value interface{}) *PanicError {
+	return &PanicError{Value: value, Stack: debug.Stack()}
+}
@@ -0,0 +1,206 @@
+// github.com/Vonng/gopher/atomic/histogram.go provides a lock-free latency histogram
+package atomic
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// HistogramSubBucketBits : each power of 2 is split into 2^bits linear
+// sub-buckets, so relative error of a recorded value is under 1/2^bits (6.25%)
+const HistogramSubBucketBits = 4
+
+const (
+	histogramSubBuckets = 1 << HistogramSubBucketBits
+	histogramSubMask    = histogramSubBuckets - 1
+	histogramBuckets    = (64 - HistogramSubBucketBits) * histogramSubBuckets
+)
+
+/**************************************************************
+* struct: Histogram
+**************************************************************/
+
+// Histogram is a log-linear bucketed histogram of non-negative int64
+// (typically nanoseconds). values below 2^bits are exact, larger values
+// fall into buckets whose width doubles every power of 2.
+// Record never locks, it's a few atomic adds on fixed size array.
+// The zero value is an empty histogram ready to use
+type Histogram struct {
+	count uint64
+	sum   int64
+	// minGap : math.MaxInt64 - min, so that zero means no sample yet
+	// and lowering min is raising minGap
+	minGap  int64
+	max     int64
+	buckets [histogramBuckets]uint64
+}
+
+// NewHistogram create an empty histogram, same as new(Histogram)
+func NewHistogram() *Histogram {
+	return &Histogram{}
+}
+
+// histogramIndex maps value to bucket index
+func histogramIndex(v int64) int {
+	if v < histogramSubBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - HistogramSubBucketBits - 1
+	return (shift+1)<<HistogramSubBucketBits + int(v>>uint(shift))&histogramSubMask
+}
+
+// histogramBounds returns inclusive value range of bucket i
+func histogramBounds(i int) (lower, upper int64) {
+	group := i >> HistogramSubBucketBits
+	if group == 0 {
+		return int64(i), int64(i)
+	}
+	shift := uint(group - 1)
+	m := uint64(i&histogramSubMask + histogramSubBuckets)
+	return int64(m << shift), int64((m+1)<<shift - 1)
+}
+
+// Record adds a duration sample
+func (h *Histogram) Record(d time.Duration) {
+	h.RecordValue(int64(d))
+}
+
+// RecordValue adds a sample, negative value is recorded as 0
+func (h *Histogram) RecordValue(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	atomic.AddUint64(&h.buckets[histogramIndex(v)], 1)
+	atomic.AddInt64(&h.sum, v)
+	atomic.AddUint64(&h.count, 1)
+	storeMax(&h.minGap, math.MaxInt64-v)
+	storeMax(&h.max, v)
+}
+
+// storeMax raises *addr to v if v is larger
+func storeMax(addr *int64, v int64) {
+	for {
+		old := atomic.LoadInt64(addr)
+		if v <= old || atomic.CompareAndSwapInt64(addr, old, v) {
+			return
+		}
+	}
+}
+
+// Count returns number of samples
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
+
+// Quantile returns approximate q-th (0 <= q <= 1) quantile as duration
+func (h *Histogram) Quantile(q float64) time.Duration {
+	return time.Duration(h.Snapshot().Quantile(q))
+}
+
+// Merge adds all samples of other histogram into h
+func (h *Histogram) Merge(other *Histogram) {
+	s := other.Snapshot()
+	if s.Count == 0 {
+		return
+	}
+	for _, b := range s.Buckets {
+		atomic.AddUint64(&h.buckets[histogramIndex(b.Lower)], b.Count)
+	}
+	atomic.AddInt64(&h.sum, s.Sum)
+	atomic.AddUint64(&h.count, s.Count)
+	storeMax(&h.minGap, math.MaxInt64-s.Min)
+	storeMax(&h.max, s.Max)
+}
+
+// Reset clears all samples. Records concurrent with Reset may be
+// partially kept (e.g. counted in bucket but not in sum)
+func (h *Histogram) Reset() {
+	atomic.StoreUint64(&h.count, 0)
+	atomic.StoreInt64(&h.sum, 0)
+	atomic.StoreInt64(&h.minGap, 0)
+	atomic.StoreInt64(&h.max, 0)
+	for i := range h.buckets {
+		atomic.StoreUint64(&h.buckets[i], 0)
+	}
+}
+
+// Snapshot copies current state out for export or further calculation.
+// Count is the sum of copied buckets, so quantiles are self-consistent
+// even if Record runs concurrently
+func (h *Histogram) Snapshot() *HistogramSnapshot {
+	s := &HistogramSnapshot{
+		Sum: atomic.LoadInt64(&h.sum),
+		Min: math.MaxInt64 - atomic.LoadInt64(&h.minGap),
+		Max: atomic.LoadInt64(&h.max),
+	}
+	for i := range h.buckets {
+		if n := atomic.LoadUint64(&h.buckets[i]); n > 0 {
+			lower, upper := histogramBounds(i)
+			s.Buckets = append(s.Buckets, HistogramBucket{lower, upper, n})
+			s.Count += n
+		}
+	}
+	if s.Count == 0 {
+		s.Min, s.Max, s.Sum = 0, 0, 0
+	}
+	return s
+}
+
+/**************************************************************
+* struct: HistogramSnapshot
+**************************************************************/
+
+// HistogramBucket is a non-empty bucket with inclusive bounds
+type HistogramBucket struct {
+	Lower int64  `json:"lower"`
+	Upper int64  `json:"upper"`
+	Count uint64 `json:"count"`
+}
+
+// HistogramSnapshot is a point-in-time copy of Histogram
+type HistogramSnapshot struct {
+	Count   uint64            `json:"count"`
+	Sum     int64             `json:"sum"`
+	Min     int64             `json:"min"`
+	Max     int64             `json:"max"`
+	Buckets []HistogramBucket `json:"buckets"`
+}
+
+// Mean returns average of samples
+func (s *HistogramSnapshot) Mean() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.Sum) / float64(s.Count)
+}
+
+// Quantile returns approximate q-th quantile, i.e. upper bound of the
+// bucket containing that rank, clamped into [Min, Max]
+func (s *HistogramSnapshot) Quantile(q float64) int64 {
+	if s.Count == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return s.Min
+	}
+	if q >= 1 {
+		return s.Max
+	}
+	rank := uint64(math.Ceil(q * float64(s.Count)))
+	var seen uint64
+	for _, b := range s.Buckets {
+		if seen += b.Count; seen >= rank {
+			v := b.Upper
+			if v > s.Max {
+				v = s.Max
+			}
+			if v < s.Min {
+				v = s.Min
+			}
+			return v
+		}
+	}
+	return s.Max
+}
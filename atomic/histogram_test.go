@@ -0,0 +1,121 @@
+package atomic
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	// buckets must be contiguous and cover all non-negative int64
+	var next int64
+	for i := 0; i < histogramBuckets; i++ {
+		lower, upper := histogramBounds(i)
+		if lower != next || upper < lower {
+			t.Fatalf("bucket %d [%d, %d] not contiguous with %d", i, lower, upper, next)
+		}
+		if histogramIndex(lower) != i || histogramIndex(upper) != i {
+			t.Fatalf("bucket %d [%d, %d] maps to %d, %d", i, lower, upper,
+				histogramIndex(lower), histogramIndex(upper))
+		}
+		next = upper + 1
+	}
+	if next != math.MinInt64 {
+		t.Fatalf("buckets do not cover MaxInt64: end at %d", next)
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	h := NewHistogram()
+	if h.Quantile(0.5) != 0 {
+		t.Fatal("empty histogram should return zero quantile")
+	}
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+	table := []struct {
+		q   float64
+		out time.Duration
+	}{
+		{0, time.Microsecond},
+		{0.5, 500 * time.Microsecond},
+		{0.99, 990 * time.Microsecond},
+		{1, time.Millisecond},
+	}
+	for _, tt := range table {
+		out := h.Quantile(tt.q)
+		if diff := math.Abs(float64(out-tt.out)) / float64(tt.out); diff > 1.0/histogramSubBuckets {
+			t.Errorf("Quantile(%v) => %s, want %s", tt.q, out, tt.out)
+		}
+	}
+	s := h.Snapshot()
+	if s.Count != 1000 || s.Mean() != float64(500500*time.Microsecond)/1000 {
+		t.Fatalf("Inconsistent snapshot: count: %d, mean: %v", s.Count, s.Mean())
+	}
+}
+
+func TestHistogramMergeAndReset(t *testing.T) {
+	a, b := NewHistogram(), NewHistogram()
+	a.RecordValue(10)
+	b.RecordValue(1000)
+	b.RecordValue(-5)
+	a.Merge(b)
+	s := a.Snapshot()
+	if s.Count != 3 || s.Min != 0 || s.Max != 1000 || s.Sum != 1010 {
+		t.Fatalf("Inconsistent merged snapshot: %+v", s)
+	}
+	a.Reset()
+	if s := a.Snapshot(); s.Count != 0 || len(s.Buckets) != 0 || s.Min != 0 {
+		t.Fatalf("Reset failed: %+v", s)
+	}
+	a.RecordValue(7)
+	if a.Snapshot().Min != 7 {
+		t.Fatalf("min not reset: %d", a.Snapshot().Min)
+	}
+}
+
+func TestHistogramParallel(t *testing.T) {
+	h := NewHistogram()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for n := 0; n < 1000; n++ {
+				h.RecordValue(int64(g*1000 + n))
+				h.Quantile(0.99)
+			}
+		}(g)
+	}
+	wg.Wait()
+	s := h.Snapshot()
+	if s.Count != 8000 || s.Min != 0 || s.Max != 7999 {
+		t.Fatalf("Inconsistent snapshot: %d %d %d", s.Count, s.Min, s.Max)
+	}
+}
+
+// zero value must track min like NewHistogram does
+func TestHistogramZeroValue(t *testing.T) {
+	var h Histogram
+	if s := h.Snapshot(); s.Count != 0 || s.Min != 0 || s.Max != 0 {
+		t.Fatalf("Inconsistent empty snapshot: %+v", s)
+	}
+	for _, v := range []int64{5, 3, 9} {
+		h.RecordValue(v)
+	}
+	if s := h.Snapshot(); s.Min != 3 || s.Max != 9 {
+		t.Fatalf("Inconsistent min, max: expected: 3, 9, actual: %d, %d", s.Min, s.Max)
+	}
+	h.Reset()
+	h.RecordValue(7)
+	if s := h.Snapshot(); s.Min != 7 || s.Max != 7 {
+		t.Fatalf("Inconsistent min, max after reset: expected: 7, 7, actual: %d, %d", s.Min, s.Max)
+	}
+
+	var merged Histogram
+	merged.Merge(&h)
+	if s := merged.Snapshot(); s.Count != 1 || s.Min != 7 || s.Max != 7 {
+		t.Fatalf("Inconsistent merged snapshot: %+v", s)
+	}
+}
@@ -0,0 +1,156 @@
+// github.com/Vonng/gopher/atomic/meter.go provides EWMA rate meters
+package atomic
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// MeterTickInterval is how often EWMA rates are decayed
+const MeterTickInterval = 5 * time.Second
+
+/**************************************************************
+* struct: EWMA
+**************************************************************/
+
+// EWMA is exponentially-weighted moving average of rate,
+// same as the load average in unix `top`.
+// Update is safe to call concurrently, while Tick should be
+// driven by a single goroutine (Meter does that for you)
+type EWMA struct {
+	// alpha : weight of latest tick
+	alpha float64
+	// uncounted : events since last tick
+	uncounted int64
+	// rate : events per nanosecond
+	rate Float64
+	// init : first tick takes instant rate directly
+	init AtomicBool
+}
+
+// NewEWMA create an EWMA averaging over given window,
+// assuming Tick is called every MeterTickInterval
+func NewEWMA(window time.Duration) *EWMA {
+	return &EWMA{alpha: 1 - math.Exp(-MeterTickInterval.Seconds()/window.Seconds())}
+}
+
+// Update adds n events
+func (e *EWMA) Update(n int64) {
+	atomic.AddInt64(&e.uncounted, n)
+}
+
+// Tick decays the rate, should be called every MeterTickInterval
+func (e *EWMA) Tick() {
+	count := atomic.SwapInt64(&e.uncounted, 0)
+	instant := float64(count) / float64(MeterTickInterval)
+	if !e.init.Swap(true) {
+		e.rate.Store(instant)
+		return
+	}
+	rate := e.rate.Load()
+	e.rate.Store(rate + e.alpha*(instant-rate))
+}
+
+// Rate returns events per second
+func (e *EWMA) Rate() float64 {
+	return e.rate.Load() * float64(time.Second)
+}
+
+/**************************************************************
+* struct: Meter
+**************************************************************/
+
+// Meter measures event rate: total count, mean rate and
+// 1, 5, 15 minute EWMA rates. ticks are applied lazily
+// by whoever calls Mark or Rate first after an interval elapsed,
+// so no background goroutine is needed
+type Meter struct {
+	count    int64
+	start    int64
+	lastTick int64
+	m1       *EWMA
+	m5       *EWMA
+	m15      *EWMA
+	// clock : time source, replaceable in test
+	clock func() time.Time
+}
+
+// NewMeter create a new meter starting from now
+func NewMeter() *Meter {
+	return newMeter(time.Now)
+}
+
+// newMeter create a meter with given clock
+func newMeter(clock func() time.Time) *Meter {
+	now := clock().UnixNano()
+	return &Meter{
+		start:    now,
+		lastTick: now,
+		m1:       NewEWMA(time.Minute),
+		m5:       NewEWMA(5 * time.Minute),
+		m15:      NewEWMA(15 * time.Minute),
+		clock:    clock,
+	}
+}
+
+// Mark record n events
+func (m *Meter) Mark(n int64) {
+	m.tickIfNecessary()
+	atomic.AddInt64(&m.count, n)
+	m.m1.Update(n)
+	m.m5.Update(n)
+	m.m15.Update(n)
+}
+
+// tickIfNecessary applies all elapsed ticks. the goroutine winning
+// CAS on lastTick owns those ticks, thus Tick is never concurrent
+func (m *Meter) tickIfNecessary() {
+	now := m.clock().UnixNano()
+	last := atomic.LoadInt64(&m.lastTick)
+	elapsed := now - last
+	if elapsed < int64(MeterTickInterval) {
+		return
+	}
+	next := now - elapsed%int64(MeterTickInterval)
+	if !atomic.CompareAndSwapInt64(&m.lastTick, last, next) {
+		return
+	}
+	for i := int64(0); i < elapsed/int64(MeterTickInterval); i++ {
+		m.m1.Tick()
+		m.m5.Tick()
+		m.m15.Tick()
+	}
+}
+
+// Count returns total events marked
+func (m *Meter) Count() int64 {
+	return atomic.LoadInt64(&m.count)
+}
+
+// Rate1 returns one-minute moving average rate per second
+func (m *Meter) Rate1() float64 {
+	m.tickIfNecessary()
+	return m.m1.Rate()
+}
+
+// Rate5 returns five-minute moving average rate per second
+func (m *Meter) Rate5() float64 {
+	m.tickIfNecessary()
+	return m.m5.Rate()
+}
+
+// Rate15 returns fifteen-minute moving average rate per second
+func (m *Meter) Rate15() float64 {
+	m.tickIfNecessary()
+	return m.m15.Rate()
+}
+
+// RateMean returns mean rate per second since the meter was created
+func (m *Meter) RateMean() float64 {
+	elapsed := m.clock().UnixNano() - m.start
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(m.Count()) / time.Duration(elapsed).Seconds()
+}
@@ -0,0 +1,73 @@
+package atomic
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEWMA(t *testing.T) {
+	e := NewEWMA(time.Minute)
+	e.Update(15)
+	e.Tick()
+	if e.Rate() != 3 {
+		t.Fatalf("first tick should take instant rate: expected: %v, actual: %v", 3, e.Rate())
+	}
+	// a minute without events decays rate to 1/e
+	for i := 0; i < 12; i++ {
+		e.Tick()
+	}
+	if math.Abs(e.Rate()-3/math.E) > 1e-9 {
+		t.Fatalf("Inconsistent decay: expected: %v, actual: %v", 3/math.E, e.Rate())
+	}
+}
+
+func TestMeter(t *testing.T) {
+	clock := newFakeClock()
+	m := newMeter(clock.Now)
+	for i := 0; i < 60; i++ {
+		m.Mark(10)
+		clock.Advance(time.Second)
+	}
+	if m.Count() != 600 || m.RateMean() != 10 {
+		t.Fatalf("Inconsistent count or mean rate: %d %v", m.Count(), m.RateMean())
+	}
+	for _, rate := range []float64{m.Rate1(), m.Rate5(), m.Rate15()} {
+		if math.Abs(rate-10) > 1e-9 {
+			t.Fatalf("Inconsistent rate of steady load: expected: %v, actual: %v", 10, rate)
+		}
+	}
+	clock.Advance(5 * time.Minute)
+	if !(m.Rate1() < m.Rate5() && m.Rate5() < m.Rate15() && m.Rate15() < 10) {
+		t.Fatalf("rates should decay faster on shorter window: %v %v %v",
+			m.Rate1(), m.Rate5(), m.Rate15())
+	}
+}
+
+func TestMeterParallel(t *testing.T) {
+	clock := newFakeClock()
+	m := newMeter(clock.Now)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 1000; n++ {
+				m.Mark(1)
+				m.Rate1()
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for n := 0; n < 10; n++ {
+			clock.Advance(time.Second)
+		}
+	}()
+	wg.Wait()
+	if m.Count() != 8000 {
+		t.Fatalf("Inconsistent count: expected: %d, actual: %d", 8000, m.Count())
+	}
+}
@@ -0,0 +1,331 @@
+// github.com/Vonng/gopher/atomic/number.go provides typed atomic numbers
+package atomic
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+/**************************************************************
+* struct: Int32
+**************************************************************/
+
+// Int32 is an atomic int32, zero value is ready to use
+type Int32 struct{ v int32 }
+
+// NewInt32 creates an Int32 with given initial value
+func NewInt32(value int32) *Int32 {
+	return &Int32{v: value}
+}
+
+// Load atomically loads the value
+func (i *Int32) Load() int32 {
+	return atomic.LoadInt32(&i.v)
+}
+
+// Store atomically stores given value
+func (i *Int32) Store(value int32) {
+	atomic.StoreInt32(&i.v, value)
+}
+
+// Add atomically adds delta and returns the new value
+func (i *Int32) Add(delta int32) int32 {
+	return atomic.AddInt32(&i.v, delta)
+}
+
+// Inc is equivalent to add 1
+func (i *Int32) Inc() int32 {
+	return atomic.AddInt32(&i.v, 1)
+}
+
+// Dec is equivalent to add -1
+func (i *Int32) Dec() int32 {
+	return atomic.AddInt32(&i.v, -1)
+}
+
+// Swap atomically stores new value and returns the old one
+func (i *Int32) Swap(value int32) (old int32) {
+	return atomic.SwapInt32(&i.v, value)
+}
+
+// CompareAndSwap stores new value only if current value equals old
+func (i *Int32) CompareAndSwap(old, new int32) (swapped bool) {
+	return atomic.CompareAndSwapInt32(&i.v, old, new)
+}
+
+// String implements fmt.Stringer
+func (i *Int32) String() string {
+	return strconv.FormatInt(int64(i.Load()), 10)
+}
+
+// MarshalJSON encodes value as json number
+func (i *Int32) MarshalJSON() ([]byte, error) {
+	return json.Marshal(i.Load())
+}
+
+// UnmarshalJSON decodes json number into value
+func (i *Int32) UnmarshalJSON(b []byte) error {
+	var value int32
+	if err := json.Unmarshal(b, &value); err != nil {
+		return err
+	}
+	i.Store(value)
+	return nil
+}
+
+// MarshalText encodes value as decimal text
+func (i *Int32) MarshalText() ([]byte, error) {
+	return []byte(i.String()), nil
+}
+
+// UnmarshalText decodes decimal text into value
+func (i *Int32) UnmarshalText(b []byte) error {
+	value, err := strconv.ParseInt(string(b), 10, 32)
+	if err != nil {
+		return err
+	}
+	i.Store(int32(value))
+	return nil
+}
+
+/**************************************************************
+* struct: Uint64
+**************************************************************/
+
+// Uint64 is an atomic uint64, zero value is ready to use
+type Uint64 struct{ v uint64 }
+
+// NewUint64 creates an Uint64 with given initial value
+func NewUint64(value uint64) *Uint64 {
+	return &Uint64{v: value}
+}
+
+// Load atomically loads the value
+func (u *Uint64) Load() uint64 {
+	return atomic.LoadUint64(&u.v)
+}
+
+// Store atomically stores given value
+func (u *Uint64) Store(value uint64) {
+	atomic.StoreUint64(&u.v, value)
+}
+
+// Add atomically adds delta and returns the new value
+func (u *Uint64) Add(delta uint64) uint64 {
+	return atomic.AddUint64(&u.v, delta)
+}
+
+// Inc is equivalent to add 1
+func (u *Uint64) Inc() uint64 {
+	return atomic.AddUint64(&u.v, 1)
+}
+
+// Dec is equivalent to minus 1, wraps around on zero
+func (u *Uint64) Dec() uint64 {
+	return atomic.AddUint64(&u.v, ^uint64(0))
+}
+
+// Swap atomically stores new value and returns the old one
+func (u *Uint64) Swap(value uint64) (old uint64) {
+	return atomic.SwapUint64(&u.v, value)
+}
+
+// CompareAndSwap stores new value only if current value equals old
+func (u *Uint64) CompareAndSwap(old, new uint64) (swapped bool) {
+	return atomic.CompareAndSwapUint64(&u.v, old, new)
+}
+
+// String implements fmt.Stringer
+func (u *Uint64) String() string {
+	return strconv.FormatUint(u.Load(), 10)
+}
+
+// MarshalJSON encodes value as json number
+func (u *Uint64) MarshalJSON() ([]byte, error) {
+	return json.Marshal(u.Load())
+}
+
+// UnmarshalJSON decodes json number into value
+func (u *Uint64) UnmarshalJSON(b []byte) error {
+	var value uint64
+	if err := json.Unmarshal(b, &value); err != nil {
+		return err
+	}
+	u.Store(value)
+	return nil
+}
+
+// MarshalText encodes value as decimal text
+func (u *Uint64) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+// UnmarshalText decodes decimal text into value
+func (u *Uint64) UnmarshalText(b []byte) error {
+	value, err := strconv.ParseUint(string(b), 10, 64)
+	if err != nil {
+		return err
+	}
+	u.Store(value)
+	return nil
+}
+
+/**************************************************************
+* struct: Float64
+**************************************************************/
+
+// Float64 is an atomic float64 stored as IEEE-754 bits in an uint64
+type Float64 struct{ bits uint64 }
+
+// NewFloat64 creates a Float64 with given initial value
+func NewFloat64(value float64) *Float64 {
+	return &Float64{bits: math.Float64bits(value)}
+}
+
+// Load atomically loads the value
+func (f *Float64) Load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&f.bits))
+}
+
+// Store atomically stores given value
+func (f *Float64) Store(value float64) {
+	atomic.StoreUint64(&f.bits, math.Float64bits(value))
+}
+
+// Add atomically adds delta and returns the new value
+// there is no hardware float add, so it spins on CAS
+func (f *Float64) Add(delta float64) float64 {
+	for {
+		old := atomic.LoadUint64(&f.bits)
+		new := math.Float64frombits(old) + delta
+		if atomic.CompareAndSwapUint64(&f.bits, old, math.Float64bits(new)) {
+			return new
+		}
+	}
+}
+
+// Swap atomically stores new value and returns the old one
+func (f *Float64) Swap(value float64) (old float64) {
+	return math.Float64frombits(atomic.SwapUint64(&f.bits, math.Float64bits(value)))
+}
+
+// CompareAndSwap stores new value only if current value equals old
+// comparison is bitwise, so NaN can be swapped while -0 != +0
+func (f *Float64) CompareAndSwap(old, new float64) (swapped bool) {
+	return atomic.CompareAndSwapUint64(&f.bits, math.Float64bits(old), math.Float64bits(new))
+}
+
+// String implements fmt.Stringer
+func (f *Float64) String() string {
+	return strconv.FormatFloat(f.Load(), 'g', -1, 64)
+}
+
+// MarshalJSON encodes value as json number
+func (f *Float64) MarshalJSON() ([]byte, error) {
+	return json.Marshal(f.Load())
+}
+
+// UnmarshalJSON decodes json number into value
+func (f *Float64) UnmarshalJSON(b []byte) error {
+	var value float64
+	if err := json.Unmarshal(b, &value); err != nil {
+		return err
+	}
+	f.Store(value)
+	return nil
+}
+
+// MarshalText encodes value as shortest decimal text
+func (f *Float64) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+// UnmarshalText decodes decimal text into value
+func (f *Float64) UnmarshalText(b []byte) error {
+	value, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return err
+	}
+	f.Store(value)
+	return nil
+}
+
+/**************************************************************
+* struct: Duration
+**************************************************************/
+
+// Duration is an atomic time.Duration
+// it is marshaled as human readable string like "1m30s"
+type Duration struct{ v int64 }
+
+// NewDuration creates a Duration with given initial value
+func NewDuration(value time.Duration) *Duration {
+	return &Duration{v: int64(value)}
+}
+
+// Load atomically loads the value
+func (d *Duration) Load() time.Duration {
+	return time.Duration(atomic.LoadInt64(&d.v))
+}
+
+// Store atomically stores given value
+func (d *Duration) Store(value time.Duration) {
+	atomic.StoreInt64(&d.v, int64(value))
+}
+
+// Add atomically adds delta and returns the new value
+func (d *Duration) Add(delta time.Duration) time.Duration {
+	return time.Duration(atomic.AddInt64(&d.v, int64(delta)))
+}
+
+// Swap atomically stores new value and returns the old one
+func (d *Duration) Swap(value time.Duration) (old time.Duration) {
+	return time.Duration(atomic.SwapInt64(&d.v, int64(value)))
+}
+
+// CompareAndSwap stores new value only if current value equals old
+func (d *Duration) CompareAndSwap(old, new time.Duration) (swapped bool) {
+	return atomic.CompareAndSwapInt64(&d.v, int64(old), int64(new))
+}
+
+// String implements fmt.Stringer
+func (d *Duration) String() string {
+	return d.Load().String()
+}
+
+// MarshalJSON encodes value as json string like "1m30s"
+func (d *Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON accepts both duration string and integer nanoseconds
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var ns int64
+		if err := json.Unmarshal(b, &ns); err != nil {
+			return err
+		}
+		d.Store(time.Duration(ns))
+		return nil
+	}
+	return d.UnmarshalText([]byte(s))
+}
+
+// MarshalText encodes value as duration string
+func (d *Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText decodes duration string via time.ParseDuration
+func (d *Duration) UnmarshalText(b []byte) error {
+	value, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	d.Store(value)
+	return nil
+}
@@ -0,0 +1,97 @@
+package atomic
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInt32(t *testing.T) {
+	i := NewInt32(1)
+	if i.Add(2) != 3 || i.Inc() != 4 || i.Dec() != 3 {
+		t.Fatalf("Inconsistent Add/Inc/Dec result: %d", i.Load())
+	}
+	if old := i.Swap(10); old != 3 {
+		t.Fatalf("Inconsistent swap result: expected: %d, actual: %d", 3, old)
+	}
+	if i.CompareAndSwap(3, 20) {
+		t.Fatal("CompareAndSwap succeed with stale old value!")
+	}
+	if !i.CompareAndSwap(10, 20) || i.Load() != 20 {
+		t.Fatalf("CompareAndSwap failed: %d", i.Load())
+	}
+	if err := i.UnmarshalText([]byte("42")); err != nil || i.Load() != 42 {
+		t.Fatalf("UnmarshalText failed: %v %d", err, i.Load())
+	}
+	if err := i.UnmarshalText([]byte("4294967296")); err == nil {
+		t.Fatal("UnmarshalText accept overflowed int32!")
+	}
+}
+
+func TestUint64Parallel(t *testing.T) {
+	var u Uint64
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 1000; n++ {
+				u.Inc()
+			}
+		}()
+	}
+	wg.Wait()
+	if u.Load() != 8000 {
+		t.Fatalf("Inconsistent total: expected: %d, actual: %d", 8000, u.Load())
+	}
+}
+
+func TestFloat64Parallel(t *testing.T) {
+	f := NewFloat64(0.5)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 1000; n++ {
+				f.Add(0.25)
+			}
+		}()
+	}
+	wg.Wait()
+	if f.Load() != 2000.5 {
+		t.Fatalf("Inconsistent total: expected: %v, actual: %v", 2000.5, f.Load())
+	}
+	if !f.CompareAndSwap(2000.5, 1.5) || f.String() != "1.5" {
+		t.Fatalf("CompareAndSwap failed: %v", f.Load())
+	}
+}
+
+func TestMarshalNumbers(t *testing.T) {
+	type config struct {
+		Workers Int32    `json:"workers"`
+		Total   Uint64   `json:"total"`
+		Ratio   Float64  `json:"ratio"`
+		Timeout Duration `json:"timeout"`
+	}
+	var c config
+	in := `{"workers":8,"total":18446744073709551615,"ratio":0.75,"timeout":"1m30s"}`
+	if err := json.Unmarshal([]byte(in), &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Workers.Load() != 8 || c.Total.Load() != 1<<64-1 ||
+		c.Ratio.Load() != 0.75 || c.Timeout.Load() != 90*time.Second {
+		t.Fatalf("Inconsistent unmarshal result: %+v", &c)
+	}
+	out, err := json.Marshal(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != in {
+		t.Fatalf("Inconsistent marshal result: expected: %s, actual: %s", in, out)
+	}
+	if err := c.Timeout.UnmarshalJSON([]byte("1000")); err != nil || c.Timeout.Load() != time.Microsecond {
+		t.Fatalf("Duration doesn't accept integer nanoseconds: %v %v", err, c.Timeout.Load())
+	}
+}
@@ -0,0 +1,58 @@
+// github.com/Vonng/gopher/atomic/once.go provides a retryable once
+package atomic
+
+import "sync"
+
+/**************************************************************
+* struct: OnceErr
+**************************************************************/
+
+// OnceErr is like sync.Once, but only a successful (nil error) call
+// counts. A failed call will be retried by next Do, which fits lazy
+// initialization such as loading a cache from database.
+// zero value is ready to use
+type OnceErr struct {
+	done AtomicBool
+	lock sync.Mutex
+}
+
+// Do calls fn if no previous call succeeded. concurrent callers
+// block until the running one finish. returns fn's error,
+// or nil if already done. panic in fn is returned as *PanicError
+func (o *OnceErr) Do(fn func() error) error {
+	if o.done.Get() {
+		return nil
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.done.Get() {
+		return nil
+	}
+	if err := o.call(fn); err != nil {
+		return err
+	}
+	o.done.Set(true)
+	return nil
+}
+
+// call runs fn with panic converted into error
+func (o *OnceErr) call(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = newPanicError(r)
+		}
+	}()
+	return fn()
+}
+
+// Done reports whether a call has succeeded
+func (o *OnceErr) Done() bool {
+	return o.done.Get()
+}
+
+// Reset makes next Do call fn again, e.g. to force reload a cache
+func (o *OnceErr) Reset() {
+	o.lock.Lock()
+	o.done.Set(false)
+	o.lock.Unlock()
+}
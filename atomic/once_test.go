@@ -0,0 +1,60 @@
+package atomic
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestOnceErr(t *testing.T) {
+	var once OnceErr
+	var calls int
+	fail := errors.New("fail")
+	fn := func() error {
+		calls++
+		if calls < 3 {
+			return fail
+		}
+		return nil
+	}
+	for i := 0; i < 2; i++ {
+		if err := once.Do(fn); err != fail || once.Done() {
+			t.Fatalf("failed call should be retried: %v %v", err, once.Done())
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if err := once.Do(fn); err != nil || !once.Done() {
+			t.Fatalf("Inconsistent result: %v %v", err, once.Done())
+		}
+	}
+	if calls != 3 {
+		t.Fatalf("Inconsistent calls: expected: %d, actual: %d", 3, calls)
+	}
+	once.Reset()
+	once.Do(fn)
+	if calls != 4 {
+		t.Fatalf("Reset should make fn called again: %d", calls)
+	}
+	once.Reset()
+	var pe *PanicError
+	if err := once.Do(func() error { panic("boom") }); !errors.As(err, &pe) || once.Done() {
+		t.Fatalf("panic should be returned as PanicError: %v", err)
+	}
+}
+
+func TestOnceErrParallel(t *testing.T) {
+	var once OnceErr
+	var calls Int32
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			once.Do(func() error { calls.Inc(); return nil })
+		}()
+	}
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Fatalf("Inconsistent calls: expected: %d, actual: %d", 1, calls.Load())
+	}
+}
@@ -0,0 +1,104 @@
+// github.com/Vonng/gopher/atomic/rolling.go provides sliding window counter
+package atomic
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// bucketResetting marks a bucket being recycled by another goroutine
+const bucketResetting = -1
+
+/**************************************************************
+* struct: RollingCounter
+**************************************************************/
+
+// rollingBucket holds count of one time slice, epoch is the
+// slice index (unix nano / width) the count belongs to
+type rollingBucket struct {
+	epoch int64
+	count int64
+}
+
+// RollingCounter counts events in a sliding window, e.g. last minute.
+// window is split into a ring of buckets, a bucket is lazily recycled
+// when a later time slice maps onto it. So resolution is window/buckets
+type RollingCounter struct {
+	// width : time span of each bucket in nanoseconds
+	width int64
+	// window : width * len(buckets)
+	window time.Duration
+	// buckets : ring of time slices
+	buckets []rollingBucket
+	// clock : time source, replaceable in test
+	clock func() time.Time
+}
+
+// NewRollingCounter create a counter over last window split into n buckets
+// window must be divisible into buckets of at least 1ns
+func NewRollingCounter(window time.Duration, buckets int) (*RollingCounter, error) {
+	if buckets <= 0 || window < time.Duration(buckets) {
+		return nil, ErrInvalidWindow
+	}
+	width := int64(window) / int64(buckets)
+	return &RollingCounter{
+		width:   width,
+		window:  time.Duration(width * int64(buckets)),
+		buckets: make([]rollingBucket, buckets),
+		clock:   time.Now,
+	}, nil
+}
+
+// Window returns the effective window size
+func (rc *RollingCounter) Window() time.Duration {
+	return rc.window
+}
+
+// Mark is equivalent to add 1
+func (rc *RollingCounter) Mark() {
+	rc.Add(1)
+}
+
+// Add will add n events to current time slice
+func (rc *RollingCounter) Add(n int64) {
+	epoch := rc.clock().UnixNano() / rc.width
+	b := &rc.buckets[epoch%int64(len(rc.buckets))]
+	for {
+		switch e := atomic.LoadInt64(&b.epoch); {
+		case e == epoch:
+			atomic.AddInt64(&b.count, n)
+			return
+		case e == bucketResetting:
+			runtime.Gosched()
+		case e > epoch:
+			// caller stalled longer than a whole window, drop it
+			return
+		default:
+			// first one reaching new slice recycles the bucket
+			if atomic.CompareAndSwapInt64(&b.epoch, e, bucketResetting) {
+				atomic.StoreInt64(&b.count, n)
+				atomic.StoreInt64(&b.epoch, epoch)
+				return
+			}
+		}
+	}
+}
+
+// Count returns number of events in the window
+func (rc *RollingCounter) Count() (sum int64) {
+	epoch := rc.clock().UnixNano() / rc.width
+	oldest := epoch - int64(len(rc.buckets))
+	for i := range rc.buckets {
+		b := &rc.buckets[i]
+		if e := atomic.LoadInt64(&b.epoch); e > oldest && e <= epoch {
+			sum += atomic.LoadInt64(&b.count)
+		}
+	}
+	return
+}
+
+// Rate returns events per second averaged over the window
+func (rc *RollingCounter) Rate() float64 {
+	return float64(rc.Count()) / rc.window.Seconds()
+}
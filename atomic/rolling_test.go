@@ -0,0 +1,84 @@
+package atomic
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced time source for tests
+type fakeClock struct{ t Time }
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: *NewTime(time.Unix(1500000000, 0))}
+}
+
+func (c *fakeClock) Now() time.Time { return c.t.Load() }
+
+func (c *fakeClock) Advance(d time.Duration) { c.t.Store(c.t.Load().Add(d)) }
+
+func TestRollingCounterNew(t *testing.T) {
+	if _, err := NewRollingCounter(time.Minute, 0); err != ErrInvalidWindow {
+		t.Fatal("No error when new a rolling counter with zero buckets!")
+	}
+	if _, err := NewRollingCounter(5, 10); err != ErrInvalidWindow {
+		t.Fatal("No error when new a rolling counter with window < buckets!")
+	}
+	rc, err := NewRollingCounter(time.Minute, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.Window() > time.Minute || rc.Window() < time.Minute-7 {
+		t.Fatalf("Inconsistent window: %s", rc.Window())
+	}
+}
+
+func TestRollingCounterSlide(t *testing.T) {
+	clock := newFakeClock()
+	rc, _ := NewRollingCounter(time.Minute, 6)
+	rc.clock = clock.Now
+	for i := 0; i < 6; i++ {
+		rc.Add(10)
+		clock.Advance(10 * time.Second)
+	}
+	// first bucket slides out
+	if rc.Count() != 50 {
+		t.Fatalf("Inconsistent count: expected: %d, actual: %d", 50, rc.Count())
+	}
+	rc.Mark()
+	if rc.Count() != 51 || rc.Rate() != 51.0/60 {
+		t.Fatalf("Inconsistent count after recycle: %d %v", rc.Count(), rc.Rate())
+	}
+	clock.Advance(time.Hour)
+	if rc.Count() != 0 {
+		t.Fatalf("Stale buckets still counted: %d", rc.Count())
+	}
+}
+
+func TestRollingCounterParallel(t *testing.T) {
+	clock := newFakeClock()
+	rc, _ := NewRollingCounter(time.Second, 10)
+	rc.clock = clock.Now
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 1000; n++ {
+				rc.Mark()
+				rc.Count()
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for n := 0; n < 5; n++ {
+			clock.Advance(100 * time.Millisecond)
+		}
+	}()
+	wg.Wait()
+	if rc.Count() != 8000 {
+		t.Fatalf("Inconsistent count: expected: %d, actual: %d", 8000, rc.Count())
+	}
+}
@@ -0,0 +1,114 @@
+// github.com/Vonng/gopher/atomic/singleflight.go provides duplicate call suppression
+package atomic
+
+import (
+	"context"
+	"sync"
+)
+
+/**************************************************************
+* struct: Group
+**************************************************************/
+
+// flight is an in-flight or completed call of Group
+type flight[V any] struct {
+	// done : closed when fn returns
+	done chan struct{}
+	val  V
+	err  error
+	// waiters : callers still waiting, fn is cancelled when drops to 0
+	waiters int
+	// dups : number of callers joined this flight
+	dups   int
+	cancel context.CancelFunc
+}
+
+// Group collapses concurrent calls with same key into one execution,
+// e.g. many cache misses on one key result in only one db query.
+// zero value is ready to use
+type Group[K comparable, V any] struct {
+	lock  sync.Mutex
+	calls map[K]*flight[V]
+}
+
+// Do executes fn for key, if there is already one in-flight for
+// same key, wait for it and share its result instead.
+// shared reports whether result was given to multiple callers
+func (g *Group[K, V]) Do(key K, fn func() (V, error)) (v V, err error, shared bool) {
+	return g.DoContext(context.Background(), key, func(context.Context) (V, error) {
+		return fn()
+	})
+}
+
+// DoContext is like Do, but caller stops waiting when ctx is done.
+// fn runs in its own goroutine with a context which is cancelled
+// only when all callers waiting on it are gone, so one impatient
+// caller won't break the result of others.
+// panic in fn is returned as *PanicError to all callers
+func (g *Group[K, V]) DoContext(ctx context.Context, key K, fn func(context.Context) (V, error)) (v V, err error, shared bool) {
+	g.lock.Lock()
+	if g.calls == nil {
+		g.calls = make(map[K]*flight[V])
+	}
+	if f, ok := g.calls[key]; ok {
+		f.dups++
+		f.waiters++
+		g.lock.Unlock()
+		return g.wait(ctx, key, f)
+	}
+	fctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	f := &flight[V]{done: make(chan struct{}), waiters: 1, cancel: cancel}
+	g.calls[key] = f
+	g.lock.Unlock()
+
+	go g.run(fctx, key, f, fn)
+	return g.wait(ctx, key, f)
+}
+
+// run executes fn and publishes result
+func (g *Group[K, V]) run(ctx context.Context, key K, f *flight[V], fn func(context.Context) (V, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			f.err = newPanicError(r)
+		}
+		g.lock.Lock()
+		if g.calls[key] == f {
+			delete(g.calls, key)
+		}
+		g.lock.Unlock()
+		f.cancel()
+		close(f.done)
+	}()
+	f.val, f.err = fn(ctx)
+}
+
+// wait blocks until flight is done or ctx is done
+func (g *Group[K, V]) wait(ctx context.Context, key K, f *flight[V]) (v V, err error, shared bool) {
+	select {
+	case <-f.done:
+		g.lock.Lock()
+		shared = f.dups > 0
+		g.lock.Unlock()
+		return f.val, f.err, shared
+	case <-ctx.Done():
+		g.lock.Lock()
+		if f.waiters--; f.waiters == 0 {
+			// nobody cares anymore: abort fn, later callers start over
+			f.cancel()
+			if g.calls[key] == f {
+				delete(g.calls, key)
+			}
+		}
+		shared = f.dups > 0
+		g.lock.Unlock()
+		return v, ctx.Err(), shared
+	}
+}
+
+// Forget makes later calls of key execute fn again instead of
+// joining the in-flight one. current waiters still get its result
+func (g *Group[K, V]) Forget(key K) {
+	g.lock.Lock()
+	delete(g.calls, key)
+	g.lock.Unlock()
+}
@@ -0,0 +1,126 @@
+package atomic
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGroupDo(t *testing.T) {
+	var g Group[string, int]
+	v, err, shared := g.Do("key", func() (int, error) { return 42, nil })
+	if v != 42 || err != nil || shared {
+		t.Fatalf("Inconsistent result: %v %v %v", v, err, shared)
+	}
+	want := errors.New("boom")
+	if _, err, _ := g.Do("key", func() (int, error) { return 0, want }); err != want {
+		t.Fatalf("Inconsistent error: expected: %v, actual: %v", want, err)
+	}
+	_, err, _ = g.Do("key", func() (int, error) { panic("boom") })
+	var pe *PanicError
+	if !errors.As(err, &pe) || pe.Value != "boom" {
+		t.Fatalf("panic should be returned as PanicError: %v", err)
+	}
+}
+
+func TestGroupDoDuplicate(t *testing.T) {
+	var g Group[string, int]
+	var calls Int32
+	release := make(chan struct{})
+	fn := func() (int, error) {
+		calls.Inc()
+		<-release
+		return 1, nil
+	}
+	var wg sync.WaitGroup
+	var sharedCount Int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err, shared := g.Do("key", fn)
+			if v != 1 || err != nil {
+				t.Errorf("Inconsistent result: %v %v", v, err)
+			}
+			if shared {
+				sharedCount.Inc()
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls.Load() != 1 || sharedCount.Load() != 10 {
+		t.Fatalf("calls not collapsed: calls: %d, shared: %d", calls.Load(), sharedCount.Load())
+	}
+}
+
+func TestGroupForget(t *testing.T) {
+	var g Group[string, int]
+	release := make(chan struct{})
+	go g.Do("key", func() (int, error) { <-release; return 1, nil })
+	time.Sleep(10 * time.Millisecond)
+	g.Forget("key")
+	v, _, _ := g.Do("key", func() (int, error) { return 2, nil })
+	close(release)
+	if v != 2 {
+		t.Fatalf("forgotten key still joined old call: %d", v)
+	}
+}
+
+func TestGroupDoContext(t *testing.T) {
+	var g Group[string, int]
+	started := make(chan struct{})
+	aborted := make(chan struct{})
+	fn := func(ctx context.Context) (int, error) {
+		close(started)
+		<-ctx.Done()
+		close(aborted)
+		return 0, ctx.Err()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { <-started; cancel() }()
+	if _, err, _ := g.DoContext(ctx, "key", fn); err != context.Canceled {
+		t.Fatalf("Inconsistent error: expected: %v, actual: %v", context.Canceled, err)
+	}
+	select {
+	case <-aborted:
+	case <-time.After(time.Second):
+		t.Fatal("fn not cancelled after all waiters gone")
+	}
+	v, err, _ := g.DoContext(context.Background(), "key", func(context.Context) (int, error) { return 3, nil })
+	if v != 3 || err != nil {
+		t.Fatalf("cancelled call not removed: %v %v", v, err)
+	}
+}
+
+// one waiter giving up must not cancel fn for others
+func TestGroupDoContextPartialCancel(t *testing.T) {
+	var g Group[string, int]
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (int, error) {
+		select {
+		case <-release:
+			return 1, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	result := make(chan error, 1)
+	go func() {
+		_, err, _ := g.DoContext(context.Background(), "key", fn)
+		result <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err, shared := g.DoContext(ctx, "key", fn); err != context.DeadlineExceeded || !shared {
+		t.Fatalf("Inconsistent result: %v %v", err, shared)
+	}
+	close(release)
+	if err := <-result; err != nil {
+		t.Fatalf("patient waiter got error: %v", err)
+	}
+}
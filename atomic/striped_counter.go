@@ -0,0 +1,132 @@
+package atomic
+
+import (
+	"math/rand/v2"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// cacheLineSize is the assumed cache line size of common platforms
+const cacheLineSize = 64
+
+/**************************************************************
+* struct: StripedCounter
+**************************************************************/
+
+// stripedCell is an int64 padded to occupy a whole cache line
+// so that neighbouring cells never share (and bounce) a line
+type stripedCell struct {
+	v int64
+	_ [cacheLineSize - 8]byte
+}
+
+// StripedCounter spreads writes over multiple cache line padded cells
+// and sums them on read. it trades read cost for write scalability.
+// It implements Counter, but Add/Inc/Dec pay a full Get to return the
+// total, so writes only scale as far as the read lock and sum allow
+type StripedCounter struct {
+	// cells : write shards, length is power of 2
+	cells []stripedCell
+	// mask : len(cells) - 1, used to pick a cell
+	mask uint32
+	// lock : Get and Set/Swap/CompareAndSwap/AddClamped
+	// must not interleave with each other, while Add never takes it
+	lock sync.RWMutex
+}
+
+// StripedCounter is a Counter
+var _ Counter = (*StripedCounter)(nil)
+
+// NewStripedCounter create a high-contention friendly counter
+// with one cell per P (rounded up to power of 2)
+func NewStripedCounter() *StripedCounter {
+	n := 1
+	for n < runtime.GOMAXPROCS(0) {
+		n <<= 1
+	}
+	return &StripedCounter{
+		cells: make([]stripedCell, n),
+		mask:  uint32(n - 1),
+	}
+}
+
+// cell picks a random cell. math/rand/v2 top-level functions
+// use per-thread state, so picking itself is contention free
+func (self *StripedCounter) cell() *int64 {
+	return &self.cells[rand.Uint32()&self.mask].v
+}
+
+// collapse moves all cells' value out and returns the sum.
+// concurrent Add is never lost: it either lands before a cell is
+// swapped and counted, or after and stays in that cell.
+// caller must hold the write lock
+func (self *StripedCounter) collapse() (sum int64) {
+	for i := range self.cells {
+		sum += atomic.SwapInt64(&self.cells[i].v, 0)
+	}
+	return
+}
+
+// Set to given value
+func (self *StripedCounter) Set(value int64) {
+	self.Swap(value)
+}
+
+// Add will add given num to a random cell and return the total.
+// The total is a full Get: it sums every cell under the read lock,
+// so it may include concurrent adds and costs O(cells) per call
+func (self *StripedCounter) Add(delta int64) int64 {
+	atomic.AddInt64(self.cell(), delta)
+	return self.Get()
+}
+
+// Get will sum up all cells
+func (self *StripedCounter) Get() (sum int64) {
+	self.lock.RLock()
+	for i := range self.cells {
+		sum += atomic.LoadInt64(&self.cells[i].v)
+	}
+	self.lock.RUnlock()
+	return
+}
+
+// Inc is equivalent to add 1
+func (self *StripedCounter) Inc() int64 {
+	return self.Add(1)
+}
+
+// Dec is equivalent to add -1
+func (self *StripedCounter) Dec() int64 {
+	return self.Add(-1)
+}
+
+// CompareAndSwap set counter to new only if current total is old
+func (self *StripedCounter) CompareAndSwap(old, new int64) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if sum := self.collapse(); sum != old {
+		atomic.AddInt64(&self.cells[0].v, sum)
+		return false
+	}
+	atomic.AddInt64(&self.cells[0].v, new)
+	return true
+}
+
+// Swap set counter to given value and return the old total
+func (self *StripedCounter) Swap(value int64) int64 {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	old := self.collapse()
+	atomic.AddInt64(&self.cells[0].v, value)
+	return old
+}
+
+// AddClamped add delta to the total, result is clamped into [min, max]
+func (self *StripedCounter) AddClamped(delta, min, max int64) int64 {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	new := clamp(self.collapse(), delta, min, max)
+	atomic.AddInt64(&self.cells[0].v, new)
+	return new
+}
@@ -0,0 +1,118 @@
+package atomic
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestStripedCounter(t *testing.T) {
+	c := NewStripedCounter()
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 1000; n++ {
+				c.Inc()
+			}
+			c.Dec()
+		}()
+	}
+	wg.Wait()
+	if c.Get() != 16*999 {
+		t.Fatalf("Inconsistent total: expected: %d, actual: %d", 16*999, c.Get())
+	}
+	if old := c.Swap(5); old != 16*999 || c.Get() != 5 {
+		t.Fatalf("Inconsistent swap result: old: %d, new: %d", old, c.Get())
+	}
+	if c.CompareAndSwap(4, 1) || c.Get() != 5 {
+		t.Fatalf("CompareAndSwap succeed with stale old value: %d", c.Get())
+	}
+	if !c.CompareAndSwap(5, 1) || c.Get() != 1 {
+		t.Fatalf("CompareAndSwap failed: %d", c.Get())
+	}
+	if v := c.AddClamped(100, 0, 10); v != 10 || c.Get() != 10 {
+		t.Fatalf("Inconsistent AddClamped result: %d %d", v, c.Get())
+	}
+	c.Set(0)
+	if c.Get() != 0 {
+		t.Fatalf("Set failed: %d", c.Get())
+	}
+	if v := c.Add(3); v != 3 {
+		t.Fatalf("Inconsistent Add result: expected: %d, actual: %d", 3, v)
+	}
+	if v := c.Inc(); v != 4 {
+		t.Fatalf("Inconsistent Inc result: expected: %d, actual: %d", 4, v)
+	}
+	if v := c.Dec(); v != 3 {
+		t.Fatalf("Inconsistent Dec result: expected: %d, actual: %d", 3, v)
+	}
+}
+
+// Add returns a total which includes its own delta, even under contention
+func TestStripedCounterAddTotal(t *testing.T) {
+	var c Counter = NewStripedCounter()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := int64(1); n <= 1000; n++ {
+				if v := c.Inc(); v < n {
+					t.Errorf("Inconsistent Inc result: expected at least: %d, actual: %d", n, v)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if c.Get() != 8000 {
+		t.Fatalf("Inconsistent total: expected: %d, actual: %d", 8000, c.Get())
+	}
+}
+
+// adds running concurrently with Swap must never be lost
+func TestStripedCounterSwapParallel(t *testing.T) {
+	c := NewStripedCounter()
+	var swapped Counter = NewCounter()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 1000; n++ {
+				c.Inc()
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for n := 0; n < 100; n++ {
+			swapped.Add(c.Swap(0))
+		}
+	}()
+	wg.Wait()
+	if total := swapped.Get() + c.Get(); total != 8000 {
+		t.Fatalf("Inconsistent total: expected: %d, actual: %d", 8000, total)
+	}
+}
+
+func benchmarkIncParallel(b *testing.B, inc func()) {
+	b.SetParallelism(16)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			inc()
+		}
+	})
+}
+
+func BenchmarkCounterParallel(b *testing.B) {
+	c := NewCounter()
+	benchmarkIncParallel(b, func() { c.Inc() })
+}
+
+func BenchmarkStripedCounterParallel(b *testing.B) {
+	c := NewStripedCounter()
+	benchmarkIncParallel(b, func() { c.Inc() })
+}
@@ -0,0 +1,321 @@
+// github.com/Vonng/gopher/atomic/value.go provides generic atomic values
+package atomic
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+/**************************************************************
+* struct: Value
+**************************************************************/
+
+// Value holds a T atomically, zero value holds zero T
+// each Store allocates a new copy, so it fits read-mostly data
+// such as hot-reloaded configuration
+type Value[T comparable] struct {
+	p atomic.Pointer[T]
+}
+
+// NewValue creates a Value with given initial value
+func NewValue[T comparable](value T) *Value[T] {
+	v := &Value[T]{}
+	v.Store(value)
+	return v
+}
+
+// Load atomically loads the value
+func (v *Value[T]) Load() T {
+	if p := v.p.Load(); p != nil {
+		return *p
+	}
+	var zero T
+	return zero
+}
+
+// Store atomically stores given value
+func (v *Value[T]) Store(value T) {
+	v.p.Store(&value)
+}
+
+// Swap atomically stores new value and returns the old one
+func (v *Value[T]) Swap(value T) (old T) {
+	if p := v.p.Swap(&value); p != nil {
+		return *p
+	}
+	return old
+}
+
+// CompareAndSwap stores new value only if current value == old
+func (v *Value[T]) CompareAndSwap(old, new T) (swapped bool) {
+	for {
+		p := v.p.Load()
+		var cur T
+		if p != nil {
+			cur = *p
+		}
+		if cur != old {
+			return false
+		}
+		if v.p.CompareAndSwap(p, &new) {
+			return true
+		}
+	}
+}
+
+// String implements fmt.Stringer
+func (v *Value[T]) String() string {
+	return fmt.Sprint(v.Load())
+}
+
+// MarshalJSON encodes the holding value with encoding/json
+func (v *Value[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.Load())
+}
+
+// UnmarshalJSON decodes json into a new T and stores it
+func (v *Value[T]) UnmarshalJSON(b []byte) error {
+	var value T
+	if err := json.Unmarshal(b, &value); err != nil {
+		return err
+	}
+	v.Store(value)
+	return nil
+}
+
+// MarshalText use T's own TextMarshaler if any,
+// raw string for string kind, and json for others
+func (v *Value[T]) MarshalText() ([]byte, error) {
+	return marshalText(v.Load())
+}
+
+// UnmarshalText is the reverse of MarshalText
+func (v *Value[T]) UnmarshalText(b []byte) error {
+	var value T
+	if err := unmarshalText(b, &value); err != nil {
+		return err
+	}
+	v.Store(value)
+	return nil
+}
+
+/**************************************************************
+* struct: String
+**************************************************************/
+
+// String is an atomic string
+type String struct{ Value[string] }
+
+// NewString creates a String with given initial value
+func NewString(value string) *String {
+	s := &String{}
+	s.Store(value)
+	return s
+}
+
+// String implements fmt.Stringer
+func (s *String) String() string {
+	return s.Load()
+}
+
+/**************************************************************
+* struct: Pointer
+**************************************************************/
+
+// Pointer is an atomic *T
+// unlike Value, CompareAndSwap compares pointer address rather than content
+type Pointer[T any] struct {
+	p atomic.Pointer[T]
+}
+
+// NewPointer creates a Pointer with given initial pointer
+func NewPointer[T any](ptr *T) *Pointer[T] {
+	p := &Pointer[T]{}
+	p.Store(ptr)
+	return p
+}
+
+// Load atomically loads the pointer
+func (p *Pointer[T]) Load() *T {
+	return p.p.Load()
+}
+
+// Store atomically stores given pointer
+func (p *Pointer[T]) Store(ptr *T) {
+	p.p.Store(ptr)
+}
+
+// Swap atomically stores new pointer and returns the old one
+func (p *Pointer[T]) Swap(ptr *T) (old *T) {
+	return p.p.Swap(ptr)
+}
+
+// CompareAndSwap stores new pointer only if current pointer is old
+func (p *Pointer[T]) CompareAndSwap(old, new *T) (swapped bool) {
+	return p.p.CompareAndSwap(old, new)
+}
+
+// String implements fmt.Stringer
+func (p *Pointer[T]) String() string {
+	if ptr := p.Load(); ptr != nil {
+		return fmt.Sprint(*ptr)
+	}
+	return "<nil>"
+}
+
+// MarshalJSON encodes the pointee, nil pointer is encoded as null
+func (p *Pointer[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.Load())
+}
+
+// UnmarshalJSON decodes json into a newly allocated T and stores it
+// json null stores a nil pointer
+func (p *Pointer[T]) UnmarshalJSON(b []byte) error {
+	var ptr *T
+	if err := json.Unmarshal(b, &ptr); err != nil {
+		return err
+	}
+	p.Store(ptr)
+	return nil
+}
+
+// MarshalText encodes the pointee, nil pointer is encoded as empty text
+func (p *Pointer[T]) MarshalText() ([]byte, error) {
+	ptr := p.Load()
+	if ptr == nil {
+		return []byte{}, nil
+	}
+	return marshalText(*ptr)
+}
+
+// UnmarshalText decodes text into a newly allocated T and stores it
+func (p *Pointer[T]) UnmarshalText(b []byte) error {
+	ptr := new(T)
+	if err := unmarshalText(b, ptr); err != nil {
+		return err
+	}
+	p.Store(ptr)
+	return nil
+}
+
+/**************************************************************
+* struct: Time
+**************************************************************/
+
+// Time is an atomic time.Time
+type Time struct {
+	p atomic.Pointer[time.Time]
+}
+
+// NewTime creates a Time with given initial value
+func NewTime(value time.Time) *Time {
+	t := &Time{}
+	t.Store(value)
+	return t
+}
+
+// Load atomically loads the value, zero time if never stored
+func (t *Time) Load() time.Time {
+	if p := t.p.Load(); p != nil {
+		return *p
+	}
+	return time.Time{}
+}
+
+// Store atomically stores given value
+func (t *Time) Store(value time.Time) {
+	t.p.Store(&value)
+}
+
+// Swap atomically stores new value and returns the old one
+func (t *Time) Swap(value time.Time) (old time.Time) {
+	if p := t.p.Swap(&value); p != nil {
+		return *p
+	}
+	return
+}
+
+// CompareAndSwap stores new value only if current value
+// represents the same instant as old (time.Time.Equal)
+func (t *Time) CompareAndSwap(old, new time.Time) (swapped bool) {
+	for {
+		p := t.p.Load()
+		var cur time.Time
+		if p != nil {
+			cur = *p
+		}
+		if !cur.Equal(old) {
+			return false
+		}
+		if t.p.CompareAndSwap(p, &new) {
+			return true
+		}
+	}
+}
+
+// String implements fmt.Stringer
+func (t *Time) String() string {
+	return t.Load().String()
+}
+
+// MarshalJSON encodes value as RFC 3339 json string
+func (t *Time) MarshalJSON() ([]byte, error) {
+	return t.Load().MarshalJSON()
+}
+
+// UnmarshalJSON decodes RFC 3339 json string into value
+func (t *Time) UnmarshalJSON(b []byte) error {
+	var value time.Time
+	if err := value.UnmarshalJSON(b); err != nil {
+		return err
+	}
+	t.Store(value)
+	return nil
+}
+
+// MarshalText encodes value as RFC 3339 text
+func (t *Time) MarshalText() ([]byte, error) {
+	return t.Load().MarshalText()
+}
+
+// UnmarshalText decodes RFC 3339 text into value
+func (t *Time) UnmarshalText(b []byte) error {
+	var value time.Time
+	if err := value.UnmarshalText(b); err != nil {
+		return err
+	}
+	t.Store(value)
+	return nil
+}
+
+/**************************************************************
+* text marshal helpers
+**************************************************************/
+
+// marshalText prefer encoding.TextMarshaler, then string, then json
+func marshalText(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case encoding.TextMarshaler:
+		return v.MarshalText()
+	case string:
+		return []byte(v), nil
+	default:
+		return json.Marshal(v)
+	}
+}
+
+// unmarshalText is the reverse of marshalText, ptr must be a pointer
+func unmarshalText(b []byte, ptr interface{}) error {
+	switch p := ptr.(type) {
+	case encoding.TextUnmarshaler:
+		return p.UnmarshalText(b)
+	case *string:
+		*p = string(b)
+		return nil
+	default:
+		return json.Unmarshal(b, p)
+	}
+}
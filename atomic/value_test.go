@@ -0,0 +1,105 @@
+package atomic
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestValue(t *testing.T) {
+	type endpoint struct {
+		Host string
+		Port int
+	}
+	var v Value[endpoint]
+	if v.Load() != (endpoint{}) {
+		t.Fatalf("Zero Value should hold zero T: %v", v.Load())
+	}
+	a, b := endpoint{"a", 1}, endpoint{"b", 2}
+	if !v.CompareAndSwap(endpoint{}, a) {
+		t.Fatal("CompareAndSwap on zero value failed!")
+	}
+	if v.CompareAndSwap(b, a) {
+		t.Fatal("CompareAndSwap succeed with stale old value!")
+	}
+	if old := v.Swap(b); old != a || v.Load() != b {
+		t.Fatalf("Inconsistent swap result: %v %v", old, v.Load())
+	}
+	data, _ := json.Marshal(&v)
+	var w Value[endpoint]
+	if err := json.Unmarshal(data, &w); err != nil || w.Load() != b {
+		t.Fatalf("json round trip failed: %v %v", err, w.Load())
+	}
+}
+
+func TestValueCompareAndSwapParallel(t *testing.T) {
+	v := NewValue(0)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 500; n++ {
+				for {
+					old := v.Load()
+					if v.CompareAndSwap(old, old+1) {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if v.Load() != 4000 {
+		t.Fatalf("Inconsistent total: expected: %d, actual: %d", 4000, v.Load())
+	}
+}
+
+func TestString(t *testing.T) {
+	s := NewString("foo")
+	if s.String() != "foo" {
+		t.Fatalf("Inconsistent value: %s", s)
+	}
+	if err := s.UnmarshalText([]byte("bar")); err != nil || s.Load() != "bar" {
+		t.Fatalf("UnmarshalText failed: %v %s", err, s)
+	}
+	data, _ := json.Marshal(s)
+	if string(data) != `"bar"` {
+		t.Fatalf("Inconsistent json: %s", data)
+	}
+}
+
+func TestPointer(t *testing.T) {
+	var p Pointer[int]
+	if data, _ := json.Marshal(&p); string(data) != "null" {
+		t.Fatalf("nil Pointer should marshal as null: %s", data)
+	}
+	x, y := 1, 1
+	p.Store(&x)
+	if p.CompareAndSwap(&y, &y) {
+		t.Fatal("CompareAndSwap should compare address rather than content!")
+	}
+	if err := p.UnmarshalText([]byte("7")); err != nil || *p.Load() != 7 {
+		t.Fatalf("UnmarshalText failed: %v %s", err, &p)
+	}
+}
+
+func TestTime(t *testing.T) {
+	now := time.Now()
+	var tm Time
+	if !tm.CompareAndSwap(time.Time{}, now) {
+		t.Fatal("CompareAndSwap on zero Time failed!")
+	}
+	if !tm.CompareAndSwap(now.In(time.UTC), now.Add(time.Second)) {
+		t.Fatal("CompareAndSwap should compare instant rather than location!")
+	}
+	data, err := tm.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tm2 Time
+	if err := tm2.UnmarshalText(data); err != nil || !tm2.Load().Equal(tm.Load()) {
+		t.Fatalf("text round trip failed: %v %s", err, data)
+	}
+}
@@ -0,0 +1,128 @@
+// github.com/Vonng/gopher/atomic/worker_pool.go provides bounded goroutine pool
+package atomic
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+/**************************************************************
+* struct: WorkerPool
+**************************************************************/
+
+// WorkerPool runs submitted funcs in goroutines with bounded concurrency.
+// concurrency is controlled by a Semaphore of maxLimit slots, where
+// maxLimit - limit slots are held by the pool itself, so resizing is
+// just acquiring or releasing those reserved slots
+type WorkerPool struct {
+	// sem : each running task or reserved slot occupies one
+	sem Semaphore
+	// limit : current concurrency limit, lock : serializes Resize,
+	// so reserved slots always match limit once a Resize returns
+	limit Int32
+	lock  sync.Mutex
+	// wg : tracks running tasks for Wait
+	wg       sync.WaitGroup
+	inFlight Int32
+	queued   Int32
+	// errs : errors collected since last Wait
+	errs    []error
+	errLock sync.Mutex
+}
+
+// NewWorkerPool create a pool running at most limit tasks at the same
+// time, limit could be resized within [1, maxLimit] later
+func NewWorkerPool(limit, maxLimit int) (*WorkerPool, error) {
+	if limit <= 0 || maxLimit < limit {
+		return nil, ErrInvalidPoolSize
+	}
+	sem := make(Semaphore, maxLimit)
+	sem.P(maxLimit - limit)
+	wp := &WorkerPool{sem: sem}
+	wp.limit.Store(int32(limit))
+	return wp, nil
+}
+
+// Submit blocks until a slot is available, then runs fn in a new
+// goroutine. returns ctx.Err() if ctx is done before getting a slot.
+// error or panic (as *PanicError) of fn is collected for Wait
+func (wp *WorkerPool) Submit(ctx context.Context, fn func() error) error {
+	wp.queued.Inc()
+	select {
+	case wp.sem <- struct{}{}:
+		wp.queued.Dec()
+	case <-ctx.Done():
+		wp.queued.Dec()
+		return ctx.Err()
+	}
+	wp.inFlight.Inc()
+	wp.wg.Add(1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				wp.collect(newPanicError(r))
+			}
+			wp.inFlight.Dec()
+			wp.sem.Dec()
+			wp.wg.Done()
+		}()
+		if err := fn(); err != nil {
+			wp.collect(err)
+		}
+	}()
+	return nil
+}
+
+// collect appends err to error list
+func (wp *WorkerPool) collect(err error) {
+	wp.errLock.Lock()
+	wp.errs = append(wp.errs, err)
+	wp.errLock.Unlock()
+}
+
+// Wait blocks until all submitted tasks finish, returns their errors
+// joined by errors.Join, or nil if all succeeded. error list is
+// cleared so the pool can be reused. Like sync.WaitGroup,
+// Wait should not be called concurrently with Submit
+func (wp *WorkerPool) Wait() error {
+	wp.wg.Wait()
+	wp.errLock.Lock()
+	defer wp.errLock.Unlock()
+	err := errors.Join(wp.errs...)
+	wp.errs = nil
+	return err
+}
+
+// Resize changes concurrency limit. shrinking blocks until enough
+// running tasks finish to fit in the new limit, other Resize calls
+// wait for it meanwhile, while Limit reports the new limit at once
+func (wp *WorkerPool) Resize(limit int) error {
+	if limit <= 0 || limit > cap(wp.sem) {
+		return ErrInvalidPoolSize
+	}
+	wp.lock.Lock()
+	defer wp.lock.Unlock()
+	old := int(wp.limit.Swap(int32(limit)))
+	if limit > old {
+		wp.sem.V(limit - old)
+	} else {
+		wp.sem.P(old - limit)
+	}
+	return nil
+}
+
+// Limit returns current concurrency limit, never blocks
+func (wp *WorkerPool) Limit() int {
+	return int(wp.limit.Load())
+}
+
+// InFlight returns number of running tasks
+func (wp *WorkerPool) InFlight() int {
+	return int(wp.inFlight.Load())
+}
+
+// Queued returns number of Submit calls waiting for a slot
+func (wp *WorkerPool) Queued() int {
+	return int(wp.queued.Load())
+}
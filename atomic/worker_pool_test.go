@@ -0,0 +1,124 @@
+package atomic
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolNew(t *testing.T) {
+	if _, err := NewWorkerPool(0, 1); err != ErrInvalidPoolSize {
+		t.Fatal("No error when new a worker pool with zero limit!")
+	}
+	if _, err := NewWorkerPool(2, 1); err != ErrInvalidPoolSize {
+		t.Fatal("No error when new a worker pool with limit > maxLimit!")
+	}
+}
+
+func TestWorkerPoolLimit(t *testing.T) {
+	wp, _ := NewWorkerPool(3, 8)
+	var running, peak Int32
+	for i := 0; i < 30; i++ {
+		wp.Submit(context.Background(), func() error {
+			n := running.Inc()
+			for {
+				old := peak.Load()
+				if n <= old || peak.CompareAndSwap(old, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			running.Dec()
+			return nil
+		})
+	}
+	if err := wp.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if peak.Load() > 3 {
+		t.Fatalf("concurrency exceeds limit: expected: %d, actual: %d", 3, peak.Load())
+	}
+}
+
+func TestWorkerPoolErrors(t *testing.T) {
+	wp, _ := NewWorkerPool(2, 2)
+	fail := errors.New("fail")
+	wp.Submit(context.Background(), func() error { return fail })
+	wp.Submit(context.Background(), func() error { panic("boom") })
+	wp.Submit(context.Background(), func() error { return nil })
+	err := wp.Wait()
+	var pe *PanicError
+	if !errors.Is(err, fail) || !errors.As(err, &pe) {
+		t.Fatalf("errors not aggregated: %v", err)
+	}
+	if err := wp.Wait(); err != nil {
+		t.Fatalf("errors should be cleared after Wait: %v", err)
+	}
+}
+
+func TestWorkerPoolSubmitContext(t *testing.T) {
+	wp, _ := NewWorkerPool(1, 1)
+	release := make(chan struct{})
+	wp.Submit(context.Background(), func() error { <-release; return nil })
+	if wp.InFlight() != 1 {
+		t.Fatalf("Inconsistent in-flight: expected: %d, actual: %d", 1, wp.InFlight())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- wp.Submit(ctx, func() error { return nil }) }()
+	time.Sleep(10 * time.Millisecond)
+	if wp.Queued() != 1 {
+		t.Fatalf("Inconsistent queued: expected: %d, actual: %d", 1, wp.Queued())
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Inconsistent error: expected: %v, actual: %v", context.Canceled, err)
+	}
+	close(release)
+	wp.Wait()
+	if wp.Queued() != 0 || wp.InFlight() != 0 {
+		t.Fatalf("gauges not back to zero: %d %d", wp.Queued(), wp.InFlight())
+	}
+}
+
+func TestWorkerPoolResize(t *testing.T) {
+	wp, _ := NewWorkerPool(1, 4)
+	if err := wp.Resize(5); err != ErrInvalidPoolSize {
+		t.Fatal("No error when resize beyond maxLimit!")
+	}
+	release := make(chan struct{})
+	block := func() error { <-release; return nil }
+	wp.Resize(3)
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if err := wp.Submit(ctx, block); err != nil {
+			t.Fatalf("Submit failed after grow: %v", err)
+		}
+		cancel()
+	}
+	resized := make(chan struct{})
+	go func() { wp.Resize(1); close(resized) }()
+	select {
+	case <-resized:
+		t.Fatal("shrink should wait for running tasks")
+	case <-time.After(10 * time.Millisecond):
+	}
+	// a pending shrink must not block readers of the limit
+	limited := make(chan int)
+	go func() { limited <- wp.Limit() }()
+	select {
+	case limit := <-limited:
+		if limit != 1 {
+			t.Fatalf("Inconsistent limit during shrink: expected: %d, actual: %d", 1, limit)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Limit blocked by a pending shrink")
+	}
+	close(release)
+	<-resized
+	if wp.Limit() != 1 {
+		t.Fatalf("Inconsistent limit: expected: %d, actual: %d", 1, wp.Limit())
+	}
+	wp.Wait()
+}
@@ -0,0 +1,102 @@
+// pgmigrate applies versioned sql migrations to postgres
+//
+//	pgmigrate [-url postgres://...] [-dir migrations] [-target N] [-dry-run] up|down|status
+//
+// up applies pending migrations up to -target (all by default), it never reverts,
+// down reverts applied migrations above -target (required, 0 reverts all), it never applies,
+// status lists migrations and whether they are applied
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+)
+
+import "github.com/Vonng/gopher/db/pg"
+import "github.com/Vonng/gopher/db/pg/migrate"
+import log "github.com/Sirupsen/logrus"
+
+func main() {
+	pgURL := flag.String("url", os.Getenv("PGURL"), "postgres url or connection string, default ENV:PGURL")
+	dir := flag.String("dir", "migrations", "directory of <version>_<name>.(up|down).sql files")
+	table := flag.String("table", migrate.DefaultTable, "version table name")
+	target := flag.Int64("target", -1, "target version, up: latest by default, down: required")
+	dryRun := flag.Bool("dry-run", false, "print steps without executing")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] up|down|status\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	migrations, err := migrate.Dir(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *pgURL == "" {
+		*pgURL = pg.DefaultURL
+	}
+	db, err := pg.OpenURL(ctx, *pgURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	m := migrate.New(db, migrations, migrate.Options{Table: *table, DryRun: *dryRun, Logf: log.Infof})
+
+	switch flag.Arg(0) {
+	case "up":
+		if *target < 0 {
+			*target = migrate.Latest
+		}
+		steps, err := m.UpTo(ctx, *target)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("migrate: %d steps", len(steps))
+	case "down":
+		if *target < 0 {
+			log.Fatal("down requires -target, use -target 0 to revert all")
+		}
+		steps, err := m.DownTo(ctx, *target)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("migrate: %d steps", len(steps))
+	case "status":
+		list, err := m.Status(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+		for _, s := range list {
+			state, appliedAt := "pending", ""
+			switch {
+			case s.Missing:
+				state = "missing"
+			case s.Modified:
+				state = "modified"
+			case s.Applied:
+				state = "applied"
+			}
+			if s.Applied {
+				appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", s.Version, s.Name, state, appliedAt)
+		}
+		w.Flush()
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
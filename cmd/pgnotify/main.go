@@ -0,0 +1,104 @@
+// pgnotify generates change notification triggers for cache sync
+//
+//	pgnotify [-url postgres://...] -table T [-keys a,b] [-columns c,d] [-truncate]
+//	         [-format text|json] [-row] sql|install|uninstall
+//
+// sql prints the DDL (keys are detected from primary key if -url is
+// reachable and -keys is not given), install creates the triggers,
+// uninstall removes them and the trigger function
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+import "github.com/Vonng/gopher/db/pg"
+import "github.com/Vonng/gopher/db/pg/notify"
+import log "github.com/Sirupsen/logrus"
+
+// splitList split comma separated flag value, "" gives nil
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	list := strings.Split(s, ",")
+	for i := range list {
+		list[i] = strings.TrimSpace(list[i])
+	}
+	return list
+}
+
+func main() {
+	pgURL := flag.String("url", os.Getenv("PGURL"), "postgres url or connection string, default ENV:PGURL")
+	table := flag.String("table", "", "table name, could be schema qualified")
+	channel := flag.String("channel", "", "notification channel, <table>_chan by default")
+	keys := flag.String("keys", "", "comma separated key columns, primary key by default")
+	columns := flag.String("columns", "", "comma separated columns whose updates are notified, all by default")
+	truncate := flag.Bool("truncate", false, "notify TRUNCATE as well")
+	format := flag.String("format", "text", "payload format: text or json")
+	row := flag.Bool("row", false, "include row image in json payload")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] sql|install|uninstall\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || *table == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	trigger := notify.Trigger{Table: *table, Channel: *channel, Keys: splitList(*keys),
+		Columns: splitList(*columns), Truncate: *truncate, Row: *row}
+	switch *format {
+	case "text":
+	case "json":
+		trigger.Format = notify.FormatJSON
+	default:
+		log.Fatalf("unknown format %q, should be text or json", *format)
+	}
+
+	ctx := context.Background()
+	var db *pg.DB
+	if flag.Arg(0) != "sql" || len(trigger.Keys) == 0 {
+		if *pgURL == "" {
+			*pgURL = pg.DefaultURL
+		}
+		var err error
+		if db, err = pg.OpenURL(ctx, *pgURL); err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+	}
+
+	switch flag.Arg(0) {
+	case "sql":
+		if len(trigger.Keys) == 0 {
+			detected, err := notify.Detect(ctx, db, trigger.Table)
+			if err != nil {
+				log.Fatal(err)
+			}
+			trigger.Keys = detected
+		}
+		sql, err := trigger.CreateSQL()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(sql)
+	case "install":
+		if err := notify.Install(ctx, db, trigger); err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("notify: trigger installed on %s", trigger.Table)
+	case "uninstall":
+		if err := notify.Uninstall(ctx, db, trigger); err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("notify: trigger removed from %s", trigger.Table)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
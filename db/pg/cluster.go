@@ -0,0 +1,301 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+import "github.com/go-pg/pg"
+import "github.com/Vonng/gopher/atomic"
+
+// ReplicaPolicy decides which healthy replica serves a read
+type ReplicaPolicy int
+
+// Replica selection policy
+const (
+	// RoundRobin spreads reads evenly across healthy replicas
+	RoundRobin ReplicaPolicy = iota
+	// LeastLag prefers the replica with smallest replication lag
+	LeastLag
+)
+
+// Cluster defaults
+const (
+	DefaultMaxLag        = 10 * time.Second
+	DefaultCheckInterval = 5 * time.Second
+)
+
+// ClusterConfig describes a primary and its streaming replicas
+type ClusterConfig struct {
+	Primary  Config
+	Replicas []Config
+	// Policy : replica selection, RoundRobin by default
+	Policy ReplicaPolicy
+	// MaxLag : replicas lagging more than this are skipped, DefaultMaxLag if 0
+	MaxLag time.Duration
+	// CheckInterval : how often replicas are probed, DefaultCheckInterval if 0
+	CheckInterval time.Duration
+}
+
+// ReplicaStatus is the result of last probe of a replica
+type ReplicaStatus struct {
+	Addr      string        `json:"addr"`
+	Healthy   bool          `json:"healthy"`
+	Lag       time.Duration `json:"lag"`
+	Err       error         `json:"-"`
+	CheckedAt time.Time     `json:"checked_at"`
+}
+
+/**************************************************************
+* struct: Cluster
+**************************************************************/
+
+// Cluster routes writes to primary and reads to healthy replicas,
+// falling back to primary when all replicas are lagging or down
+type Cluster struct {
+	primary  *DB
+	replicas []*replica
+	policy   ReplicaPolicy
+	maxLag   time.Duration
+	interval time.Duration
+	// next : rotating start position for replica selection
+	next atomic.Uint64
+	// probe : returns replication lag of a replica, replaced in tests
+	probe func(ctx context.Context, db *DB) (time.Duration, error)
+
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// replica is a replica pool with its last probe result
+type replica struct {
+	db     *DB
+	status atomic.Pointer[ReplicaStatus]
+}
+
+// OpenCluster open primary and replica pools. primary must be reachable,
+// replicas are probed immediately and then every CheckInterval,
+// an unreachable replica is just marked unhealthy until it recovers
+func OpenCluster(ctx context.Context, cfg ClusterConfig) (*Cluster, error) {
+	primary, err := Open(ctx, cfg.Primary)
+	if err != nil {
+		return nil, err
+	}
+	c := newCluster(primary, cfg)
+	for _, replicaConfig := range cfg.Replicas {
+		db, err := connect(replicaConfig)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.replicas = append(c.replicas, &replica{db: db})
+	}
+	c.Check(ctx)
+	c.wg.Add(1)
+	go c.loop()
+	return c, nil
+}
+
+// OpenClusterURL is equivalent to OpenCluster with default policy,
+// urls could be either postgres:// uri or keyword/value connection string
+func OpenClusterURL(ctx context.Context, primaryURL string, replicaURLs ...string) (*Cluster, error) {
+	primary, err := Parse(primaryURL)
+	if err != nil {
+		return nil, err
+	}
+	cfg := ClusterConfig{Primary: *primary}
+	for _, replicaURL := range replicaURLs {
+		replicaConfig, err := Parse(replicaURL)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Replicas = append(cfg.Replicas, *replicaConfig)
+	}
+	return OpenCluster(ctx, cfg)
+}
+
+// newCluster fill defaults, replicas are added by caller
+func newCluster(primary *DB, cfg ClusterConfig) *Cluster {
+	c := &Cluster{
+		primary:  primary,
+		policy:   cfg.Policy,
+		maxLag:   cfg.MaxLag,
+		interval: cfg.CheckInterval,
+		probe:    replicationLag,
+		done:     make(chan struct{}),
+	}
+	if c.maxLag <= 0 {
+		c.maxLag = DefaultMaxLag
+	}
+	if c.interval <= 0 {
+		c.interval = DefaultCheckInterval
+	}
+	return c
+}
+
+// Primary returns the primary pool
+func (c *Cluster) Primary() *DB {
+	return c.primary
+}
+
+// Writer returns the primary pool, and pins the session in ctx (if any)
+// to primary so that following reads see this write
+func (c *Cluster) Writer(ctx context.Context) *DB {
+	if s, ok := ctx.Value(sessionKey{}).(*session); ok {
+		s.pinned.Set(true)
+	}
+	return c.primary
+}
+
+// Reader returns a pool for read-only queries: primary if ctx is pinned,
+// otherwise a healthy replica chosen by policy, or primary if none
+func (c *Cluster) Reader(ctx context.Context) *DB {
+	if Pinned(ctx) || len(c.replicas) == 0 {
+		return c.primary
+	}
+	n := uint64(len(c.replicas))
+	start := c.next.Inc()
+	var best *replica
+	var bestLag time.Duration
+	for i := uint64(0); i < n; i++ {
+		r := c.replicas[(start+i)%n]
+		status := r.status.Load()
+		if status == nil || !status.Healthy {
+			continue
+		}
+		if c.policy == RoundRobin {
+			return r.db
+		}
+		if best == nil || status.Lag < bestLag {
+			best, bestLag = r, status.Lag
+		}
+	}
+	if best == nil {
+		return c.primary
+	}
+	return best.db
+}
+
+// Replicas returns last probe result of each replica
+func (c *Cluster) Replicas() []ReplicaStatus {
+	res := make([]ReplicaStatus, len(c.replicas))
+	for i, r := range c.replicas {
+		if status := r.status.Load(); status != nil {
+			res[i] = *status
+		} else {
+			res[i] = ReplicaStatus{Addr: r.db.config.Addr()}
+		}
+	}
+	return res
+}
+
+// Check probe all replicas once and update their health
+func (c *Cluster) Check(ctx context.Context) {
+	for _, r := range c.replicas {
+		probeCtx, cancel := context.WithTimeout(ctx, c.interval)
+		lag, err := c.probe(probeCtx, r.db)
+		cancel()
+		r.status.Store(&ReplicaStatus{
+			Addr:      r.db.config.Addr(),
+			Healthy:   err == nil && lag <= c.maxLag,
+			Lag:       lag,
+			Err:       err,
+			CheckedAt: time.Now(),
+		})
+	}
+}
+
+// loop probes replicas every interval until Close
+func (c *Cluster) loop() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.Check(context.Background())
+		}
+	}
+}
+
+// Close stops health checking and closes all pools, it is safe to call
+// more than once, later calls return the result of the first one
+func (c *Cluster) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.wg.Wait()
+		errs := []error{c.primary.Close()}
+		for _, r := range c.replicas {
+			errs = append(errs, r.db.Close())
+		}
+		c.closeErr = errors.Join(errs...)
+	})
+	return c.closeErr
+}
+
+// replicationLag returns how far a replica is behind its primary. It is 0
+// if replica is not in recovery, or streaming and has replayed everything
+// received. Otherwise, e.g. wal receiver is down so nothing new is received,
+// it is the age of last replayed transaction, an error if there is none.
+// status of pg_stat_wal_receiver is hidden without pg_read_all_stats,
+// then a running receiver is taken as streaming
+func replicationLag(ctx context.Context, db *DB) (time.Duration, error) {
+	var seconds *float64
+	_, err := db.DB.WithContext(ctx).QueryOne(pg.Scan(&seconds), `
+SELECT CASE
+  WHEN NOT pg_is_in_recovery() THEN 0
+  WHEN NOT EXISTS (SELECT 1 FROM pg_stat_wal_receiver WHERE coalesce(status, 'streaming') = 'streaming')
+    THEN extract(EPOCH FROM now() - pg_last_xact_replay_timestamp())
+  WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+  ELSE COALESCE(extract(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+END`)
+	if err != nil {
+		return 0, err
+	}
+	if seconds == nil {
+		return 0, ErrReplicaDetached
+	}
+	return time.Duration(*seconds * float64(time.Second)), nil
+}
+
+/**************************************************************
+* read my writes
+**************************************************************/
+
+// sessionKey is the context key of *session
+type sessionKey struct{}
+
+// session records whether a request has written to primary
+type session struct {
+	pinned atomic.AtomicBool
+}
+
+// WithSession returns a context carrying an unpinned session, usually
+// installed per request, Cluster.Writer pins it so later reads go to primary
+func WithSession(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionKey{}, &session{})
+}
+
+// PinPrimary pins the session in ctx to primary, a new pinned session is
+// attached if ctx has none
+func PinPrimary(ctx context.Context) context.Context {
+	if s, ok := ctx.Value(sessionKey{}).(*session); ok {
+		s.pinned.Set(true)
+		return ctx
+	}
+	s := &session{}
+	s.pinned.Set(true)
+	return context.WithValue(ctx, sessionKey{}, s)
+}
+
+// Pinned reports whether reads within ctx should go to primary
+func Pinned(ctx context.Context) bool {
+	s, ok := ctx.Value(sessionKey{}).(*session)
+	return ok && s.pinned.Get()
+}
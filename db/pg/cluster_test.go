@@ -0,0 +1,93 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestCluster build a cluster of fake pools whose lag is given by lags,
+// a negative lag means the replica is unreachable
+func newTestCluster(policy ReplicaPolicy, lags ...time.Duration) *Cluster {
+	c := newCluster(&DB{config: Config{Hosts: []Endpoint{{"primary", 5432}}}}, ClusterConfig{Policy: policy})
+	for i := range lags {
+		c.replicas = append(c.replicas, &replica{db: &DB{config: Config{Hosts: []Endpoint{{"replica", 5432 + i}}}}})
+	}
+	c.probe = func(ctx context.Context, db *DB) (time.Duration, error) {
+		lag := lags[db.config.Hosts[0].Port-5432]
+		if lag < 0 {
+			return 0, errors.New("connection refused")
+		}
+		return lag, nil
+	}
+	c.Check(context.Background())
+	return c
+}
+
+func TestClusterRoundRobin(t *testing.T) {
+	c := newTestCluster(RoundRobin, 0, time.Minute, 0, -1)
+	hits := map[*DB]int{}
+	for i := 0; i < 100; i++ {
+		hits[c.Reader(context.Background())]++
+	}
+	if len(hits) != 2 || hits[c.replicas[0].db] != 50 || hits[c.replicas[2].db] != 50 {
+		t.Fatalf("Inconsistent round robin: expected only healthy replicas evenly, actual: %v", hits)
+	}
+	status := c.Replicas()
+	if !status[0].Healthy || status[1].Healthy || status[3].Healthy || status[3].Err == nil {
+		t.Fatalf("Inconsistent replica status: %+v", status)
+	}
+}
+
+func TestClusterLeastLag(t *testing.T) {
+	c := newTestCluster(LeastLag, 3*time.Second, time.Second, -1, 2*time.Second)
+	for i := 0; i < 10; i++ {
+		if db := c.Reader(context.Background()); db != c.replicas[1].db {
+			t.Fatalf("Inconsistent least lag replica: expected: %s, actual: %s",
+				c.replicas[1].db.config.Addr(), db.config.Addr())
+		}
+	}
+
+	// equal lag replicas share the load
+	c = newTestCluster(LeastLag, 0, 0)
+	if c.Reader(context.Background()) == c.Reader(context.Background()) {
+		t.Fatal("ties should rotate between replicas")
+	}
+}
+
+func TestClusterFallback(t *testing.T) {
+	for _, c := range []*Cluster{
+		newTestCluster(RoundRobin),
+		newTestCluster(RoundRobin, -1, -1),
+		newTestCluster(LeastLag, time.Hour, -1),
+	} {
+		if db := c.Reader(context.Background()); db != c.Primary() {
+			t.Fatalf("Inconsistent fallback: expected primary, actual: %s", db.config.Addr())
+		}
+	}
+}
+
+func TestClusterPinning(t *testing.T) {
+	c := newTestCluster(RoundRobin, 0)
+	ctx := WithSession(context.Background())
+	if c.Reader(ctx) == c.Primary() {
+		t.Fatal("unpinned session should read from replica")
+	}
+	if c.Writer(ctx) != c.Primary() || !Pinned(ctx) {
+		t.Fatal("writer should pin session to primary")
+	}
+	if c.Reader(ctx) != c.Primary() {
+		t.Fatal("pinned session should read from primary")
+	}
+
+	// without session only explicit pinning works
+	ctx = context.Background()
+	c.Writer(ctx)
+	if Pinned(ctx) {
+		t.Fatal("context without session should not be pinned")
+	}
+	if ctx = PinPrimary(ctx); c.Reader(ctx) != c.Primary() {
+		t.Fatal("PinPrimary should route reads to primary")
+	}
+}
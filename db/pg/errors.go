@@ -0,0 +1,69 @@
+package pg
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error returned by package pg
+var (
+	// ErrInvalidScheme occurs when url scheme is not postgres or postgresql
+	ErrInvalidScheme = errors.New("scheme should be postgres or postgresql")
+
+	// ErrInvalidPort occurs when port is not a number in 1~65535
+	ErrInvalidPort = errors.New("port should be a number in 1~65535")
+
+	// ErrInvalidSSLMode occurs when sslmode is not supported
+	ErrInvalidSSLMode = errors.New("unsupported sslmode")
+
+	// ErrNoRootCert occurs when sslmode needs a root certificate but none found
+	ErrNoRootCert = errors.New("root certificate file not found, set sslrootcert or use sslrootcert=system")
+
+	// ErrUnknownParam occurs when url contains unsupported query parameter
+	ErrUnknownParam = errors.New("unknown parameter")
+
+	// ErrInvalidParam occurs when parameter value is malformed or unsupported
+	ErrInvalidParam = errors.New("invalid parameter value")
+
+	// ErrSessionAttrs occurs when no server satisfies target_session_attrs
+	ErrSessionAttrs = errors.New("server does not satisfy target_session_attrs")
+
+	// ErrNoPassword occurs when server asks for a password but none is
+	// given or found in passfile for that host
+	ErrNoPassword = errors.New("server requires password but none is given")
+
+	// ErrSSLRefused occurs when sslmode requires ssl but server does not support it
+	ErrSSLRefused = errors.New("SSL is not enabled on the server")
+
+	// ErrUnknownSession occurs when a cancel request refers to no open session
+	ErrUnknownSession = errors.New("cancel request of unknown session")
+
+	// ErrReplicaDetached occurs when a replica is not streaming from primary
+	// and has not replayed any transaction, so its lag is unknown
+	ErrReplicaDetached = errors.New("replica is not streaming and has replayed nothing")
+
+	// ErrSyntax occurs when keyword/value connection string is malformed
+	ErrSyntax = errors.New("syntax error: want key=value or key='quoted value'")
+)
+
+// URLError describes which part of a postgres url is invalid
+type URLError struct {
+	// URL : the url with password redacted
+	URL string
+	// Part : scheme, host, port, user, database or parameter name
+	Part string
+	// Value : the offending value
+	Value string
+	// Err : underlying reason
+	Err error
+}
+
+// Error implements error
+func (e *URLError) Error() string {
+	return fmt.Sprintf("pg: invalid %s %q in %q: %v", e.Part, e.Value, e.URL, e.Err)
+}
+
+// Unwrap returns underlying reason
+func (e *URLError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,54 @@
+package pg
+
+import (
+	"context"
+	"time"
+)
+
+import "github.com/go-pg/pg"
+
+// Health is a snapshot of server reachability and pool saturation,
+// suitable for readiness probes and dashboards
+type Health struct {
+	// Latency : round trip time of the probe query
+	Latency time.Duration `json:"latency"`
+	// ServerVersion : e.g. 10.4, empty if server is unreachable
+	ServerVersion string `json:"server_version"`
+	// PoolSize : max connections allowed in pool
+	PoolSize int `json:"pool_size"`
+	// TotalConns, IdleConns : connections in pool, total - idle are in use
+	TotalConns uint32 `json:"total_conns"`
+	IdleConns  uint32 `json:"idle_conns"`
+	// StaleConns : connections closed by idle timeout or max age so far
+	StaleConns uint32 `json:"stale_conns"`
+	// Hits, Misses : times an idle connection was / wasn't available
+	Hits   uint32 `json:"hits"`
+	Misses uint32 `json:"misses"`
+	// Timeouts : times waiting for a free connection exceeded PoolTimeout.
+	// go-pg (v7, v8) does not count waits on a full pool which succeed in
+	// time, so there is no wait counter
+	Timeouts uint32 `json:"timeouts"`
+}
+
+// Saturation returns ratio of connections in use to pool size
+func (h *Health) Saturation() float64 {
+	if h.PoolSize == 0 {
+		return 0
+	}
+	return float64(h.TotalConns-h.IdleConns) / float64(h.PoolSize)
+}
+
+// Health query server version as a ping and collect pool stats.
+// the returned Health is always filled with pool stats,
+// error is non-nil if server is unreachable
+func (db *DB) Health(ctx context.Context) (*Health, error) {
+	h := &Health{PoolSize: db.Options().PoolSize}
+	start := time.Now()
+	_, err := db.DB.QueryOneContext(ctx, pg.Scan(&h.ServerVersion), `SHOW server_version`)
+	h.Latency = time.Since(start)
+
+	stats := db.PoolStats()
+	h.TotalConns, h.IdleConns, h.StaleConns = stats.TotalConns, stats.IdleConns, stats.StaleConns
+	h.Hits, h.Misses, h.Timeouts = stats.Hits, stats.Misses, stats.Timeouts
+	return h, err
+}
@@ -0,0 +1,68 @@
+package pg
+
+import (
+	"context"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	server := newFakeServer(t, &fakeServer{auth: "trust"})
+	db, err := connect(Config{Hosts: []Endpoint{server.endpoint()}, User: "bob", Database: "app", PoolSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	h, err := db.Health(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.ServerVersion != "10.0" || h.Latency <= 0 || h.PoolSize != 2 {
+		t.Fatalf("Inconsistent health: %+v", h)
+	}
+	// first probe dials, second reuses the idle connection
+	if h.TotalConns != 1 || h.IdleConns != 1 || h.Hits != 0 || h.Misses != 1 {
+		t.Fatalf("Inconsistent pool stats of first probe: %+v", h)
+	}
+	if h, _ = db.Health(context.Background()); h.Hits != 1 || h.Misses != 1 || h.Saturation() != 0 {
+		t.Fatalf("Inconsistent pool stats of second probe: %+v", h)
+	}
+
+}
+
+func TestHealthUnreachable(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	server := newFakeServer(t, &fakeServer{auth: "trust"})
+	server.ln.Close()
+	db, err := connect(Config{Hosts: []Endpoint{server.endpoint()}, User: "bob", Database: "app", PoolSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	h, err := db.Health(context.Background())
+	if err == nil {
+		t.Fatal("unreachable server should fail")
+	}
+	if h.ServerVersion != "" || h.PoolSize != 3 || h.TotalConns != 0 {
+		t.Fatalf("Inconsistent health of unreachable server: %+v", h)
+	}
+}
+
+func TestHealthSaturation(t *testing.T) {
+	tests := []struct {
+		h    Health
+		want float64
+	}{
+		{Health{}, 0},
+		{Health{PoolSize: 10, TotalConns: 4, IdleConns: 4}, 0},
+		{Health{PoolSize: 10, TotalConns: 4, IdleConns: 1}, 0.3},
+		{Health{PoolSize: 4, TotalConns: 4}, 1},
+	}
+	for _, tt := range tests {
+		if got := tt.h.Saturation(); got != tt.want {
+			t.Errorf("Inconsistent saturation of %+v: expected: %v, actual: %v", tt.h, tt.want, got)
+		}
+	}
+}
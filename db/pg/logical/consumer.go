@@ -0,0 +1,262 @@
+package logical
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+import gopg "github.com/go-pg/pg"
+import "github.com/Vonng/gopher/db/pg"
+
+// Consumer defaults
+const (
+	DefaultBatchSize    = 1000
+	DefaultPollInterval = time.Second
+)
+
+// message is a row returned by pg_logical_slot_peek_binary_changes
+type message struct {
+	LSN  string
+	Data []byte
+}
+
+// source is what Consumer needs from a replication slot
+type source interface {
+	peek(ctx context.Context, limit int) ([]message, error)
+	advance(ctx context.Context, lsn LSN) error
+}
+
+/**************************************************************
+* struct: Consumer
+**************************************************************/
+
+// Consumer reads committed transactions from a logical replication slot.
+//
+// go-pg does not speak the streaming replication protocol, so changes
+// are polled through the SQL interface: pg_logical_slot_peek_binary_changes
+// decodes from the slot's confirmed position, and pg_replication_slot_advance
+// confirms a transaction once handled (PostgreSQL 11+). Delivery is
+// at least once: a transaction is redelivered until its handler succeeds
+type Consumer struct {
+	// Slot : replication slot name, Publication : publication decoded
+	Slot        string
+	Publication string
+	// BatchSize : changes decoded per poll, whole transactions are
+	// always returned, DefaultBatchSize if 0
+	BatchSize int
+	// PollInterval : wait between polls when caught up, DefaultPollInterval if 0
+	PollInterval time.Duration
+	// OnError : called with each poll failure before retry, optional
+	OnError func(error)
+	// ReplicaIdentityFull : Setup alters published tables to REPLICA
+	// IDENTITY FULL, so that old image of update & delete has all columns
+	ReplicaIdentityFull bool
+
+	db        *pg.DB
+	source    source
+	decoder   *Decoder
+	confirmed LSN
+}
+
+// NewConsumer create a consumer of slot decoding publication,
+// both are created by Setup if not exist
+func NewConsumer(db *pg.DB, slot, publication string) *Consumer {
+	c := newConsumer(nil, slot, publication)
+	c.db, c.source = db, sqlSource{c}
+	return c
+}
+
+// newConsumer create a consumer reading from given source
+func newConsumer(src source, slot, publication string) *Consumer {
+	return &Consumer{
+		Slot:        slot,
+		Publication: publication,
+		source:      src,
+		decoder:     NewDecoder(),
+	}
+}
+
+// Confirmed returns end of the last transaction confirmed by this consumer
+func (c *Consumer) Confirmed() LSN {
+	return c.confirmed
+}
+
+// Setup create publication of tables (all tables if none given) and
+// the slot, skipping those already exist. Published tables are altered
+// to REPLICA IDENTITY FULL if ReplicaIdentityFull is set. The slot
+// retains WAL until consumed, Drop it when consumer is decommissioned
+func (c *Consumer) Setup(ctx context.Context, tables ...string) error {
+	db := c.db.DB.WithContext(ctx)
+	var exists bool
+	if _, err := db.QueryOne(gopg.Scan(&exists), `SELECT exists(SELECT 1 FROM pg_publication WHERE pubname = ?)`, c.Publication); err != nil {
+		return fmt.Errorf("logical: publication %s: %w", c.Publication, err)
+	}
+	if !exists {
+		target := "ALL TABLES"
+		if len(tables) > 0 {
+			quoted := make([]string, len(tables))
+			for i, table := range tables {
+				quoted[i] = quoteTable(table)
+			}
+			target = "TABLE " + strings.Join(quoted, ", ")
+		}
+		if _, err := db.Exec(`CREATE PUBLICATION ` + quoteIdent(c.Publication) + ` FOR ` + target); err != nil {
+			return fmt.Errorf("logical: create publication %s: %w", c.Publication, err)
+		}
+	}
+	if c.ReplicaIdentityFull {
+		if err := c.identityFull(db); err != nil {
+			return err
+		}
+	}
+	if _, err := db.Exec(`SELECT pg_create_logical_replication_slot(?, 'pgoutput')
+WHERE NOT exists(SELECT 1 FROM pg_replication_slots WHERE slot_name = ?)`, c.Slot, c.Slot); err != nil {
+		return fmt.Errorf("logical: create slot %s: %w", c.Slot, err)
+	}
+	return nil
+}
+
+// identityFull alter published tables not yet REPLICA IDENTITY FULL
+func (c *Consumer) identityFull(db *gopg.DB) error {
+	var tables []string
+	if _, err := db.Query(&tables, `SELECT format('%I.%I', p.schemaname, p.tablename)
+FROM pg_publication_tables p
+JOIN pg_namespace n ON n.nspname = p.schemaname
+JOIN pg_class c ON c.relnamespace = n.oid AND c.relname = p.tablename
+WHERE p.pubname = ? AND c.relreplident <> 'f'`, c.Publication); err != nil {
+		return fmt.Errorf("logical: tables of publication %s: %w", c.Publication, err)
+	}
+	for _, table := range tables {
+		if _, err := db.Exec(`ALTER TABLE ` + table + ` REPLICA IDENTITY FULL`); err != nil {
+			return fmt.Errorf("logical: replica identity of %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// Drop remove the slot and publication
+func (c *Consumer) Drop(ctx context.Context) error {
+	db := c.db.DB.WithContext(ctx)
+	if _, err := db.Exec(`SELECT pg_drop_replication_slot(slot_name) FROM pg_replication_slots WHERE slot_name = ?`, c.Slot); err != nil {
+		return fmt.Errorf("logical: drop slot %s: %w", c.Slot, err)
+	}
+	if _, err := db.Exec(`DROP PUBLICATION IF EXISTS ` + quoteIdent(c.Publication)); err != nil {
+		return fmt.Errorf("logical: drop publication %s: %w", c.Publication, err)
+	}
+	return nil
+}
+
+// Run poll until ctx is done, handing each committed transaction to
+// handle in commit order. Failures are reported to OnError and retried
+// after PollInterval. Run always returns ctx.Err()
+func (c *Consumer) Run(ctx context.Context, handle func(context.Context, *Tx) error) error {
+	for {
+		n, err := c.Poll(ctx, handle)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil && c.OnError != nil {
+			c.OnError(err)
+		}
+		// more changes are probably waiting
+		if err == nil && n >= c.batchSize() {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.pollInterval()):
+		}
+	}
+}
+
+// Poll decode a batch once, handle transactions and confirm those
+// handled. It returns number of messages read
+func (c *Consumer) Poll(ctx context.Context, handle func(context.Context, *Tx) error) (int, error) {
+	messages, err := c.source.peek(ctx, c.batchSize())
+	if err != nil {
+		return 0, fmt.Errorf("logical: peek %s: %w", c.Slot, err)
+	}
+	// a transaction is never split across polls, drop leftovers anyway
+	c.decoder.tx = nil
+	var handled LSN
+	for _, m := range messages {
+		tx, err := c.decoder.Decode(m.Data)
+		if err == nil && tx != nil {
+			if err = handle(ctx, tx); err == nil {
+				handled = tx.End
+			}
+		}
+		if err != nil {
+			err = fmt.Errorf("logical: at %s: %w", m.LSN, err)
+			if cerr := c.confirm(ctx, handled); cerr != nil {
+				return len(messages), cerr
+			}
+			return len(messages), err
+		}
+	}
+	return len(messages), c.confirm(ctx, handled)
+}
+
+// confirm advance slot to lsn, let server recycle WAL before it
+func (c *Consumer) confirm(ctx context.Context, lsn LSN) error {
+	if lsn <= c.confirmed {
+		return nil
+	}
+	if err := c.source.advance(ctx, lsn); err != nil {
+		return fmt.Errorf("logical: confirm %s: %w", lsn, err)
+	}
+	c.confirmed = lsn
+	return nil
+}
+
+func (c *Consumer) batchSize() int {
+	if c.BatchSize > 0 {
+		return c.BatchSize
+	}
+	return DefaultBatchSize
+}
+
+func (c *Consumer) pollInterval() time.Duration {
+	if c.PollInterval > 0 {
+		return c.PollInterval
+	}
+	return DefaultPollInterval
+}
+
+/**************************************************************
+* struct: sqlSource
+**************************************************************/
+
+// sqlSource reads slot through SQL functions
+type sqlSource struct {
+	c *Consumer
+}
+
+func (s sqlSource) peek(ctx context.Context, limit int) ([]message, error) {
+	var messages []message
+	_, err := s.c.db.DB.WithContext(ctx).Query(&messages, `SELECT lsn::text AS lsn, data
+FROM pg_logical_slot_peek_binary_changes(?, NULL, ?, 'proto_version', '1', 'publication_names', ?)`,
+		s.c.Slot, limit, quoteIdent(s.c.Publication))
+	return messages, err
+}
+
+func (s sqlSource) advance(ctx context.Context, lsn LSN) error {
+	_, err := s.c.db.DB.WithContext(ctx).Exec(`SELECT pg_replication_slot_advance(?, ?::pg_lsn)`, s.c.Slot, lsn.String())
+	return err
+}
+
+// quoteTable quote optionally schema qualified table name
+func quoteTable(table string) string {
+	if schema, name, ok := strings.Cut(table, "."); ok {
+		return quoteIdent(schema) + "." + quoteIdent(name)
+	}
+	return quoteIdent(table)
+}
+
+// quoteIdent quote an identifier, always quoted
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,133 @@
+package logical
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeSlot replays transactions after the confirmed position, like a slot
+type fakeSlot struct {
+	txs       [][]message
+	ends      []LSN
+	confirmed LSN
+	advances  []LSN
+	err       error
+}
+
+// commit append a transaction of one insert per user name
+func (s *fakeSlot) commit(end uint64, names ...string) {
+	tx := [][]byte{begin(end), usersRelation}
+	for _, name := range names {
+		tx = append(tx, msg(byte('I'), uint32(16384), byte('N'), uint16(2), text("1"), text(name)))
+	}
+	tx = append(tx, commit(end))
+	messages := make([]message, len(tx))
+	for i, data := range tx {
+		messages[i] = message{LSN: LSN(end).String(), Data: data}
+	}
+	s.txs, s.ends = append(s.txs, messages), append(s.ends, LSN(end))
+}
+
+// peek returns whole transactions until limit is reached
+func (s *fakeSlot) peek(ctx context.Context, limit int) ([]message, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	var res []message
+	for i, tx := range s.txs {
+		if s.ends[i] > s.confirmed && len(res) < limit {
+			res = append(res, tx...)
+		}
+	}
+	return res, nil
+}
+
+func (s *fakeSlot) advance(ctx context.Context, lsn LSN) error {
+	s.advances = append(s.advances, lsn)
+	s.confirmed = lsn
+	return nil
+}
+
+func TestConsumerPoll(t *testing.T) {
+	slot := &fakeSlot{}
+	slot.commit(0x100, "alice")
+	slot.commit(0x200, "bob", "carol")
+	slot.commit(0x300, "dave")
+	c := newConsumer(slot, "users_slot", "users_pub")
+	ctx := context.Background()
+
+	// handler fails on the second transaction: first is confirmed
+	var names []string
+	fail := true
+	handle := func(ctx context.Context, tx *Tx) error {
+		if tx.End == 0x200 && fail {
+			return errors.New("cache busy")
+		}
+		for _, change := range tx.Changes {
+			name, _ := change.New.Get("name")
+			names = append(names, name)
+		}
+		return nil
+	}
+	if _, err := c.Poll(ctx, handle); err == nil {
+		t.Fatal("handler error not reported")
+	}
+	if c.Confirmed() != 0x100 || !reflect.DeepEqual(slot.advances, []LSN{0x100}) {
+		t.Fatalf("Inconsistent confirmed: expected: %s, actual: %s (%v)", LSN(0x100), c.Confirmed(), slot.advances)
+	}
+
+	// resume right after the confirmed transaction
+	fail = false
+	if _, err := c.Poll(ctx, handle); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"alice", "bob", "carol", "dave"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("Inconsistent names: expected: %v, actual: %v", want, names)
+	}
+	if c.Confirmed() != 0x300 {
+		t.Fatalf("Inconsistent confirmed: expected: %s, actual: %s", LSN(0x300), c.Confirmed())
+	}
+
+	// nothing new: no confirmation round trip
+	if n, err := c.Poll(ctx, handle); err != nil || n != 0 || len(slot.advances) != 2 {
+		t.Fatalf("idle poll => %d %v, advances %v", n, err, slot.advances)
+	}
+}
+
+func TestConsumerRun(t *testing.T) {
+	slot := &fakeSlot{err: errors.New("connection refused")}
+	c := newConsumer(slot, "users_slot", "users_pub")
+	c.PollInterval = time.Millisecond
+	c.BatchSize = 1
+	ctx, cancel := context.WithCancel(context.Background())
+	c.OnError = func(err error) {
+		// recover after the first failure
+		slot.err = nil
+		slot.commit(0x100, "alice")
+		slot.commit(0x200, "bob")
+	}
+	done := make(chan error)
+	var ends []LSN
+	go func() {
+		done <- c.Run(ctx, func(ctx context.Context, tx *Tx) error {
+			if ends = append(ends, tx.End); len(ends) == 2 {
+				cancel()
+			}
+			return nil
+		})
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Run => %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run not stopped")
+	}
+	if want := []LSN{0x100, 0x200}; !reflect.DeepEqual(ends, want) {
+		t.Fatalf("Inconsistent transactions: expected: %v, actual: %v", want, ends)
+	}
+}
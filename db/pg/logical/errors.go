@@ -0,0 +1,18 @@
+package logical
+
+import "errors"
+
+// Error returned by package logical
+var (
+	// ErrMalformed occurs when a pgoutput message is truncated or corrupted
+	ErrMalformed = errors.New("malformed pgoutput message")
+
+	// ErrUnknownMessage occurs when a pgoutput message type is not supported
+	ErrUnknownMessage = errors.New("unknown pgoutput message")
+
+	// ErrUnknownRelation occurs when a change refers to a relation not described yet
+	ErrUnknownRelation = errors.New("change of unknown relation")
+
+	// ErrInvalidLSN occurs when a LSN is not in X/X form
+	ErrInvalidLSN = errors.New("invalid lsn")
+)
@@ -0,0 +1,371 @@
+// Package logical consumes row changes from a logical replication slot
+// with the builtin pgoutput plugin. Unlike LISTEN/NOTIFY, changes are
+// retained by the slot until confirmed, so a consumer resumes exactly
+// where it left off after disconnect or restart.
+//
+// Insert and update carry the full new row. Old row of update & delete
+// only has key columns unless table is REPLICA IDENTITY FULL, and
+// unchanged TOASTed values are not sent (Column.Unchanged)
+package logical
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**************************************************************
+* type: LSN
+**************************************************************/
+
+// LSN is a position in write ahead log
+type LSN uint64
+
+// ParseLSN parse LSN in postgres text form X/X
+func ParseLSN(s string) (LSN, error) {
+	hi, lo, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, fmt.Errorf("logical: %q: %w", s, ErrInvalidLSN)
+	}
+	h, err1 := strconv.ParseUint(hi, 16, 32)
+	l, err2 := strconv.ParseUint(lo, 16, 32)
+	if err1 != nil || err2 != nil {
+		return 0, fmt.Errorf("logical: %q: %w", s, ErrInvalidLSN)
+	}
+	return LSN(h<<32 | l), nil
+}
+
+// String returns LSN in postgres text form
+func (l LSN) String() string {
+	return fmt.Sprintf("%X/%X", uint32(l>>32), uint32(l))
+}
+
+/**************************************************************
+* struct: Relation, Row, Change, Tx
+**************************************************************/
+
+// Relation describes a published table, sent before its first change
+type Relation struct {
+	ID              uint32
+	Namespace       string
+	Name            string
+	ReplicaIdentity byte // d default, n nothing, f full, i index
+	Columns         []RelationColumn
+}
+
+// RelationColumn describes a column of relation
+type RelationColumn struct {
+	Name     string
+	Type     uint32 // type oid
+	Modifier int32
+	Key      bool // part of replica identity
+}
+
+// Column is a column value of row
+type Column struct {
+	Name string
+	Type uint32
+	Key  bool
+	// Value : text representation of value, nil if Null or Unchanged
+	Value []byte
+	Null  bool
+	// Unchanged : TOASTed value not changed by update, thus not sent
+	Unchanged bool
+}
+
+// Row is a row image in column order of relation
+type Row []Column
+
+// Get returns text value of column, false if column is missing, null or unchanged
+func (r Row) Get(name string) (string, bool) {
+	for _, c := range r {
+		if c.Name == name {
+			return string(c.Value), !c.Null && !c.Unchanged
+		}
+	}
+	return "", false
+}
+
+// Action of change
+type Action byte
+
+// Change actions
+const (
+	Insert   Action = 'I'
+	Update   Action = 'U'
+	Delete   Action = 'D'
+	Truncate Action = 'T'
+)
+
+// String implements fmt.Stringer
+func (a Action) String() string {
+	switch a {
+	case Insert:
+		return "insert"
+	case Update:
+		return "update"
+	case Delete:
+		return "delete"
+	case Truncate:
+		return "truncate"
+	}
+	return "unknown"
+}
+
+// Change is a row change, or a truncate of Relation
+type Change struct {
+	Action   Action
+	Relation *Relation
+	// Old : old image of update (only if key changed or replica identity
+	// is full) and delete (key columns only unless replica identity full)
+	Old Row
+	// New : new image of insert & update
+	New Row
+}
+
+// Table returns schema qualified table name of change
+func (c Change) Table() string {
+	return c.Relation.Namespace + "." + c.Relation.Name
+}
+
+// Tx is a committed transaction
+type Tx struct {
+	XID uint32
+	// LSN : commit record position, End : position right after commit
+	LSN     LSN
+	End     LSN
+	Time    time.Time
+	Changes []Change
+}
+
+/**************************************************************
+* struct: Decoder
+**************************************************************/
+
+// Decoder assembles pgoutput (protocol version 1) messages into
+// transactions. Relations are remembered across transactions
+type Decoder struct {
+	relations map[uint32]*Relation
+	tx        *Tx
+}
+
+// NewDecoder create a decoder
+func NewDecoder() *Decoder {
+	return &Decoder{relations: map[uint32]*Relation{}}
+}
+
+// Relation returns relation by oid if described
+func (d *Decoder) Relation(id uint32) (*Relation, bool) {
+	r, ok := d.relations[id]
+	return r, ok
+}
+
+// Decode feed a message, returns the transaction when its commit arrives
+func (d *Decoder) Decode(msg []byte) (*Tx, error) {
+	if len(msg) == 0 {
+		return nil, fmt.Errorf("logical: empty message: %w", ErrMalformed)
+	}
+	r := &reader{buf: msg[1:]}
+	switch msg[0] {
+	case 'B':
+		final, ts, xid := LSN(r.uint64()), r.time(), r.uint32()
+		d.tx = &Tx{XID: xid, LSN: final, Time: ts}
+	case 'C':
+		r.uint8() // flags, unused
+		lsn, end, ts := LSN(r.uint64()), LSN(r.uint64()), r.time()
+		if r.err == nil {
+			tx := d.tx
+			if tx == nil {
+				return nil, fmt.Errorf("logical: commit without begin: %w", ErrMalformed)
+			}
+			d.tx = nil
+			tx.LSN, tx.End, tx.Time = lsn, end, ts
+			return tx, nil
+		}
+	case 'R':
+		rel := &Relation{ID: r.uint32(), Namespace: r.string(), Name: r.string(), ReplicaIdentity: r.uint8()}
+		rel.Columns = make([]RelationColumn, r.uint16())
+		for i := range rel.Columns {
+			if r.err != nil {
+				break
+			}
+			flags := r.uint8()
+			rel.Columns[i] = RelationColumn{Key: flags&1 != 0, Name: r.string(), Type: r.uint32(), Modifier: int32(r.uint32())}
+		}
+		if r.err == nil {
+			d.relations[rel.ID] = rel
+		}
+	case 'I', 'U', 'D':
+		return nil, d.change(Action(msg[0]), r)
+	case 'T':
+		n := r.uint32()
+		r.uint8() // options: cascade, restart identity
+		for i := uint32(0); i < n && r.err == nil; i++ {
+			rel, err := d.relation(r.uint32())
+			if err != nil {
+				return nil, err
+			}
+			if err := d.add(Change{Action: Truncate, Relation: rel}); err != nil {
+				return nil, err
+			}
+		}
+	case 'Y', 'O', 'M':
+		// type, origin & generic messages are irrelevant to row changes
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("logical: message %q: %w", msg[0], ErrUnknownMessage)
+	}
+	return nil, r.err
+}
+
+// change decode insert, update and delete
+func (d *Decoder) change(action Action, r *reader) error {
+	rel, err := d.relation(r.uint32())
+	if err != nil {
+		return err
+	}
+	change := Change{Action: action, Relation: rel}
+	for r.err == nil && len(r.buf) > 0 {
+		switch kind := r.uint8(); kind {
+		case 'K', 'O':
+			change.Old = r.row(rel)
+		case 'N':
+			change.New = r.row(rel)
+		default:
+			return fmt.Errorf("logical: tuple kind %q: %w", kind, ErrMalformed)
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+	return d.add(change)
+}
+
+// relation lookup relation, which must be described before use
+func (d *Decoder) relation(id uint32) (*Relation, error) {
+	rel, ok := d.relations[id]
+	if !ok {
+		return nil, fmt.Errorf("logical: relation %d: %w", id, ErrUnknownRelation)
+	}
+	return rel, nil
+}
+
+// add append change to current transaction
+func (d *Decoder) add(change Change) error {
+	if d.tx == nil {
+		return fmt.Errorf("logical: %s outside transaction: %w", change.Action, ErrMalformed)
+	}
+	d.tx.Changes = append(d.tx.Changes, change)
+	return nil
+}
+
+/**************************************************************
+* struct: reader
+**************************************************************/
+
+// pgEpoch is the origin of postgres timestamps
+var pgEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// reader reads big endian fields, the first failure sticks in err
+type reader struct {
+	buf []byte
+	err error
+}
+
+// next consume n bytes, nil if not enough
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.buf) < n {
+		r.err = fmt.Errorf("logical: truncated message: %w", ErrMalformed)
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) uint8() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) uint16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *reader) uint64() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+// time read microseconds since 2000-01-01
+func (r *reader) time() time.Time {
+	return pgEpoch.Add(time.Duration(int64(r.uint64())) * time.Microsecond)
+}
+
+// string read null terminated string
+func (r *reader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	for i, c := range r.buf {
+		if c == 0 {
+			s := string(r.buf[:i])
+			r.buf = r.buf[i+1:]
+			return s
+		}
+	}
+	r.err = fmt.Errorf("logical: unterminated string: %w", ErrMalformed)
+	return ""
+}
+
+// row read tuple data of relation
+func (r *reader) row(rel *Relation) Row {
+	n := int(r.uint16())
+	if r.err == nil && n != len(rel.Columns) {
+		r.err = fmt.Errorf("logical: %s.%s has %d columns, tuple has %d: %w",
+			rel.Namespace, rel.Name, len(rel.Columns), n, ErrMalformed)
+	}
+	if r.err != nil {
+		return nil
+	}
+	row := make(Row, n)
+	for i, col := range rel.Columns {
+		row[i] = Column{Name: col.Name, Type: col.Type, Key: col.Key}
+		switch kind := r.uint8(); kind {
+		case 'n':
+			row[i].Null = true
+		case 'u':
+			row[i].Unchanged = true
+		case 't', 'b':
+			size := int(int32(r.uint32()))
+			row[i].Value = append([]byte{}, r.next(size)...)
+		default:
+			if r.err == nil {
+				r.err = fmt.Errorf("logical: column kind %q: %w", kind, ErrMalformed)
+			}
+		}
+		if r.err != nil {
+			return nil
+		}
+	}
+	return row
+}
@@ -0,0 +1,163 @@
+package logical
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// msg build a pgoutput message from fields: byte, uint16, uint32,
+// uint64, string (null terminated) and []byte (raw)
+func msg(fields ...interface{}) []byte {
+	var b []byte
+	for _, f := range fields {
+		switch v := f.(type) {
+		case byte:
+			b = append(b, v)
+		case uint16:
+			b = binary.BigEndian.AppendUint16(b, v)
+		case uint32:
+			b = binary.BigEndian.AppendUint32(b, v)
+		case uint64:
+			b = binary.BigEndian.AppendUint64(b, v)
+		case string:
+			b = append(append(b, v...), 0)
+		case []byte:
+			b = append(b, v...)
+		}
+	}
+	return b
+}
+
+// text build a text column of tuple data
+func text(s string) []byte {
+	return msg(byte('t'), uint32(len(s)), []byte(s))
+}
+
+// usersRelation describes public.users(id int8 key, name text)
+var usersRelation = msg(byte('R'), uint32(16384), "public", "users", byte('d'), uint16(2),
+	byte(1), "id", uint32(20), uint32(0xFFFFFFFF),
+	byte(0), "name", uint32(25), uint32(0xFFFFFFFF))
+
+// begin and commit of xid 7 ending at lsn end
+func begin(end uint64) []byte {
+	return msg(byte('B'), end, uint64(time.Second/time.Microsecond), uint32(7))
+}
+
+func commit(end uint64) []byte {
+	return msg(byte('C'), byte(0), end-8, end, uint64(time.Second/time.Microsecond))
+}
+
+func TestLSN(t *testing.T) {
+	table := []struct {
+		text string
+		lsn  LSN
+	}{
+		{"0/0", 0},
+		{"0/16B3748", 0x16B3748},
+		{"A/FF", 0xA000000FF},
+	}
+	for _, tt := range table {
+		lsn, err := ParseLSN(tt.text)
+		if err != nil || lsn != tt.lsn {
+			t.Errorf("Inconsistent ParseLSN(%s): expected: %d, actual: %d (%v)", tt.text, tt.lsn, lsn, err)
+		}
+		if lsn.String() != tt.text {
+			t.Errorf("Inconsistent LSN string: expected: %s, actual: %s", tt.text, lsn)
+		}
+	}
+	for _, s := range []string{"", "16B3748", "x/1", "1/100000000"} {
+		if _, err := ParseLSN(s); !errors.Is(err, ErrInvalidLSN) {
+			t.Errorf("ParseLSN(%q) => %v, want %v", s, err, ErrInvalidLSN)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	d := NewDecoder()
+	messages := [][]byte{
+		begin(0x100),
+		usersRelation,
+		msg(byte('Y'), uint32(1), "public", "mood"),
+		msg(byte('I'), uint32(16384), byte('N'), uint16(2), text("1"), text("alice")),
+		msg(byte('U'), uint32(16384), byte('N'), uint16(2), text("1"), byte('u')),
+		msg(byte('U'), uint32(16384), byte('K'), uint16(2), text("1"), byte('n'), byte('N'), uint16(2), text("2"), byte('n')),
+		msg(byte('D'), uint32(16384), byte('K'), uint16(2), text("2"), byte('n')),
+		msg(byte('T'), uint32(1), byte(0), uint32(16384)),
+	}
+	for _, m := range messages {
+		if tx, err := d.Decode(m); err != nil || tx != nil {
+			t.Fatalf("Decode(%q) => %v %v before commit", m[0], tx, err)
+		}
+	}
+	tx, err := d.Decode(commit(0x100))
+	if err != nil || tx == nil {
+		t.Fatalf("commit => %v %v", tx, err)
+	}
+	if tx.XID != 7 || tx.End != 0x100 || tx.LSN != 0xF8 || !tx.Time.Equal(pgEpoch.Add(time.Second)) {
+		t.Fatalf("Inconsistent tx: %+v", tx)
+	}
+
+	actions := make([]Action, len(tx.Changes))
+	for i, c := range tx.Changes {
+		actions[i] = c.Action
+	}
+	if want := []Action{Insert, Update, Update, Delete, Truncate}; !reflect.DeepEqual(actions, want) {
+		t.Fatalf("Inconsistent actions: expected: %v, actual: %v", want, actions)
+	}
+	insert := tx.Changes[0]
+	if insert.Table() != "public.users" || insert.Old != nil {
+		t.Fatalf("Inconsistent insert: %+v", insert)
+	}
+	if name, ok := insert.New.Get("name"); !ok || name != "alice" {
+		t.Fatalf("Inconsistent name: expected: %s, actual: %s", "alice", name)
+	}
+	if !insert.New[0].Key || insert.New[0].Type != 20 {
+		t.Fatalf("Inconsistent column: %+v", insert.New[0])
+	}
+	if _, ok := tx.Changes[1].New.Get("name"); ok || !tx.Changes[1].New[1].Unchanged {
+		t.Fatalf("unchanged toast reported as value: %+v", tx.Changes[1].New)
+	}
+	if id, _ := tx.Changes[2].Old.Get("id"); id != "1" {
+		t.Fatalf("Inconsistent old key: expected: %s, actual: %s", "1", id)
+	}
+	if _, ok := tx.Changes[3].Old.Get("name"); ok || !tx.Changes[3].Old[1].Null {
+		t.Fatalf("Inconsistent delete image: %+v", tx.Changes[3].Old)
+	}
+
+	// relations are remembered across transactions
+	if _, ok := d.Relation(16384); !ok {
+		t.Fatal("relation forgotten")
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	table := []struct {
+		name string
+		msgs [][]byte
+		err  error
+	}{
+		{"empty", [][]byte{{}}, ErrMalformed},
+		{"unknown", [][]byte{{'S'}}, ErrUnknownMessage},
+		{"truncated", [][]byte{{'B', 0, 1}}, ErrMalformed},
+		{"no begin", [][]byte{commit(0x100)}, ErrMalformed},
+		{"relation", [][]byte{begin(0x100), msg(byte('I'), uint32(1), byte('N'), uint16(0))}, ErrUnknownRelation},
+		{"columns", [][]byte{begin(0x100), usersRelation, msg(byte('I'), uint32(16384), byte('N'), uint16(1), text("1"))}, ErrMalformed},
+		{"tuple", [][]byte{begin(0x100), usersRelation, msg(byte('I'), uint32(16384), byte('X'))}, ErrMalformed},
+		{"string", [][]byte{msg(byte('R'), uint32(1), []byte("public"))}, ErrMalformed},
+	}
+	for _, tt := range table {
+		d := NewDecoder()
+		var err error
+		for _, m := range tt.msgs {
+			if _, err = d.Decode(m); err != nil {
+				break
+			}
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: Decode => %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error returned by package migrate
+var (
+	// ErrInvalidName occurs when a file in migration directory is not
+	// named like <version>_<name>.up.sql or <version>_<name>.down.sql
+	ErrInvalidName = errors.New("migration file should be named <version>_<name>.(up|down).sql")
+
+	// ErrDuplicateVersion occurs when two migrations share a version
+	ErrDuplicateVersion = errors.New("duplicate migration version")
+
+	// ErrMissingUp occurs when a version only has a down file
+	ErrMissingUp = errors.New("migration has no up file")
+
+	// ErrIrreversible occurs when reverting a migration without down file
+	ErrIrreversible = errors.New("migration has no down file")
+
+	// ErrWouldRevert occurs when migrating up to a target below an applied
+	// version, which would revert it, use down instead
+	ErrWouldRevert = errors.New("applied migration is above target, up never reverts")
+
+	// ErrWouldApply occurs when migrating down to a target above a pending
+	// version, which would apply it, use up instead
+	ErrWouldApply = errors.New("pending migration is not above target, down never applies")
+
+	// ErrChecksum occurs when an applied migration file has been modified
+	ErrChecksum = errors.New("applied migration has been modified")
+
+	// ErrUnknownVersion occurs when database has a version not found in files
+	ErrUnknownVersion = errors.New("applied migration not found in files")
+)
+
+// VersionError tells which migration an error is about
+type VersionError struct {
+	Version int64
+	Name    string
+	Err     error
+}
+
+// Error implements error
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("migrate: %d_%s: %v", e.Version, e.Name, e.Err)
+}
+
+// Unwrap returns underlying reason
+func (e *VersionError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,337 @@
+package migrate
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+import gopg "github.com/go-pg/pg"
+import "github.com/go-pg/pg/orm"
+import "github.com/Vonng/gopher/db/pg"
+
+// Latest as target means all migrations
+const Latest int64 = math.MaxInt64
+
+// DefaultTable records applied migrations
+const DefaultTable = "schema_migrations"
+
+// lockPoll is the interval between advisory lock attempts
+const lockPoll = 500 * time.Millisecond
+
+// Options tweaks Migrator
+type Options struct {
+	// Table : name of version table, could be schema qualified
+	Table string
+	// DryRun : only report steps, nothing is changed
+	DryRun bool
+	// Logf : progress log, discarded if nil
+	Logf func(format string, args ...interface{})
+}
+
+// Record is a row of version table
+type Record struct {
+	Version   int64
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// Step is a migration to apply, or to revert if Revert is set
+type Step struct {
+	Migration
+	Revert bool
+}
+
+// SQL returns statements to execute for this step
+func (s Step) SQL() string {
+	if s.Revert {
+		return s.Down
+	}
+	return s.Up
+}
+
+// Status describes a migration from both files and database
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	// Modified : applied but file content changed since
+	Modified bool
+	// Missing : applied but file not found
+	Missing bool
+}
+
+/**************************************************************
+* struct: Migrator
+**************************************************************/
+
+// Migrator applies migrations to a database, holding an advisory lock
+// so concurrent instances (e.g. replicas of a service starting together)
+// run them only once. It uses a single connection from the pool
+type Migrator struct {
+	db         *pg.DB
+	migrations []Migration
+	opts       Options
+}
+
+// New create a migrator, migrations are usually from Load or Dir
+func New(db *pg.DB, migrations []Migration, opts Options) *Migrator {
+	if opts.Table == "" {
+		opts.Table = DefaultTable
+	}
+	if opts.Logf == nil {
+		opts.Logf = func(string, ...interface{}) {}
+	}
+	return &Migrator{db: db, migrations: migrations, opts: opts}
+}
+
+// Migrate bring database to target version: revert applied migrations
+// above target, then apply pending ones up to target. returns steps
+// executed, or to be executed in dry run. Each step runs in its own
+// transaction unless it starts with NoTxDirective
+func (m *Migrator) Migrate(ctx context.Context, target int64) ([]Step, error) {
+	return m.migrate(ctx, target, both)
+}
+
+// Up apply all pending migrations
+func (m *Migrator) Up(ctx context.Context) ([]Step, error) {
+	return m.migrate(ctx, Latest, upOnly)
+}
+
+// UpTo apply pending migrations up to target, it never reverts:
+// ErrWouldRevert if target is below an applied version
+func (m *Migrator) UpTo(ctx context.Context, target int64) ([]Step, error) {
+	return m.migrate(ctx, target, upOnly)
+}
+
+// DownTo revert applied migrations above target, it never applies:
+// ErrWouldApply if a pending migration is not above target
+func (m *Migrator) DownTo(ctx context.Context, target int64) ([]Step, error) {
+	return m.migrate(ctx, target, downOnly)
+}
+
+// migrate plan and run steps in given direction. Everything runs on the
+// connection holding the advisory lock, so it needs only one connection
+func (m *Migrator) migrate(ctx context.Context, target int64, dir direction) ([]Step, error) {
+	conn := m.db.WithContext(ctx).Conn()
+	defer conn.Close()
+	unlock, err := m.lock(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if !m.opts.DryRun {
+		if _, err := conn.Exec(`CREATE TABLE IF NOT EXISTS ? (
+  version    BIGINT PRIMARY KEY,
+  name       TEXT        NOT NULL,
+  checksum   TEXT        NOT NULL,
+  applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`, gopg.F(m.opts.Table)); err != nil {
+			return nil, err
+		}
+	}
+	applied, err := m.applied(conn)
+	if err != nil {
+		return nil, err
+	}
+	steps, err := plan(m.migrations, applied, target, dir)
+	if err != nil {
+		return nil, err
+	}
+	if m.opts.DryRun {
+		for _, step := range steps {
+			m.opts.Logf("migrate: dry run %s", describe(step))
+		}
+		return steps, nil
+	}
+	for i, step := range steps {
+		m.opts.Logf("migrate: %s", describe(step))
+		start := time.Now()
+		if err := m.run(conn, step); err != nil {
+			return steps[:i], &VersionError{step.Version, step.Name, err}
+		}
+		m.opts.Logf("migrate: %s done in %v", describe(step), time.Since(start))
+	}
+	return steps, nil
+}
+
+// Status list all migrations known by files or database, ordered by version
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn := m.db.WithContext(ctx).Conn()
+	defer conn.Close()
+	applied, err := m.applied(conn)
+	if err != nil {
+		return nil, err
+	}
+	return status(m.migrations, applied), nil
+}
+
+// applied read version table, empty if table not exists yet
+func (m *Migrator) applied(conn *gopg.Conn) (map[int64]Record, error) {
+	var exists bool
+	if _, err := conn.QueryOne(gopg.Scan(&exists), `SELECT to_regclass(?) IS NOT NULL`, m.opts.Table); err != nil {
+		return nil, err
+	}
+	applied := map[int64]Record{}
+	if !exists {
+		return applied, nil
+	}
+	var records []Record
+	if _, err := conn.Query(&records, `SELECT version, name, checksum, applied_at FROM ?`, gopg.F(m.opts.Table)); err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+// run execute one step and update version table
+func (m *Migrator) run(conn *gopg.Conn, step Step) error {
+	record := func(exec func(query interface{}, params ...interface{}) (orm.Result, error)) error {
+		var err error
+		if step.Revert {
+			_, err = exec(`DELETE FROM ? WHERE version = ?`, gopg.F(m.opts.Table), step.Version)
+		} else {
+			_, err = exec(`INSERT INTO ? (version, name, checksum) VALUES (?, ?, ?)`,
+				gopg.F(m.opts.Table), step.Version, step.Name, step.Checksum)
+		}
+		return err
+	}
+
+	if NoTx(step.SQL()) {
+		if _, err := conn.Exec(step.SQL()); err != nil {
+			return err
+		}
+		return record(conn.Exec)
+	}
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(step.SQL()); err != nil {
+		return err
+	}
+	if err := record(tx.Exec); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// lock wait for a session level advisory lock keyed by table name on conn,
+// which is held until unlock. Steps must run on conn as well: with lock on
+// one pooled connection and steps on another, a pool of one would deadlock
+func (m *Migrator) lock(ctx context.Context, conn *gopg.Conn) (unlock func(), err error) {
+	key := "migrate:" + m.opts.Table
+	for waited := false; ; waited = true {
+		var ok bool
+		if _, err := conn.QueryOne(gopg.Scan(&ok), `SELECT pg_try_advisory_lock(hashtext(?))`, key); err != nil {
+			return nil, err
+		}
+		if ok {
+			return func() {
+				if _, err := conn.Exec(`SELECT pg_advisory_unlock(hashtext(?))`, key); err != nil {
+					m.opts.Logf("migrate: release lock: %v", err)
+				}
+			}, nil
+		}
+		if !waited {
+			m.opts.Logf("migrate: waiting for another instance to finish")
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPoll):
+		}
+	}
+}
+
+/**************************************************************
+* planning
+**************************************************************/
+
+// direction restricts steps of a plan
+type direction int
+
+const (
+	both direction = iota
+	upOnly
+	downOnly
+)
+
+// plan compute steps from applied to target: reverts in descending
+// order, then applies in ascending order. applied migrations must be
+// present and unmodified in files, and steps must be in direction
+func plan(migrations []Migration, applied map[int64]Record, target int64, dir direction) ([]Step, error) {
+	known := make(map[int64]bool, len(migrations))
+	for _, m := range migrations {
+		known[m.Version] = true
+		if r, ok := applied[m.Version]; ok && r.Checksum != m.Checksum {
+			return nil, &VersionError{m.Version, m.Name, ErrChecksum}
+		}
+	}
+	for _, r := range applied {
+		if !known[r.Version] {
+			return nil, &VersionError{r.Version, r.Name, ErrUnknownVersion}
+		}
+	}
+
+	var steps []Step
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; ok && m.Version > target {
+			if dir == upOnly {
+				return nil, &VersionError{m.Version, m.Name, ErrWouldRevert}
+			}
+			if m.Down == "" {
+				return nil, &VersionError{m.Version, m.Name, ErrIrreversible}
+			}
+			steps = append(steps, Step{Migration: m, Revert: true})
+		}
+	}
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok && m.Version <= target {
+			if dir == downOnly {
+				return nil, &VersionError{m.Version, m.Name, ErrWouldApply}
+			}
+			steps = append(steps, Step{Migration: m})
+		}
+	}
+	return steps, nil
+}
+
+// status merge files and version table
+func status(migrations []Migration, applied map[int64]Record) []Status {
+	var res []Status
+	known := make(map[int64]bool, len(migrations))
+	for _, m := range migrations {
+		known[m.Version] = true
+		s := Status{Version: m.Version, Name: m.Name}
+		if r, ok := applied[m.Version]; ok {
+			s.Applied, s.AppliedAt, s.Modified = true, r.AppliedAt, r.Checksum != m.Checksum
+		}
+		res = append(res, s)
+	}
+	for _, r := range applied {
+		if !known[r.Version] {
+			res = append(res, Status{Version: r.Version, Name: r.Name, Applied: true, AppliedAt: r.AppliedAt, Missing: true})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Version < res[j].Version })
+	return res
+}
+
+// describe returns e.g. "up 3_add_email"
+func describe(step Step) string {
+	direction := "up"
+	if step.Revert {
+		direction = "down"
+	}
+	return direction + " " + strconv.FormatInt(step.Version, 10) + "_" + step.Name
+}
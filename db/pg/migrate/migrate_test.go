@@ -0,0 +1,132 @@
+package migrate
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+)
+
+func testMigrations(t *testing.T) []Migration {
+	fsys := fstest.MapFS{
+		"sql/0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id TEXT PRIMARY KEY);")},
+		"sql/0001_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		"sql/0002_add_name.up.sql":       {Data: []byte("ALTER TABLE users ADD name TEXT;")},
+		"sql/0002_add_name.down.sql":     {Data: []byte("ALTER TABLE users DROP name;")},
+		"sql/0010_index_name.up.sql":     {Data: []byte(NoTxDirective + "\nCREATE INDEX CONCURRENTLY ON users (name);")},
+		"sql/README.md":                  {Data: []byte("ignored")},
+	}
+	migrations, err := Load(fsys, "sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return migrations
+}
+
+func TestLoad(t *testing.T) {
+	migrations := testMigrations(t)
+	if len(migrations) != 3 {
+		t.Fatalf("Inconsistent migration count: expected: %d, actual: %d", 3, len(migrations))
+	}
+	for i, want := range []int64{1, 2, 10} {
+		if migrations[i].Version != want {
+			t.Errorf("Inconsistent version: expected: %d, actual: %d", want, migrations[i].Version)
+		}
+	}
+	if m := migrations[0]; m.Name != "create_users" || m.Down != "DROP TABLE users;" || len(m.Checksum) != 64 {
+		t.Errorf("Inconsistent migration: %+v", m)
+	}
+	if NoTx(migrations[1].Up) || !NoTx(migrations[2].Up) {
+		t.Error("no-transaction directive not detected")
+	}
+
+	table := []struct {
+		files fstest.MapFS
+		err   error
+	}{
+		{fstest.MapFS{"1_a.sql": {}}, ErrInvalidName},
+		{fstest.MapFS{"x_a.up.sql": {}}, ErrInvalidName},
+		{fstest.MapFS{"1_a.up.sql": {Data: []byte("x")}, "1_b.up.sql": {Data: []byte("y")}}, ErrDuplicateVersion},
+		{fstest.MapFS{"01_a.up.sql": {Data: []byte("x")}, "1_a.up.sql": {Data: []byte("y")}}, ErrDuplicateVersion},
+		{fstest.MapFS{"1_a.down.sql": {Data: []byte("x")}}, ErrMissingUp},
+	}
+	for _, tt := range table {
+		if _, err := Load(tt.files, "."); !errors.Is(err, tt.err) {
+			t.Errorf("Load(%v) => %v, want %v", tt.files, err, tt.err)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	migrations := testMigrations(t)
+	record := func(m Migration) Record { return Record{Version: m.Version, Name: m.Name, Checksum: m.Checksum} }
+	applied := map[int64]Record{1: record(migrations[0])}
+
+	table := []struct {
+		target int64
+		want   []string
+	}{
+		{Latest, []string{"up 2_add_name", "up 10_index_name"}},
+		{2, []string{"up 2_add_name"}},
+		{1, nil},
+		{0, []string{"down 1_create_users"}},
+	}
+	for _, tt := range table {
+		steps, err := plan(migrations, applied, tt.target, both)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, step := range steps {
+			got = append(got, describe(step))
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("plan to %d => %v, want %v", tt.target, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("plan to %d => %v, want %v", tt.target, got, tt.want)
+			}
+		}
+	}
+	if step := (Step{Migration: migrations[0], Revert: true}); step.SQL() != migrations[0].Down {
+		t.Errorf("Inconsistent revert sql: %q", step.SQL())
+	}
+
+	// up never reverts, down never applies
+	applied[2] = record(migrations[1])
+	if _, err := plan(migrations, applied, 1, upOnly); !errors.Is(err, ErrWouldRevert) {
+		t.Errorf("plan up to 1 at version 2 => %v, want %v", err, ErrWouldRevert)
+	}
+	if steps, err := plan(migrations, applied, Latest, upOnly); err != nil || len(steps) != 1 || steps[0].Revert {
+		t.Errorf("plan up to latest => %v %v", steps, err)
+	}
+	if _, err := plan(migrations, applied, Latest, downOnly); !errors.Is(err, ErrWouldApply) {
+		t.Errorf("plan down to latest at version 2 => %v, want %v", err, ErrWouldApply)
+	}
+	if steps, err := plan(migrations, applied, 1, downOnly); err != nil || len(steps) != 1 || !steps[0].Revert {
+		t.Errorf("plan down to 1 => %v %v", steps, err)
+	}
+	delete(applied, 2)
+
+	// reverting a migration without down file
+	applied[10] = record(migrations[2])
+	if _, err := plan(migrations, applied, 1, both); !errors.Is(err, ErrIrreversible) {
+		t.Errorf("plan => %v, want %v", err, ErrIrreversible)
+	}
+
+	// modified or unknown applied migrations block everything
+	applied = map[int64]Record{1: {Version: 1, Name: "create_users", Checksum: "stale"}}
+	if _, err := plan(migrations, applied, Latest, both); !errors.Is(err, ErrChecksum) {
+		t.Errorf("plan => %v, want %v", err, ErrChecksum)
+	}
+	applied = map[int64]Record{99: {Version: 99, Name: "future"}}
+	if _, err := plan(migrations, applied, Latest, both); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("plan => %v, want %v", err, ErrUnknownVersion)
+	}
+
+	list := status(migrations, map[int64]Record{1: {Version: 1, Checksum: "stale"}, 5: {Version: 5, Name: "gone"}})
+	if len(list) != 4 || !list[0].Modified || list[1].Applied || !list[2].Missing || list[3].Version != 10 {
+		t.Errorf("Inconsistent status: %+v", list)
+	}
+}
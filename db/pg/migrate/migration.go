@@ -0,0 +1,113 @@
+package migrate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NoTxDirective as first line of a file makes it run outside transaction,
+// e.g. for CREATE INDEX CONCURRENTLY
+const NoTxDirective = "-- migrate:no-transaction"
+
+// Migration is a versioned schema change with optional revert
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	// Checksum : sha256 of Up, recorded when applied
+	Checksum string
+}
+
+// NoTx reports whether sql should run outside transaction
+func NoTx(sql string) bool {
+	return strings.HasPrefix(strings.TrimSpace(sql), NoTxDirective)
+}
+
+// Load read migrations from dir of fsys, which could be an embed.FS,
+// files are named <version>_<name>.up.sql and <version>_<name>.down.sql,
+// other files are ignored. result is sorted by version
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		version, name, up, err := parseFilename(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, &VersionError{version, name, ErrDuplicateVersion}
+		}
+		if up {
+			if m.Up != "" {
+				return nil, &VersionError{version, name, ErrDuplicateVersion}
+			}
+			m.Up, m.Checksum = string(data), checksum(data)
+		} else {
+			if m.Down != "" {
+				return nil, &VersionError{version, name, ErrDuplicateVersion}
+			}
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, &VersionError{m.Version, m.Name, ErrMissingUp}
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Dir read migrations from a directory on disk
+func Dir(dir string) ([]Migration, error) {
+	return Load(os.DirFS(dir), ".")
+}
+
+// parseFilename split 0001_create_users.up.sql into 1, create_users, true
+func parseFilename(filename string) (version int64, name string, up bool, err error) {
+	base := strings.TrimSuffix(filename, ".sql")
+	switch {
+	case strings.HasSuffix(base, ".up"):
+		base, up = strings.TrimSuffix(base, ".up"), true
+	case strings.HasSuffix(base, ".down"):
+		base = strings.TrimSuffix(base, ".down")
+	default:
+		return 0, "", false, &VersionError{0, filename, ErrInvalidName}
+	}
+	digits, name, _ := strings.Cut(base, "_")
+	version, err = strconv.ParseInt(digits, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, "", false, &VersionError{0, filename, ErrInvalidName}
+	}
+	return version, name, up, nil
+}
+
+// checksum returns hex encoded sha256 of data
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,21 @@
+package notify
+
+import "errors"
+
+// Error returned by package notify
+var (
+	// ErrNoTable occurs when trigger has no table
+	ErrNoTable = errors.New("table is required")
+
+	// ErrNoKeys occurs when key columns are neither given nor found
+	ErrNoKeys = errors.New("no key columns: table has no primary key")
+
+	// ErrInvalidPayload occurs when a JSON payload is malformed
+	ErrInvalidPayload = errors.New("invalid change payload")
+
+	// ErrPayloadVersion occurs when a JSON payload has unknown version
+	ErrPayloadVersion = errors.New("unsupported change payload version")
+
+	// ErrPayloadTooLarge occurs when even key only payload exceeds NOTIFY limit
+	ErrPayloadTooLarge = errors.New("change payload exceeds NOTIFY limit")
+)
@@ -0,0 +1,108 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PayloadVersion is the version of JSON payload written by this package
+const PayloadVersion = 1
+
+// MaxPayloadSize is the largest NOTIFY payload postgres accepts
+// (8000 bytes including terminator in default build)
+const MaxPayloadSize = 7999
+
+// Change actions, shared by text and JSON payloads
+const (
+	ActionInsert   = "I"
+	ActionUpdate   = "U"
+	ActionDelete   = "D"
+	ActionTruncate = "T"
+)
+
+// Payload is a JSON change notification:
+//
+//	{"v":1,"action":"U","table":"public.users","key":{"id":1},
+//	 "row":{"id":1,"name":"alice"},"txid":1234,"ts":"2018-06-01T12:00:00.123456+08:00"}
+//
+// Row is the new row image of insert & update if enabled. When the
+// payload would exceed MaxPayloadSize, Row is dropped and Fetch is set
+// so that receivers re-fetch the row by Key. When even the key is too
+// large, triggers send a truncate payload instead, so receivers reload
+// everything and the writing transaction never fails
+type Payload struct {
+	Version int                        `json:"v"`
+	Action  string                     `json:"action"`
+	Table   string                     `json:"table"`
+	Key     map[string]json.RawMessage `json:"key,omitempty"`
+	Row     json.RawMessage            `json:"row,omitempty"`
+	Fetch   bool                       `json:"fetch,omitempty"`
+	TxID    int64                      `json:"txid"`
+	Time    time.Time                  `json:"ts"`
+}
+
+// IsJSON reports whether a notification payload is JSON rather than text format
+func IsJSON(payload string) bool {
+	return strings.HasPrefix(payload, "{")
+}
+
+// Encode marshal payload, dropping row image if too large for NOTIFY.
+// p is left untouched
+func Encode(p *Payload) (string, error) {
+	q := *p
+	if q.Version == 0 {
+		q.Version = PayloadVersion
+	}
+	data, err := json.Marshal(&q)
+	if err != nil {
+		return "", err
+	}
+	if len(data) > MaxPayloadSize && q.Row != nil {
+		q.Row, q.Fetch = nil, true
+		if data, err = json.Marshal(&q); err != nil {
+			return "", err
+		}
+	}
+	if len(data) > MaxPayloadSize {
+		return "", fmt.Errorf("notify: %s key %d bytes: %w", p.Table, len(data), ErrPayloadTooLarge)
+	}
+	return string(data), nil
+}
+
+// Decode unmarshal and validate a JSON payload
+func Decode(payload string) (*Payload, error) {
+	var p Payload
+	if err := json.Unmarshal([]byte(payload), &p); err != nil {
+		return nil, fmt.Errorf("notify: %w: %v", ErrInvalidPayload, err)
+	}
+	if p.Version != PayloadVersion {
+		return nil, fmt.Errorf("notify: version %d: %w", p.Version, ErrPayloadVersion)
+	}
+	switch p.Action {
+	case ActionInsert, ActionUpdate, ActionDelete:
+		if len(p.Key) == 0 {
+			return nil, fmt.Errorf("notify: %s without key: %w", p.Action, ErrInvalidPayload)
+		}
+	case ActionTruncate:
+	default:
+		return nil, fmt.Errorf("notify: action %q: %w", p.Action, ErrInvalidPayload)
+	}
+	return &p, nil
+}
+
+// DecodeKey unmarshal key into v: the only value if key has a single
+// column, otherwise the whole key object (e.g. into a struct)
+func (p *Payload) DecodeKey(v interface{}) error {
+	if len(p.Key) == 1 {
+		for _, value := range p.Key {
+			return json.Unmarshal(value, v)
+		}
+	}
+	data, err := json.Marshal(p.Key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,81 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPayload(t *testing.T) {
+	// as produced by trigger function
+	p, err := Decode(`{"v": 1, "action": "U", "table": "public.users", "key": {"id": 42},
+		"row": {"id": 42, "name": "alice"}, "txid": 1234, "ts": "2018-06-01T12:00:00.123456+08:00"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Action != ActionUpdate || p.Table != "public.users" || p.TxID != 1234 || p.Fetch ||
+		!p.Time.Equal(time.Date(2018, 6, 1, 4, 0, 0, 123456000, time.UTC)) {
+		t.Fatalf("Inconsistent payload: %+v", p)
+	}
+	var id int64
+	if err := p.DecodeKey(&id); err != nil || id != 42 {
+		t.Fatalf("Inconsistent key: expected: %d, actual: %d (%v)", 42, id, err)
+	}
+
+	// composite key decodes into struct
+	p, _ = Decode(`{"v":1,"action":"D","table":"t","key":{"order_id":1,"line":"a"},"txid":1,"ts":"2018-06-01T12:00:00Z"}`)
+	var key struct {
+		OrderID int64  `json:"order_id"`
+		Line    string `json:"line"`
+	}
+	if err := p.DecodeKey(&key); err != nil || key.OrderID != 1 || key.Line != "a" {
+		t.Fatalf("Inconsistent composite key: %+v (%v)", key, err)
+	}
+
+	table := []struct {
+		in  string
+		err error
+	}{
+		{`not json`, ErrInvalidPayload},
+		{`{"v":2,"action":"I","key":{"id":1}}`, ErrPayloadVersion},
+		{`{"v":1,"action":"X","key":{"id":1}}`, ErrInvalidPayload},
+		{`{"v":1,"action":"I"}`, ErrInvalidPayload},
+	}
+	for _, tt := range table {
+		if _, err := Decode(tt.in); !errors.Is(err, tt.err) {
+			t.Errorf("Decode(%s) => %v, want %v", tt.in, err, tt.err)
+		}
+	}
+	if _, err := Decode(`{"v":1,"action":"T","table":"t"}`); err != nil {
+		t.Errorf("truncate without key should be valid: %v", err)
+	}
+}
+
+func TestEncodeFallback(t *testing.T) {
+	row, _ := json.Marshal(map[string]string{"blob": strings.Repeat("x", MaxPayloadSize)})
+	p := &Payload{Action: ActionInsert, Table: "t", Key: map[string]json.RawMessage{"id": json.RawMessage("1")}, Row: row}
+	s, err := Encode(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s) > MaxPayloadSize || !IsJSON(s) {
+		t.Fatalf("Inconsistent payload size: %d", len(s))
+	}
+	decoded, err := Decode(s)
+	if err != nil || !decoded.Fetch || decoded.Row != nil {
+		t.Fatalf("oversized row should be dropped and marked fetch: %+v (%v)", decoded, err)
+	}
+	if p.Row == nil || p.Fetch || p.Version != 0 {
+		t.Fatalf("Encode modified its argument: %+v", p)
+	}
+
+	p = &Payload{Action: ActionInsert, Table: "t", Key: map[string]json.RawMessage{"id": json.RawMessage(`"` + strings.Repeat("x", MaxPayloadSize) + `"`)}}
+	if _, err := Encode(p); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("Encode => %v, want %v", err, ErrPayloadTooLarge)
+	}
+	if IsJSON("I42") {
+		t.Fatal("text payload detected as JSON")
+	}
+}
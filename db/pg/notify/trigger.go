@@ -0,0 +1,366 @@
+// Package notify generates triggers sending row changes through
+// LISTEN/NOTIFY, in the formats consumed by package synccache.
+// Text format:
+//
+//	I<key>  row inserted          U<key>  row updated
+//	D<key>  row deleted           T       table truncated
+//
+// key is the text of the key column, or a JSON array of key columns
+// if key is composite. JSON format is described by Payload.
+// In both formats an update changing the key is sent as delete of
+// old key followed by insert of new key, and a payload whose key is too
+// large for NOTIFY is sent as truncate, so receivers reload everything
+// instead of the write failing
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+import gopg "github.com/go-pg/pg"
+import "github.com/Vonng/gopher/db/pg"
+
+// Trigger describes change notification of a table
+type Trigger struct {
+	// Table : table name, could be schema qualified
+	Table string
+	// Channel : notification channel, <table>_chan by default
+	Channel string
+	// Keys : columns identifying a row, primary key by default (see Detect)
+	Keys []string
+	// Columns : only notify updates changing these columns (keys always
+	// included), any update is notified if empty
+	Columns []string
+	// Truncate : also notify TRUNCATE, so caches can drop all rows
+	Truncate bool
+	// Format : payload format, FormatText by default
+	Format Format
+	// Row : include new row image in JSON payload, only Columns and
+	// Keys if Columns is given, whole row otherwise
+	Row bool
+}
+
+// Format of notification payload
+type Format int
+
+// Payload formats
+const (
+	// FormatText : <I|U|D><key> or T, see package doc
+	FormatText Format = iota
+	// FormatJSON : versioned JSON, see Payload
+	FormatJSON
+)
+
+// schemaTable split table into optional schema and name
+func (t Trigger) schemaTable() (schema, name string) {
+	if i := strings.LastIndexByte(t.Table, '.'); i >= 0 {
+		return t.Table[:i], t.Table[i+1:]
+	}
+	return "", t.Table
+}
+
+// qualified returns quoted name in table's schema
+func (t Trigger) qualified(name string) string {
+	if schema, _ := t.schemaTable(); schema != "" {
+		return quoteIdent(schema) + "." + quoteIdent(name)
+	}
+	return quoteIdent(name)
+}
+
+// table returns quoted table name
+func (t Trigger) table() string {
+	_, name := t.schemaTable()
+	return t.qualified(name)
+}
+
+// channel returns channel name
+func (t Trigger) channel() string {
+	if t.Channel != "" {
+		return t.Channel
+	}
+	_, name := t.schemaTable()
+	return name + "_chan"
+}
+
+// names returns function, row trigger, update trigger and truncate trigger names
+func (t Trigger) names() (function, row, update, truncate string) {
+	_, name := t.schemaTable()
+	return name + "_notify_change", name + "_notify_change", name + "_notify_update", name + "_notify_truncate"
+}
+
+// CreateSQL returns statements creating the trigger function and
+// triggers, replacing previous ones, so it could be re-run with new options
+func (t Trigger) CreateSQL() (string, error) {
+	if t.Table == "" {
+		return "", ErrNoTable
+	}
+	if len(t.Keys) == 0 {
+		return "", ErrNoKeys
+	}
+	function, row, update, truncate := t.names()
+	table := t.table()
+
+	var b strings.Builder
+	b.WriteString(t.dropTriggers())
+	if t.Format == FormatJSON {
+		b.WriteString(t.jsonFunction())
+	} else {
+		b.WriteString(t.textFunction())
+	}
+	if len(t.Columns) == 0 {
+		fmt.Fprintf(&b, "CREATE TRIGGER %s AFTER INSERT OR UPDATE OR DELETE ON %s\nFOR EACH ROW EXECUTE PROCEDURE %s();\n",
+			quoteIdent(row), table, t.qualified(function))
+	} else {
+		columns := t.watched()
+		fmt.Fprintf(&b, "CREATE TRIGGER %s AFTER INSERT OR DELETE ON %s\nFOR EACH ROW EXECUTE PROCEDURE %s();\n",
+			quoteIdent(row), table, t.qualified(function))
+		fmt.Fprintf(&b, "CREATE TRIGGER %s AFTER UPDATE OF %s ON %s\nFOR EACH ROW WHEN (%s IS DISTINCT FROM %s)\nEXECUTE PROCEDURE %s();\n",
+			quoteIdent(update), quoteList(columns), table, rowExpr("OLD", columns), rowExpr("NEW", columns), t.qualified(function))
+	}
+	if t.Truncate {
+		fmt.Fprintf(&b, "CREATE TRIGGER %s AFTER TRUNCATE ON %s\nFOR EACH STATEMENT EXECUTE PROCEDURE %s();\n",
+			quoteIdent(truncate), table, t.qualified(function))
+	}
+	return b.String(), nil
+}
+
+// textFunction returns trigger function sending text payload
+func (t Trigger) textFunction() string {
+	function, _, _, _ := t.names()
+	channel := quoteLiteral(t.channel())
+	return fmt.Sprintf(`CREATE OR REPLACE FUNCTION %s() RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_OP = 'TRUNCATE' THEN
+    PERFORM pg_notify(%s, 'T');
+  ELSIF TG_OP = 'INSERT' THEN
+    PERFORM pg_notify(%s, %s);
+  ELSIF TG_OP = 'DELETE' THEN
+    PERFORM pg_notify(%s, %s);
+  ELSIF %s IS DISTINCT FROM %s THEN
+    PERFORM pg_notify(%s, %s);
+    PERFORM pg_notify(%s, %s);
+  ELSE
+    PERFORM pg_notify(%s, %s);
+  END IF;
+  RETURN NULL;
+END; $$ LANGUAGE plpgsql;
+`, t.qualified(function),
+		channel,
+		channel, fitText("'I' || "+keyExpr("NEW", t.Keys)),
+		channel, fitText("'D' || "+keyExpr("OLD", t.Keys)),
+		rowExpr("OLD", t.Keys), rowExpr("NEW", t.Keys),
+		channel, fitText("'D' || "+keyExpr("OLD", t.Keys)),
+		channel, fitText("'I' || "+keyExpr("NEW", t.Keys)),
+		channel, fitText("'U' || "+keyExpr("NEW", t.Keys)))
+}
+
+// fitText returns text payload expression falling back to T when it
+// exceeds NOTIFY limit, since pg_notify would fail the write
+func fitText(payload string) string {
+	return fmt.Sprintf("CASE WHEN octet_length(%s) <= %d THEN %s ELSE 'T' END", payload, MaxPayloadSize, payload)
+}
+
+// jsonFunction returns trigger function sending JSON payload (see Payload),
+// and its helper dropping row image when payload exceeds NOTIFY limit,
+// and falling back to truncate when key only payload still exceeds it
+func (t Trigger) jsonFunction() string {
+	function, _, _, _ := t.names()
+	payload := t.qualified(t.payloadFunction())
+	channel := quoteLiteral(t.channel())
+	newRow, oldRow := "NULL", "NULL"
+	if t.Row {
+		newRow = rowImage("NEW", t.Columns, t.watched())
+	}
+	return fmt.Sprintf(`CREATE OR REPLACE FUNCTION %s(head jsonb, action text, key jsonb, image jsonb) RETURNS text AS $$
+  SELECT CASE WHEN octet_length(p::text) <= %[2]d THEN p::text
+         WHEN octet_length((p - 'row' || '{"fetch": true}')::text) <= %[2]d THEN (p - 'row' || '{"fetch": true}')::text
+         ELSE (head || '{"action": "T"}')::text END
+  FROM (SELECT head || jsonb_build_object('action', action, 'key', key)
+          || CASE WHEN image IS NULL THEN '{}'::jsonb ELSE jsonb_build_object('row', image) END AS p) t;
+$$ LANGUAGE sql IMMUTABLE;
+CREATE OR REPLACE FUNCTION %s() RETURNS TRIGGER AS $$
+DECLARE
+  head jsonb := jsonb_build_object('v', %d, 'table', TG_TABLE_SCHEMA || '.' || TG_TABLE_NAME,
+                                   'txid', txid_current(), 'ts', clock_timestamp());
+BEGIN
+  IF TG_OP = 'TRUNCATE' THEN
+    PERFORM pg_notify(%s, (head || '{"action": "T"}')::text);
+  ELSIF TG_OP = 'INSERT' THEN
+    PERFORM pg_notify(%s, %s(head, 'I', %s, %s));
+  ELSIF TG_OP = 'DELETE' THEN
+    PERFORM pg_notify(%s, %s(head, 'D', %s, %s));
+  ELSIF %s IS DISTINCT FROM %s THEN
+    PERFORM pg_notify(%s, %s(head, 'D', %s, %s));
+    PERFORM pg_notify(%s, %s(head, 'I', %s, %s));
+  ELSE
+    PERFORM pg_notify(%s, %s(head, 'U', %s, %s));
+  END IF;
+  RETURN NULL;
+END; $$ LANGUAGE plpgsql;
+`, payload, MaxPayloadSize,
+		t.qualified(function), PayloadVersion,
+		channel,
+		channel, payload, keyObject("NEW", t.Keys), newRow,
+		channel, payload, keyObject("OLD", t.Keys), oldRow,
+		rowExpr("OLD", t.Keys), rowExpr("NEW", t.Keys),
+		channel, payload, keyObject("OLD", t.Keys), oldRow,
+		channel, payload, keyObject("NEW", t.Keys), newRow,
+		channel, payload, keyObject("NEW", t.Keys), newRow)
+}
+
+// DropSQL returns statements removing triggers and function, table is left untouched
+func (t Trigger) DropSQL() (string, error) {
+	if t.Table == "" {
+		return "", ErrNoTable
+	}
+	function, _, _, _ := t.names()
+	return t.dropTriggers() + fmt.Sprintf("DROP FUNCTION IF EXISTS %s();\nDROP FUNCTION IF EXISTS %s(jsonb, text, jsonb, jsonb);\n",
+		t.qualified(function), t.qualified(t.payloadFunction())), nil
+}
+
+// payloadFunction returns name of JSON payload helper function
+func (t Trigger) payloadFunction() string {
+	_, name := t.schemaTable()
+	return name + "_notify_payload"
+}
+
+// dropTriggers returns statements dropping all triggers possibly created
+func (t Trigger) dropTriggers() string {
+	_, row, update, truncate := t.names()
+	table := t.table()
+	var b strings.Builder
+	for _, name := range []string{row, update, truncate} {
+		fmt.Fprintf(&b, "DROP TRIGGER IF EXISTS %s ON %s;\n", quoteIdent(name), table)
+	}
+	return b.String()
+}
+
+// watched returns keys followed by columns not in keys
+func (t Trigger) watched() []string {
+	columns := append([]string(nil), t.Keys...)
+	for _, c := range t.Columns {
+		found := false
+		for _, k := range columns {
+			found = found || k == c
+		}
+		if !found {
+			columns = append(columns, c)
+		}
+	}
+	return columns
+}
+
+/**************************************************************
+* database
+**************************************************************/
+
+// Detect returns primary key columns of table in key order
+func Detect(ctx context.Context, db *pg.DB, table string) ([]string, error) {
+	var keys []string
+	_, err := db.WithContext(ctx).QueryOne(gopg.Scan(gopg.Array(&keys)), `
+SELECT coalesce(array_agg(a.attname::text ORDER BY k.n), '{}')
+FROM pg_index i, unnest(i.indkey) WITH ORDINALITY AS k(attnum, n), pg_attribute a
+WHERE i.indrelid = ?::regclass AND i.indisprimary
+  AND a.attrelid = i.indrelid AND a.attnum = k.attnum`, table)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	return keys, nil
+}
+
+// Install create trigger in a transaction, keys are detected if not given
+func Install(ctx context.Context, db *pg.DB, t Trigger) error {
+	if len(t.Keys) == 0 && t.Table != "" {
+		keys, err := Detect(ctx, db, t.Table)
+		if err != nil {
+			return fmt.Errorf("notify: %s: %w", t.Table, err)
+		}
+		t.Keys = keys
+	}
+	sql, err := t.CreateSQL()
+	if err != nil {
+		return fmt.Errorf("notify: %s: %w", t.Table, err)
+	}
+	return db.WithTx(ctx, nil, func(tx *pg.Tx) error {
+		_, err := tx.Exec(sql)
+		return err
+	})
+}
+
+// Uninstall remove trigger and its function
+func Uninstall(ctx context.Context, db *pg.DB, t Trigger) error {
+	sql, err := t.DropSQL()
+	if err != nil {
+		return fmt.Errorf("notify: %s: %w", t.Table, err)
+	}
+	return db.WithTx(ctx, nil, func(tx *pg.Tx) error {
+		_, err := tx.Exec(sql)
+		return err
+	})
+}
+
+/**************************************************************
+* sql building
+**************************************************************/
+
+// keyExpr returns text expression of key columns of record
+func keyExpr(record string, keys []string) string {
+	if len(keys) == 1 {
+		return record + "." + quoteIdent(keys[0]) + "::text"
+	}
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = record + "." + quoteIdent(k)
+	}
+	return "json_build_array(" + strings.Join(parts, ", ") + ")::text"
+}
+
+// keyObject returns jsonb object of key columns of record
+func keyObject(record string, keys []string) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = quoteLiteral(k) + ", " + record + "." + quoteIdent(k)
+	}
+	return "jsonb_build_object(" + strings.Join(parts, ", ") + ")"
+}
+
+// rowImage returns jsonb image of record, restricted to watched columns if any given
+func rowImage(record string, columns, watched []string) string {
+	if len(columns) == 0 {
+		return "to_jsonb(" + record + ")"
+	}
+	return keyObject(record, watched)
+}
+
+// rowExpr returns row constructor of columns of record, for comparison
+func rowExpr(record string, columns []string) string {
+	parts := make([]string, len(columns))
+	for i, c := range columns {
+		parts[i] = record + "." + quoteIdent(c)
+	}
+	return "ROW(" + strings.Join(parts, ", ") + ")"
+}
+
+// quoteList returns comma separated quoted identifiers
+func quoteList(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = quoteIdent(name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// quoteIdent quote an identifier like postgres quote_ident, always quoted
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// quoteLiteral quote a string literal like postgres quote_literal
+func quoteLiteral(s string) string {
+	return `'` + strings.ReplaceAll(s, `'`, `''`) + `'`
+}
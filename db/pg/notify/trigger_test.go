@@ -0,0 +1,115 @@
+package notify
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCreateSQL(t *testing.T) {
+	table := []struct {
+		trigger Trigger
+		want    []string
+		unwant  []string
+	}{
+		{
+			Trigger{Table: "users", Keys: []string{"id"}},
+			[]string{
+				`CREATE OR REPLACE FUNCTION "users_notify_change"()`,
+				`PERFORM pg_notify('users_chan', CASE WHEN octet_length('I' || NEW."id"::text) <= 7999 THEN 'I' || NEW."id"::text ELSE 'T' END);`,
+				`PERFORM pg_notify('users_chan', CASE WHEN octet_length('D' || OLD."id"::text) <= 7999 THEN 'D' || OLD."id"::text ELSE 'T' END);`,
+				`ELSIF ROW(OLD."id") IS DISTINCT FROM ROW(NEW."id") THEN`,
+				`CREATE TRIGGER "users_notify_change" AFTER INSERT OR UPDATE OR DELETE ON "users"`,
+				`DROP TRIGGER IF EXISTS "users_notify_truncate" ON "users";`,
+			},
+			[]string{"AFTER TRUNCATE", "UPDATE OF"},
+		},
+		{
+			Trigger{Table: "app.order_items", Channel: "items", Keys: []string{"order_id", "line"},
+				Columns: []string{"qty", "line"}, Truncate: true},
+			[]string{
+				`CREATE OR REPLACE FUNCTION "app"."order_items_notify_change"()`,
+				`THEN 'U' || json_build_array(NEW."order_id", NEW."line")::text ELSE 'T' END);`,
+				`CREATE TRIGGER "order_items_notify_change" AFTER INSERT OR DELETE ON "app"."order_items"`,
+				`CREATE TRIGGER "order_items_notify_update" AFTER UPDATE OF "order_id", "line", "qty" ON "app"."order_items"`,
+				`WHEN (ROW(OLD."order_id", OLD."line", OLD."qty") IS DISTINCT FROM ROW(NEW."order_id", NEW."line", NEW."qty"))`,
+				`CREATE TRIGGER "order_items_notify_truncate" AFTER TRUNCATE ON "app"."order_items"`,
+				`FOR EACH STATEMENT EXECUTE PROCEDURE "app"."order_items_notify_change"();`,
+			},
+			nil,
+		},
+		{
+			Trigger{Table: `we"ird`, Channel: "it's", Keys: []string{"k"}},
+			[]string{`ON "we""ird"`, `pg_notify('it''s', 'T')`},
+			nil,
+		},
+	}
+	for _, tt := range table {
+		sql, err := tt.trigger.CreateSQL()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(sql, want) {
+				t.Errorf("CreateSQL(%s) missing %q in:\n%s", tt.trigger.Table, want, sql)
+			}
+		}
+		for _, unwant := range tt.unwant {
+			if strings.Contains(sql, unwant) {
+				t.Errorf("CreateSQL(%s) should not contain %q", tt.trigger.Table, unwant)
+			}
+		}
+		// re-creating starts from scratch
+		if !strings.HasPrefix(sql, "DROP TRIGGER IF EXISTS") {
+			t.Errorf("CreateSQL(%s) should drop existing triggers first", tt.trigger.Table)
+		}
+	}
+
+	if _, err := (Trigger{Table: "users"}).CreateSQL(); !errors.Is(err, ErrNoKeys) {
+		t.Errorf("CreateSQL without keys => %v, want %v", err, ErrNoKeys)
+	}
+	if _, err := (Trigger{}).DropSQL(); !errors.Is(err, ErrNoTable) {
+		t.Errorf("DropSQL without table => %v, want %v", err, ErrNoTable)
+	}
+}
+
+func TestDropSQL(t *testing.T) {
+	sql, err := Trigger{Table: "app.users"}.DropSQL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `DROP TRIGGER IF EXISTS "users_notify_change" ON "app"."users";
+DROP TRIGGER IF EXISTS "users_notify_update" ON "app"."users";
+DROP TRIGGER IF EXISTS "users_notify_truncate" ON "app"."users";
+DROP FUNCTION IF EXISTS "app"."users_notify_change"();
+DROP FUNCTION IF EXISTS "app"."users_notify_payload"(jsonb, text, jsonb, jsonb);
+`
+	if sql != want {
+		t.Fatalf("Inconsistent drop sql: expected:\n%s\nactual:\n%s", want, sql)
+	}
+}
+
+func TestCreateSQLJSON(t *testing.T) {
+	sql, err := Trigger{Table: "users", Keys: []string{"id"}, Format: FormatJSON, Row: true}.CreateSQL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`CREATE OR REPLACE FUNCTION "users_notify_payload"(head jsonb, action text, key jsonb, image jsonb)`,
+		`WHEN octet_length(p::text) <= 7999 THEN p::text`,
+		`WHEN octet_length((p - 'row' || '{"fetch": true}')::text) <= 7999 THEN`,
+		`ELSE (head || '{"action": "T"}')::text END`,
+		`head jsonb := jsonb_build_object('v', 1,`,
+		`PERFORM pg_notify('users_chan', "users_notify_payload"(head, 'I', jsonb_build_object('id', NEW."id"), to_jsonb(NEW)));`,
+		`PERFORM pg_notify('users_chan', "users_notify_payload"(head, 'D', jsonb_build_object('id', OLD."id"), NULL));`,
+	} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("CreateSQL(json) missing %q in:\n%s", want, sql)
+		}
+	}
+
+	sql, _ = Trigger{Table: "users", Keys: []string{"id"}, Columns: []string{"name"}, Format: FormatJSON, Row: true}.CreateSQL()
+	if want := `jsonb_build_object('id', NEW."id", 'name', NEW."name"))`; !strings.Contains(sql, want) {
+		t.Errorf("CreateSQL(json, columns) missing %q in:\n%s", want, sql)
+	}
+}
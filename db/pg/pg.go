@@ -1,99 +1,124 @@
 package pg
 
 import (
+	"context"
+	"fmt"
 	"os"
-	"os/user"
-	"net/url"
-	"strings"
-	"crypto/tls"
 )
 
 import "github.com/go-pg/pg"
+import "github.com/Vonng/gopher/atomic"
 
-// Pg: Global instance will init when ENV:PGURL is set
-var Pg *pg.DB
+// DB is a connection pool to postgres, it embeds *pg.DB so all
+// go-pg methods are available directly
+type DB struct {
+	*pg.DB
+	config Config
+}
 
-func init() {
-	var pgURL = "postgres://localhost:5432"
-	if envURL := os.Getenv("PGURL"); envURL != "" {
-		pgURL = envURL
+// Open create a connection pool with given config and verify it
+// by a round trip to server. Nothing happens at import time,
+// pool only exists after Open succeed
+func Open(ctx context.Context, cfg Config) (*DB, error) {
+	db, err := connect(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("pg: connect %s@%s/%s: %w", db.config.User, db.config.Addr(), db.config.Database, err)
 	}
-	Pg = NewPg(pgURL)
+	return db, nil
 }
 
-// NewPg will create a new pg instance from pg url
-// returns nil on error
-func NewPg(pgURL string) *pg.DB {
-	parsedUrl, err := url.Parse(pgURL)
+// connect create a connection pool without touching server
+func connect(cfg Config) (*DB, error) {
+	cfg, err := cfg.withDefaults()
 	if err != nil {
-		return nil
+		return nil, err
 	}
-
-	// scheme
-	if parsedUrl.Scheme != "postgres" && parsedUrl.Scheme != "postgresql" {
-		return nil
+	options, err := cfg.options()
+	if err != nil {
+		return nil, err
 	}
+	return &DB{DB: pg.Connect(options), config: cfg}, nil
+}
 
-	// host
-	options := &pg.Options{
-		Addr: parsedUrl.Host,
+// OpenURL is equivalent to Parse then Open, pgURL could be
+// either postgres:// uri or keyword/value connection string
+func OpenURL(ctx context.Context, pgURL string) (*DB, error) {
+	cfg, err := Parse(pgURL)
+	if err != nil {
+		return nil, err
 	}
+	return Open(ctx, *cfg)
+}
 
-	// port
-	if !strings.Contains(options.Addr, ":") {
-		options.Addr = options.Addr + ":5432"
-	}
+// Config returns config with defaults filled
+func (db *DB) Config() Config {
+	return db.config
+}
 
-	// username and password
-	if parsedUrl.User != nil {
-		options.User = parsedUrl.User.Username()
-		if password, ok := parsedUrl.User.Password(); ok {
-			options.Password = password
-		}
-	}
+// Ping issue a trivial query to check server is reachable
+func (db *DB) Ping(ctx context.Context) error {
+	_, err := db.DB.WithContext(ctx).Exec(`SELECT 1`)
+	return err
+}
 
-	// use current user as default
-	if options.User == "" {
-		if userinfo, err := user.Current(); err != nil {
-			return nil
-		} else {
-			options.User = userinfo.Name
-		}
+// NewPg will create a new pg instance from pg url
+// returns nil on error
+//
+// Deprecated: use Open or OpenURL, which report errors
+func NewPg(pgURL string) *pg.DB {
+	cfg, err := Parse(pgURL)
+	if err != nil {
+		return nil
 	}
-
-	// database: use postgres as default
-	if len(strings.Trim(parsedUrl.Path, "/")) > 0 {
-		options.Database = parsedUrl.Path[1:]
-	} else {
-		options.Database = "postgres"
+	if *cfg, err = cfg.withDefaults(); err != nil {
+		return nil
 	}
-
-	// ssl mode
-	query, err := url.ParseQuery(parsedUrl.RawQuery)
+	options, err := cfg.options()
 	if err != nil {
 		return nil
 	}
+	return pg.Connect(options)
+}
 
-	if sslMode, ok := query["sslmode"]; ok && len(sslMode) > 0 {
-		switch sslMode[0] {
-		case "allow":
-			fallthrough
-		case "prefer":
-			options.TLSConfig = &tls.Config{InsecureSkipVerify: true}
-		case "disable":
-			options.TLSConfig = nil
-		default:
+/**************************************************************
+* default instance
+**************************************************************/
+
+var (
+	defaultDB   atomic.Pointer[DB]
+	defaultOnce atomic.OnceErr
+)
+
+// Default returns the process wide instance, opened on first call
+// from ENV:PGURL or DefaultURL. a failed open will be retried on
+// next call. Only code calling Default pays for the connection
+func Default() (*DB, error) {
+	err := defaultOnce.Do(func() error {
+		if defaultDB.Load() != nil {
 			return nil
 		}
-	} else {
-		// disable tls on default
-		options.TLSConfig = nil
-	}
-
-	delete(query, "sslmode")
-	if len(query) > 0 {
+		pgURL := DefaultURL
+		if envURL := os.Getenv("PGURL"); envURL != "" {
+			pgURL = envURL
+		}
+		db, err := OpenURL(context.Background(), pgURL)
+		if err != nil {
+			return err
+		}
+		defaultDB.Store(db)
 		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return defaultDB.Load(), nil
+}
 
-	return pg.Connect(options)
+// SetDefault replace the instance returned by Default
+func SetDefault(db *DB) {
+	defaultDB.Store(db)
 }
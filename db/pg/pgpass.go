@@ -0,0 +1,94 @@
+package pg
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// passfilePath returns passfile location: given path, ENV:PGPASSFILE,
+// or ~/.pgpass (%APPDATA%\postgresql\pgpass.conf on windows)
+func passfilePath(path string) string {
+	if path != "" {
+		return path
+	}
+	if path = os.Getenv("PGPASSFILE"); path != "" {
+		return path
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("APPDATA"), "postgresql", "pgpass.conf")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".pgpass")
+}
+
+// lookupPassfile find password in passfile for given connection,
+// returns "" if file is missing, not private, or has no match.
+// each line is hostname:port:database:username:password,
+// first four fields could be * as wildcard, \ escapes : and \
+func lookupPassfile(path, host, port, database, username string) string {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return ""
+	}
+	// libpq ignores passfile readable by group or others
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return ""
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	// unix socket matches localhost entry
+	if host == "" || strings.HasPrefix(host, "/") {
+		host = "localhost"
+	}
+	want := [4]string{host, port, database, username}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := splitPassfileLine(line)
+		if len(fields) != 5 {
+			continue
+		}
+		match := true
+		for i, value := range want {
+			if fields[i] != "*" && fields[i] != value {
+				match = false
+				break
+			}
+		}
+		if match {
+			return fields[4]
+		}
+	}
+	return ""
+}
+
+// splitPassfileLine split line on unescaped colons
+func splitPassfileLine(line string) (fields []string) {
+	var field []byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && i+1 < len(line):
+			i++
+			field = append(field, line[i])
+		case c == ':':
+			fields = append(fields, string(field))
+			field = field[:0]
+		default:
+			field = append(field, c)
+		}
+	}
+	return append(fields, string(field))
+}
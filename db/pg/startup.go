@@ -0,0 +1,582 @@
+package pg
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+import "golang.org/x/crypto/pbkdf2"
+
+// protocol codes sent in place of message type by startup packets
+const (
+	protocolVersion = 196608
+	sslRequestCode  = 80877103
+	cancelCode      = 80877102
+)
+
+/**************************************************************
+* struct: startup
+**************************************************************/
+
+// startup performs connection startup inside the dialer instead of go-pg:
+// tls negotiation, authentication with the password of the dialed host,
+// and target_session_attrs check. go-pg then receives an authenticated
+// session, see ringConn. It is what libpq does when trying each host
+type startup struct {
+	user     string
+	database string
+	appName  string
+	attrs    string
+	// sslMode : how tlsConfig is used, tlsConfig is nil if disable
+	sslMode   string
+	tlsConfig *tls.Config
+	// password : password for given host, empty if none
+	password func(ep Endpoint) string
+	// timeout : bound of startup of one host per attempt, 0 means no limit
+	timeout time.Duration
+}
+
+// connect dial ep with dial and run startup, returns session ready to
+// hand to go-pg. Like libpq, sslmode allow retries with ssl if server rejects
+// the plain-text session, prefer retries plain-text if ssl session fails
+func (s *startup) connect(dial func() (net.Conn, error), ep Endpoint) (*startedConn, error) {
+	// ssl is never used over unix socket
+	useTLS := s.tlsConfig != nil && ep.Network() == "tcp"
+	retry := false
+	switch {
+	case useTLS && s.sslMode == "allow":
+		useTLS, retry = false, true
+	case useTLS && s.sslMode == "prefer":
+		retry = true
+	}
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	started, err := s.run(conn, ep, useTLS)
+	if err == nil {
+		return started, nil
+	}
+	conn.Close()
+	var serverErr *serverError
+	switch {
+	case !retry, errors.Is(err, ErrSessionAttrs), errors.Is(err, ErrNoPassword):
+		return nil, err
+	case !useTLS && !errors.As(err, &serverErr):
+		// allow: only a session rejected by server is retried with ssl
+		return nil, err
+	}
+	if conn, err = dial(); err != nil {
+		return nil, err
+	}
+	if started, err = s.run(conn, ep, !useTLS); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return started, nil
+}
+
+// run startup on conn dialed to ep, caller should close conn on error
+func (s *startup) run(conn net.Conn, ep Endpoint, useTLS bool) (*startedConn, error) {
+	if s.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.timeout))
+		defer conn.SetDeadline(time.Time{})
+	}
+	secure := false
+	if useTLS {
+		tlsConn, err := s.negotiateTLS(conn, ep)
+		switch {
+		case errors.Is(err, ErrSSLRefused) && s.sslMode == "prefer":
+			// go on in plain-text over the same connection
+		case err != nil:
+			return nil, err
+		default:
+			conn, secure = tlsConn, true
+		}
+	}
+
+	params := []string{"user", s.user, "database", s.database}
+	if s.appName != "" {
+		params = append(params, "application_name", s.appName)
+	}
+	msg := newMessage(0).int32(protocolVersion)
+	for _, p := range params {
+		msg.string(p)
+	}
+	if _, err := conn.Write(msg.string("").bytes()); err != nil {
+		return nil, err
+	}
+	if err := s.authenticate(conn, ep); err != nil {
+		return nil, err
+	}
+
+	// keep what server reports after authentication for go-pg
+	started := &startedConn{Conn: conn, replay: newMessage('R').int32(0).bytes(), endpoint: ep, secure: secure}
+	for {
+		typ, body, err := readMessage(conn)
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case 'S', 'K':
+			if typ == 'K' {
+				copy(started.key[:], body)
+			}
+			started.replay = append(started.replay, newMessage(typ).raw(body).bytes()...)
+		case 'E':
+			return nil, parseError(body)
+		case 'Z':
+			started.replay = append(started.replay, newMessage(typ).raw(body).bytes()...)
+			if err := s.checkAttrs(conn); err != nil {
+				return nil, err
+			}
+			return started, nil
+		}
+	}
+}
+
+// negotiateTLS send SSLRequest and upgrade conn with host name of ep
+// verified for verify-full. ErrSSLRefused if server answers no
+func (s *startup) negotiateTLS(conn net.Conn, ep Endpoint) (net.Conn, error) {
+	if _, err := conn.Write(newMessage(0).int32(sslRequestCode).bytes()); err != nil {
+		return conn, err
+	}
+	var reply [1]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return conn, err
+	}
+	if reply[0] != 'S' {
+		return conn, fmt.Errorf("pg: %w", ErrSSLRefused)
+	}
+	tlsConn := tls.Client(conn, hostTLS(s.tlsConfig, ep))
+	if err := tlsConn.Handshake(); err != nil {
+		return conn, err
+	}
+	return tlsConn, nil
+}
+
+// authenticate answer authentication requests until AuthenticationOk,
+// supports cleartext, md5 and SCRAM-SHA-256
+func (s *startup) authenticate(conn net.Conn, ep Endpoint) error {
+	var scram *scramClient
+	for {
+		typ, body, err := readMessage(conn)
+		if err != nil {
+			return err
+		}
+		if typ == 'E' {
+			return parseError(body)
+		}
+		if typ != 'R' || len(body) < 4 {
+			return fmt.Errorf("pg: unexpected message %q during authentication", typ)
+		}
+		method, data := binary.BigEndian.Uint32(body), body[4:]
+		if method == 0 {
+			return nil
+		}
+		password := s.password(ep)
+		if password == "" {
+			return fmt.Errorf("pg: %s: %w", ep.Addr(), ErrNoPassword)
+		}
+		var reply []byte
+		switch method {
+		case 3: // cleartext
+			reply = newMessage('p').string(password).bytes()
+		case 5: // md5
+			if len(data) < 4 {
+				return errors.New("pg: malformed md5 salt")
+			}
+			inner := md5Hex(password + s.user)
+			reply = newMessage('p').string("md5" + md5Hex(inner+string(data[:4]))).bytes()
+		case 10: // SASL, data is list of mechanisms
+			if !strings.Contains(string(data), "SCRAM-SHA-256\x00") {
+				return fmt.Errorf("pg: unsupported SASL mechanisms %q", data)
+			}
+			scram = newScramClient("", password, "")
+			first := scram.first()
+			reply = newMessage('p').string("SCRAM-SHA-256").int32(int32(len(first))).raw([]byte(first)).bytes()
+		case 11: // SASL continue
+			if scram == nil {
+				return errors.New("pg: unexpected SASL continue")
+			}
+			final, err := scram.final(string(data))
+			if err != nil {
+				return err
+			}
+			reply = newMessage('p').raw([]byte(final)).bytes()
+		case 12: // SASL final
+			if scram == nil {
+				return errors.New("pg: unexpected SASL final")
+			}
+			if err := scram.verify(string(data)); err != nil {
+				return err
+			}
+			continue
+		default:
+			return fmt.Errorf("pg: unsupported authentication method %d", method)
+		}
+		if _, err := conn.Write(reply); err != nil {
+			return err
+		}
+	}
+}
+
+// checkAttrs query server state and check it against target_session_attrs
+func (s *startup) checkAttrs(conn net.Conn) error {
+	var query string
+	switch s.attrs {
+	case "read-write", "read-only":
+		query = `SHOW transaction_read_only`
+	case "primary", "standby":
+		query = `SELECT pg_is_in_recovery()`
+	default:
+		return nil
+	}
+	value, err := simpleQuery(conn, query)
+	if err != nil {
+		return err
+	}
+	switch {
+	case s.attrs == "read-write" && value == "on",
+		s.attrs == "read-only" && value != "on",
+		s.attrs == "primary" && value == "t",
+		s.attrs == "standby" && value != "t":
+		return fmt.Errorf("pg: %s: %w", s.attrs, ErrSessionAttrs)
+	}
+	return nil
+}
+
+// simpleQuery run query returning a single value in text format
+func simpleQuery(conn net.Conn, query string) (value string, err error) {
+	if _, err := conn.Write(newMessage('Q').string(query).bytes()); err != nil {
+		return "", err
+	}
+	for {
+		typ, body, readErr := readMessage(conn)
+		if readErr != nil {
+			return "", readErr
+		}
+		switch typ {
+		case 'D':
+			// int16 column count, int32 length, value
+			if len(body) >= 6 {
+				if n := int32(binary.BigEndian.Uint32(body[2:])); n >= 0 && int(n) <= len(body)-6 {
+					value = string(body[6 : 6+n])
+				}
+			}
+		case 'E':
+			err = parseError(body)
+		case 'Z':
+			return value, err
+		}
+	}
+}
+
+/**************************************************************
+* struct: startedConn, ringConn
+**************************************************************/
+
+// startedConn is a session through startup. go-pg reads the recorded
+// AuthenticationOk, parameters, backend key and ReadyForQuery first,
+// in place of replies to its own startup packet
+type startedConn struct {
+	net.Conn
+	// replay : messages go-pg reads before those from server
+	replay   []byte
+	endpoint Endpoint
+	// key : process id & secret of BackendKeyData, identifies session
+	// in cancel requests. secure : session is over ssl
+	key    [8]byte
+	secure bool
+}
+
+// Read serve replayed startup messages first
+func (c *startedConn) Read(p []byte) (int, error) {
+	if len(c.replay) > 0 {
+		n := copy(p, c.replay)
+		c.replay = c.replay[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+// ringConn is what go-pg dials, connected on the first packet go-pg
+// writes: a startup packet starts a session on hosts of ring, whose
+// startup go-pg then reads; a CancelRequest is sent to the host of the
+// session it cancels without any startup. Deadlines set before that are
+// ignored, startup is bounded by connect timeout instead
+type ringConn struct {
+	net.Conn
+	ring *hostRing
+	// packet : first packet written by go-pg so far
+	packet []byte
+}
+
+// Write handle the first packet, forward the rest
+func (c *ringConn) Write(p []byte) (int, error) {
+	if c.Conn != nil {
+		return c.Conn.Write(p)
+	}
+	c.packet = append(c.packet, p...)
+	if len(c.packet) < 8 {
+		return len(p), nil
+	}
+	size := int(binary.BigEndian.Uint32(c.packet))
+	if len(c.packet) < size {
+		return len(p), nil
+	}
+	packet, rest := c.packet[:size], c.packet[size:]
+	c.packet = nil
+	var err error
+	if binary.BigEndian.Uint32(packet[4:]) == cancelCode && size == 16 {
+		c.Conn, err = c.ring.cancel(packet)
+	} else {
+		// startup packet is replaced by the one sent to each host
+		c.Conn, err = c.ring.start()
+	}
+	if err != nil {
+		c.Conn = nil
+		return 0, err
+	}
+	if len(rest) > 0 {
+		if _, err := c.Conn.Write(rest); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Read fails until connected, go-pg always writes first
+func (c *ringConn) Read(p []byte) (int, error) {
+	if c.Conn == nil {
+		return 0, net.ErrClosed
+	}
+	return c.Conn.Read(p)
+}
+
+// Close close the session and forget it
+func (c *ringConn) Close() error {
+	if c.Conn == nil {
+		return nil
+	}
+	if started, ok := c.Conn.(*startedConn); ok {
+		c.ring.sessions.CompareAndDelete(started.key, started)
+	}
+	return c.Conn.Close()
+}
+
+func (c *ringConn) LocalAddr() net.Addr {
+	if c.Conn == nil {
+		return nil
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *ringConn) RemoteAddr() net.Addr {
+	if c.Conn == nil {
+		return nil
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *ringConn) SetDeadline(t time.Time) error {
+	if c.Conn == nil {
+		return nil
+	}
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *ringConn) SetReadDeadline(t time.Time) error {
+	if c.Conn == nil {
+		return nil
+	}
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *ringConn) SetWriteDeadline(t time.Time) error {
+	if c.Conn == nil {
+		return nil
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
+
+/**************************************************************
+* messages
+**************************************************************/
+
+// message builds a frontend message, type 0 means startup packet
+type message struct {
+	buf   []byte
+	start int
+}
+
+// newMessage start a message of given type
+func newMessage(typ byte) *message {
+	m := &message{}
+	if typ != 0 {
+		m.buf, m.start = append(m.buf, typ), 1
+	}
+	m.buf = append(m.buf, 0, 0, 0, 0)
+	return m
+}
+
+func (m *message) int32(v int32) *message {
+	m.buf = binary.BigEndian.AppendUint32(m.buf, uint32(v))
+	return m
+}
+
+func (m *message) string(s string) *message {
+	m.buf = append(append(m.buf, s...), 0)
+	return m
+}
+
+func (m *message) raw(b []byte) *message {
+	m.buf = append(m.buf, b...)
+	return m
+}
+
+// bytes returns message with length filled
+func (m *message) bytes() []byte {
+	binary.BigEndian.PutUint32(m.buf[m.start:], uint32(len(m.buf)-m.start))
+	return m.buf
+}
+
+// readMessage read a backend message
+func readMessage(r io.Reader) (typ byte, body []byte, err error) {
+	var header [5]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size := int(binary.BigEndian.Uint32(header[1:]))
+	if size < 4 || size > 1<<24 {
+		return 0, nil, fmt.Errorf("pg: invalid message length %d", size)
+	}
+	body = make([]byte, size-4)
+	if _, err = io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header[0], body, nil
+}
+
+// serverError is an ErrorResponse, formatted like go-pg does
+type serverError struct {
+	fields map[byte]string
+}
+
+// Error implements error
+func (e *serverError) Error() string {
+	return fmt.Sprintf("%s #%s %s", e.fields['S'], e.fields['C'], e.fields['M'])
+}
+
+// parseError turn ErrorResponse into *serverError
+func parseError(body []byte) error {
+	fields := map[byte]string{}
+	for len(body) > 1 && body[0] != 0 {
+		end := strings.IndexByte(string(body[1:]), 0)
+		if end < 0 {
+			break
+		}
+		fields[body[0]] = string(body[1 : 1+end])
+		body = body[2+end:]
+	}
+	return &serverError{fields}
+}
+
+// md5Hex returns hex encoded md5 of s
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+/**************************************************************
+* struct: scramClient
+**************************************************************/
+
+// scramClient is client side of SCRAM-SHA-256 (RFC 7677) without
+// channel binding. postgres ignores user name in it, so it is empty
+type scramClient struct {
+	password  string
+	nonce     string
+	firstBare string
+	// serverSig : expected server signature, computed in final
+	serverSig []byte
+}
+
+// newScramClient create a client, nonce is random if empty
+func newScramClient(user, password, nonce string) *scramClient {
+	if nonce == "" {
+		b := make([]byte, 18)
+		rand.Read(b)
+		nonce = base64.StdEncoding.EncodeToString(b)
+	}
+	return &scramClient{password: password, nonce: nonce, firstBare: "n=" + user + ",r=" + nonce}
+}
+
+// first returns client-first-message
+func (s *scramClient) first() string {
+	return "n,," + s.firstBare
+}
+
+// final compute client-final-message from server-first-message
+func (s *scramClient) final(serverFirst string) (string, error) {
+	var nonce, salt string
+	var iterations int
+	for _, attr := range strings.Split(serverFirst, ",") {
+		if len(attr) < 2 || attr[1] != '=' {
+			continue
+		}
+		switch attr[0] {
+		case 'r':
+			nonce = attr[2:]
+		case 's':
+			salt = attr[2:]
+		case 'i':
+			iterations, _ = strconv.Atoi(attr[2:])
+		}
+	}
+	saltBytes, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil || !strings.HasPrefix(nonce, s.nonce) || iterations <= 0 {
+		return "", fmt.Errorf("pg: invalid SCRAM server message %q", serverFirst)
+	}
+
+	salted := pbkdf2.Key([]byte(s.password), saltBytes, iterations, sha256.Size, sha256.New)
+	clientKey := hmacSHA256(salted, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	withoutProof := "c=biws,r=" + nonce
+	authMessage := s.firstBare + "," + serverFirst + "," + withoutProof
+	proof := hmacSHA256(storedKey[:], authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	s.serverSig = hmacSHA256(hmacSHA256(salted, "Server Key"), authMessage)
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+// verify check server-final-message proves server knows the password
+func (s *scramClient) verify(serverFinal string) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(serverFinal, "v="))
+	if err != nil || !strings.HasPrefix(serverFinal, "v=") || !hmac.Equal(sig, s.serverSig) {
+		return errors.New("pg: invalid SCRAM server signature")
+	}
+	return nil
+}
+
+// hmacSHA256 returns HMAC-SHA256 of msg
+func hmacSHA256(key []byte, msg string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(msg))
+	return mac.Sum(nil)
+}
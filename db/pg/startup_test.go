@@ -0,0 +1,477 @@
+package pg
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+import "github.com/go-pg/pg"
+
+// fakeServer speaks just enough protocol for startup and single value queries
+type fakeServer struct {
+	ln net.Listener
+	// auth : trust, password or md5
+	auth       string
+	password   string
+	inRecovery bool
+	// tls : accept SSLRequest with it if set, sslOnly : reject plain-text sessions
+	tls     *tls.Config
+	sslOnly bool
+	conns   atomic.Int32
+	cancels chan cancelRequest
+}
+
+// cancelRequest is a CancelRequest received by fakeServer
+type cancelRequest struct {
+	pid, secret uint32
+	secure      bool
+}
+
+// newFakeServer start serving s on a random local port
+func newFakeServer(t *testing.T, s *fakeServer) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ln, s.cancels = ln, make(chan cancelRequest, 1)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, s.conns.Add(1))
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) endpoint() Endpoint {
+	addr := s.ln.Addr().(*net.TCPAddr)
+	return Endpoint{addr.IP.String(), addr.Port}
+}
+
+// readPacket read a startup packet without message type
+func readPacket(conn net.Conn) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+	packet := make([]byte, binary.BigEndian.Uint32(size[:])-4)
+	_, err := io.ReadFull(conn, packet)
+	return packet, err
+}
+
+// serve a connection, pid is its process id in BackendKeyData
+func (s *fakeServer) serve(conn net.Conn, pid int32) {
+	defer func() { conn.Close() }()
+	packet, err := readPacket(conn)
+	if err != nil {
+		return
+	}
+	secure := false
+	if binary.BigEndian.Uint32(packet) == sslRequestCode {
+		if s.tls == nil {
+			conn.Write([]byte("N"))
+		} else {
+			conn.Write([]byte("S"))
+			tlsConn := tls.Server(conn, s.tls)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn, secure = tlsConn, true
+		}
+		if packet, err = readPacket(conn); err != nil {
+			return
+		}
+	}
+	if binary.BigEndian.Uint32(packet) == cancelCode {
+		s.cancels <- cancelRequest{binary.BigEndian.Uint32(packet[4:]), binary.BigEndian.Uint32(packet[8:]), secure}
+		return
+	}
+	if s.sslOnly && !secure {
+		conn.Write(newMessage('E').raw([]byte("SFATAL\x00C28000\x00Mno pg_hba.conf entry, SSL off\x00\x00")).bytes())
+		return
+	}
+	params := strings.Split(string(packet[4:]), "\x00")
+	user := params[1]
+
+	var want string
+	switch s.auth {
+	case "password":
+		conn.Write(newMessage('R').int32(3).bytes())
+		want = s.password
+	case "md5":
+		conn.Write(newMessage('R').int32(5).raw([]byte("salt")).bytes())
+		want = "md5" + md5Hex(md5Hex(s.password+user)+"salt")
+	}
+	if want != "" {
+		typ, body, err := readMessage(conn)
+		if err != nil || typ != 'p' || string(body) != want+"\x00" {
+			conn.Write(newMessage('E').raw([]byte("SFATAL\x00C28P01\x00Mpassword authentication failed\x00\x00")).bytes())
+			return
+		}
+	}
+	conn.Write(newMessage('R').int32(0).bytes())
+	conn.Write(newMessage('S').string("server_version").string("10.0").bytes())
+	conn.Write(newMessage('K').int32(pid).int32(7).bytes())
+	conn.Write(newMessage('Z').raw([]byte("I")).bytes())
+
+	for {
+		typ, body, err := readMessage(conn)
+		if err != nil || typ == 'X' {
+			return
+		}
+		value := "1"
+		switch query := string(body); {
+		case strings.Contains(query, "pg_is_in_recovery"):
+			value = map[bool]string{true: "t", false: "f"}[s.inRecovery]
+		case strings.Contains(query, "transaction_read_only"):
+			value = map[bool]string{true: "on", false: "off"}[s.inRecovery]
+		case strings.Contains(query, "server_version"):
+			value = "10.0"
+		case strings.Contains(query, "pg_sleep"):
+			// runs until canceled, the request is left for the test
+			req := <-s.cancels
+			s.cancels <- req
+			conn.Write(newMessage('E').raw([]byte("SERROR\x00C57014\x00Mcanceling statement due to user request\x00\x00")).bytes())
+			conn.Write(newMessage('Z').raw([]byte("I")).bytes())
+			continue
+		}
+		// one text column v
+		conn.Write(newMessage('T').raw([]byte{0, 1}).string("v").int32(0).raw([]byte{0, 0}).int32(25).raw([]byte{0xff, 0xff}).int32(-1).raw([]byte{0, 0}).bytes())
+		conn.Write(newMessage('D').raw([]byte{0, 1}).int32(int32(len(value))).raw([]byte(value)).bytes())
+		conn.Write(newMessage('C').string("SELECT 1").bytes())
+		conn.Write(newMessage('Z').raw([]byte("I")).bytes())
+	}
+}
+
+// startSession dial like go-pg and write its startup packet, which makes
+// ringConn start a session
+func startSession(options *pg.Options) (net.Conn, error) {
+	conn, _ := options.Dialer("", "")
+	if _, err := conn.Write(newMessage(0).int32(protocolVersion).string("user").string("bob").string("").bytes()); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// startedOf returns the session started by startSession
+func startedOf(conn net.Conn) *startedConn {
+	return conn.(*ringConn).Conn.(*startedConn)
+}
+
+// replayTypes read startup replies go-pg sees, returns their types
+func replayTypes(t *testing.T, conn net.Conn) string {
+	var types []byte
+	for {
+		typ, _, err := readMessage(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if types = append(types, typ); typ == 'Z' {
+			return string(types)
+		}
+	}
+}
+
+func TestHostRingStartup(t *testing.T) {
+	standby := newFakeServer(t, &fakeServer{auth: "md5", password: "p1", inRecovery: true})
+	primary := newFakeServer(t, &fakeServer{auth: "password", password: "p2"})
+	passfile := filepath.Join(t.TempDir(), "pgpass")
+	os.WriteFile(passfile, []byte(fmt.Sprintf("127.0.0.1:%d:*:bob:p1\n127.0.0.1:%d:*:bob:p2\n",
+		standby.endpoint().Port, primary.endpoint().Port)), 0600)
+	cfg := Config{Hosts: []Endpoint{standby.endpoint(), primary.endpoint()}, User: "bob", Database: "app",
+		Passfile: passfile, TargetSessionAttrs: "primary"}
+
+	// standby is skipped within the same dial, primary gets its own password
+	options, err := cfg.options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := startSession(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if types := replayTypes(t, conn); types != "RSKZ" {
+		t.Fatalf("Inconsistent replayed startup: expected: %s, actual: %s", "RSKZ", types)
+	}
+	if value, err := simpleQuery(conn, `SELECT pg_is_in_recovery()`); err != nil || value != "f" {
+		t.Fatalf("query through started conn => %q %v", value, err)
+	}
+
+	// next dial starts from the good host, dialing alone contacts no host
+	conn2, err := startSession(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn2.Close()
+	idle, _ := options.Dialer("", "")
+	idle.Close()
+	if n := standby.conns.Load(); n != 1 {
+		t.Fatalf("Inconsistent standby dials: expected: %d, actual: %d", 1, n)
+	}
+
+	// standby with md5 and the other password
+	cfg.TargetSessionAttrs = "standby"
+	options, _ = cfg.options()
+	conn3, err := startSession(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn3.Close()
+	replayTypes(t, conn3)
+	if value, err := simpleQuery(conn3, `SHOW transaction_read_only`); err != nil || value != "on" {
+		t.Fatalf("expected standby, got transaction_read_only = %q %v", value, err)
+	}
+}
+
+func TestHostRingCancel(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	ca := newTestCA(t, "ca")
+	rootFile := writeFile(t, t.TempDir(), "root.crt", ca.pem)
+	withCert := serverTLS(t, ca, "localhost")
+	tlsA := newFakeServer(t, &fakeServer{tls: withCert})
+	tlsB := newFakeServer(t, &fakeServer{tls: withCert})
+	plainB := newFakeServer(t, &fakeServer{})
+
+	tests := []struct {
+		mode          string
+		first, second *fakeServer
+		secure        bool
+	}{
+		{"disable", tlsA, plainB, false},
+		{"verify-full", tlsA, tlsB, true},
+		// prefer fell back to plain-text, so does cancel
+		{"prefer", tlsA, plainB, false},
+	}
+	for _, tt := range tests {
+		cfg := Config{Hosts: []Endpoint{{"localhost", tt.first.endpoint().Port}, {"localhost", tt.second.endpoint().Port}},
+			User: "bob", Database: "app", SSLMode: tt.mode, SSLRootCert: rootFile}
+		options, err := cfg.options()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// session on second host, then ring turns back to first
+		idle, _ := options.Dialer("", "")
+		ring := idle.(*ringConn).ring
+		ring.next.Store(1)
+		conn, err := startSession(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		started := startedOf(conn)
+		ring.next.Store(0)
+		conns := tt.second.conns.Load()
+
+		// go-pg cancels on a new connection from the same dialer
+		cancel, _ := options.Dialer("", "")
+		packet := newMessage(0).int32(cancelCode).raw(started.key[:]).bytes()
+		if _, err := cancel.Write(packet); err != nil {
+			t.Fatalf("sslmode=%s cancel => %v", tt.mode, err)
+		}
+		cancel.Close()
+		got := <-tt.second.cancels
+		if got.pid != binary.BigEndian.Uint32(started.key[:]) || got.secret != 7 || got.secure != tt.secure {
+			t.Errorf("sslmode=%s Inconsistent cancel request: expected: secure %v, actual: %+v", tt.mode, tt.secure, got)
+		}
+		// cancel connection does no startup
+		if n := tt.second.conns.Load() - conns; n != 1 {
+			t.Errorf("Inconsistent connections of cancel: expected: %d, actual: %d", 1, n)
+		}
+		conn.Close()
+
+		// closed session is forgotten
+		cancel, _ = options.Dialer("", "")
+		if _, err := cancel.Write(packet); !errors.Is(err, ErrUnknownSession) {
+			t.Errorf("Inconsistent cancel of closed session: expected: %v, actual: %v", ErrUnknownSession, err)
+		}
+	}
+	if n := len(tlsA.cancels); n != 0 {
+		t.Fatalf("Inconsistent cancels of first host: expected: %d, actual: %d", 0, n)
+	}
+}
+
+func TestGoPgSession(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	ca := newTestCA(t, "ca")
+	rootFile := writeFile(t, t.TempDir(), "root.crt", ca.pem)
+	server := newFakeServer(t, &fakeServer{auth: "md5", password: "secret", tls: serverTLS(t, ca, "localhost"), sslOnly: true})
+	cfg := Config{Hosts: []Endpoint{{"localhost", server.endpoint().Port}}, User: "bob", Database: "app",
+		Password: "secret", SSLMode: "verify-full", SSLRootCert: rootFile, PoolSize: 1}
+	options, err := cfg.options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := pg.Connect(options)
+	defer db.Close()
+
+	// go-pg reads replayed startup and queries over tls
+	var value string
+	if _, err := db.QueryOne(pg.Scan(&value), `SELECT 1`); err != nil || value != "1" {
+		t.Fatalf("query through go-pg => %q %v", value, err)
+	}
+
+	// context cancel makes go-pg send CancelRequest through the dialer
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, `SELECT pg_sleep(10)`); err == nil || !strings.Contains(err.Error(), "57014") {
+		t.Fatalf("Inconsistent canceled query error: %v", err)
+	}
+	if req := <-server.cancels; !req.secure || req.secret != 7 {
+		t.Fatalf("Inconsistent cancel request: %+v", req)
+	}
+}
+
+func TestHostRingStartupError(t *testing.T) {
+	standby := newFakeServer(t, &fakeServer{auth: "password", password: "secret", inRecovery: true})
+	tests := []struct {
+		cfg  Config
+		want string
+		err  error
+	}{
+		{Config{TargetSessionAttrs: "primary", Password: "secret"}, "", ErrSessionAttrs},
+		{Config{TargetSessionAttrs: "read-write", Password: "secret"}, "", ErrSessionAttrs},
+		{Config{Password: "wrong"}, "28P01", nil},
+		{Config{Passfile: filepath.Join(t.TempDir(), "missing")}, "", ErrNoPassword},
+	}
+	for _, tt := range tests {
+		tt.cfg.Hosts, tt.cfg.User, tt.cfg.Database = []Endpoint{standby.endpoint()}, "bob", "app"
+		options, err := tt.cfg.options()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := startSession(options)
+		if err == nil {
+			conn.Close()
+			t.Fatalf("dial %+v should fail", tt.cfg)
+		}
+		if tt.err != nil && !errors.Is(err, tt.err) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("dial %+v => %v, want %v %s", tt.cfg, err, tt.err, tt.want)
+		}
+	}
+}
+
+// serverTLS returns tls config of a server presenting a certificate
+// for dnsNames signed by ca, and the root file trusting ca
+func serverTLS(t *testing.T, ca *testCA, dnsNames ...string) *tls.Config {
+	certPEM, key := ca.issue(t, dnsNames[0], x509.ExtKeyUsageServerAuth, dnsNames...)
+	cert, err := tls.X509KeyPair(certPEM, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}
+}
+
+func TestStartupSSLMode(t *testing.T) {
+	t.Setenv("HOME", t.TempDir()) // no default ~/.postgresql files
+	ca := newTestCA(t, "ca")
+	rootFile := writeFile(t, t.TempDir(), "root.crt", ca.pem)
+	withCert := serverTLS(t, ca, "localhost")
+	clientAuth := withCert.Clone()
+	clientAuth.ClientAuth = tls.RequireAnyClientCert
+	plain := newFakeServer(t, &fakeServer{})
+	sslOnly := newFakeServer(t, &fakeServer{tls: withCert, sslOnly: true})
+	sslAny := newFakeServer(t, &fakeServer{tls: withCert})
+	mutual := newFakeServer(t, &fakeServer{tls: clientAuth})
+
+	tests := []struct {
+		mode   string
+		server *fakeServer
+		// secure : session should be over tls, err : expected error
+		secure bool
+		err    string
+	}{
+		{"disable", plain, false, ""},
+		{"disable", sslOnly, false, "28000"},
+		{"allow", plain, false, ""},
+		{"allow", sslAny, false, ""},
+		{"allow", sslOnly, true, ""},
+		{"prefer", plain, false, ""},
+		{"prefer", sslAny, true, ""},
+		{"prefer", mutual, false, ""},
+		{"require", plain, false, ErrSSLRefused.Error()},
+		{"require", sslOnly, true, ""},
+		{"require", mutual, false, "certificate"},
+		{"verify-full", sslOnly, true, ""},
+		{"verify-full", plain, false, ErrSSLRefused.Error()},
+	}
+	for _, tt := range tests {
+		cfg := Config{Hosts: []Endpoint{{"localhost", tt.server.endpoint().Port}}, User: "bob", Database: "app",
+			SSLMode: tt.mode, SSLRootCert: rootFile}
+		options, err := cfg.options()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := startSession(options)
+		if err != nil {
+			if tt.err == "" || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("sslmode=%s against %+v => %v, want %q", tt.mode, tt.server, err, tt.err)
+			}
+			continue
+		}
+		_, secure := startedOf(conn).Conn.(*tls.Conn)
+		conn.Close()
+		if tt.err != "" || secure != tt.secure {
+			t.Errorf("sslmode=%s against %+v => secure: %v, want secure: %v error: %q", tt.mode, tt.server, secure, tt.secure, tt.err)
+		}
+	}
+
+	// verify-full checks certificate against the host dialed, not any host
+	// listed: 127.0.0.1 presenting certificate of localhost is skipped
+	spoof := newFakeServer(t, &fakeServer{tls: withCert, sslOnly: true})
+	cfg := Config{Hosts: []Endpoint{spoof.endpoint(), {"localhost", sslOnly.endpoint().Port}},
+		User: "bob", Database: "app", SSLMode: "verify-full", SSLRootCert: rootFile}
+	options, _ := cfg.options()
+	conn, err := startSession(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if ep := startedOf(conn).endpoint; ep != cfg.Hosts[1] {
+		t.Fatalf("Inconsistent host: expected: %v, actual: %v", cfg.Hosts[1], ep)
+	}
+	if n := spoof.conns.Load(); n != 1 {
+		t.Fatalf("Inconsistent spoof dials: expected: %d, actual: %d", 1, n)
+	}
+}
+
+func TestScramClient(t *testing.T) {
+	// example exchange of RFC 7677
+	s := newScramClient("user", "pencil", "rOprNGfwEbeRWgbNEkqO")
+	if first := s.first(); first != "n,,n=user,r=rOprNGfwEbeRWgbNEkqO" {
+		t.Fatalf("Inconsistent client first: %s", first)
+	}
+	final, err := s.final("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+	if final != want {
+		t.Fatalf("Inconsistent client final: expected: %s, actual: %s", want, final)
+	}
+	if err := s.verify("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.verify("v=AAAA"); err == nil {
+		t.Fatal("forged server signature accepted")
+	}
+	if _, err := newScramClient("", "pencil", "abc").final("r=xyz,s=AAAA,i=1"); err == nil {
+		t.Fatal("server nonce not extending client nonce accepted")
+	}
+}
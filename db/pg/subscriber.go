@@ -0,0 +1,203 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Subscriber defaults
+const (
+	DefaultSubscriberMinBackoff = 100 * time.Millisecond
+	DefaultSubscriberMaxBackoff = 30 * time.Second
+	// subscriberReceiveTimeout bounds a single wait for notification,
+	// connection is kept on timeout
+	subscriberReceiveTimeout = time.Minute
+)
+
+// EventKind tells Notify from Resync
+type EventKind int
+
+// Subscriber event kinds
+const (
+	// Notify : a notification arrived on Channel with Payload
+	Notify EventKind = iota
+	// Resync : LISTEN is (re)established, notifications sent while not
+	// listening are lost, so state derived from them should be reloaded
+	Resync
+)
+
+// String implements fmt.Stringer
+func (k EventKind) String() string {
+	if k == Resync {
+		return "resync"
+	}
+	return "notify"
+}
+
+// Event is emitted by Subscriber
+type Event struct {
+	Kind    EventKind
+	Channel string
+	Payload string
+}
+
+// listener is what Subscriber needs from *pg.Listener
+type listener interface {
+	Listen(channels ...string) error
+	ReceiveTimeout(timeout time.Duration) (channel, payload string, err error)
+	Close() error
+}
+
+/**************************************************************
+* struct: Subscriber
+**************************************************************/
+
+// Subscriber listens on channels over a dedicated connection, and
+// reconnects with backoff when it breaks. Events are delivered
+// in order on Events(), which is closed after Run returns
+type Subscriber struct {
+	// MinBackoff, MaxBackoff : bounds of reconnect backoff, set before Run
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// OnError : called with each connection error before reconnect, optional
+	OnError func(error)
+
+	open   func() listener
+	events chan Event
+
+	mu       sync.Mutex
+	channels []string
+	current  listener
+}
+
+// NewSubscriber create a subscriber of channels, nothing happens until Run
+func NewSubscriber(db *DB, channels ...string) *Subscriber {
+	return newSubscriber(func() listener { return db.DB.Listen() }, channels...)
+}
+
+// newSubscriber create subscriber with given listener factory
+func newSubscriber(open func() listener, channels ...string) *Subscriber {
+	channels = slices.Clone(channels)
+	slices.Sort(channels)
+	return &Subscriber{
+		MinBackoff: DefaultSubscriberMinBackoff,
+		MaxBackoff: DefaultSubscriberMaxBackoff,
+		open:       open,
+		events:     make(chan Event, 64),
+		channels:   slices.Compact(channels),
+	}
+}
+
+// Events returns the event stream, the first event is always Resync
+func (s *Subscriber) Events() <-chan Event {
+	return s.events
+}
+
+// Channels returns channels listened
+func (s *Subscriber) Channels() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.channels)
+}
+
+// Listen adds channels, takes effect immediately if connected,
+// and is re-issued after every reconnect
+func (s *Subscriber) Listen(channels ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var added []string
+	for _, channel := range channels {
+		if !slices.Contains(s.channels, channel) && !slices.Contains(added, channel) {
+			added = append(added, channel)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	s.channels = append(s.channels, added...)
+	slices.Sort(s.channels)
+	if s.current != nil {
+		return s.current.Listen(added...)
+	}
+	return nil
+}
+
+// Run receive notifications until ctx is done, reconnecting on error.
+// Events is closed when Run returns, which is always ctx.Err()
+func (s *Subscriber) Run(ctx context.Context) error {
+	defer close(s.events)
+	for retry := 0; ; {
+		if retry > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff(s.MinBackoff, max(s.MinBackoff, s.MaxBackoff), retry)):
+			}
+		}
+		connected, err := s.session(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if s.OnError != nil {
+			s.OnError(err)
+		}
+		// a healthy connection broke: reconnect quickly
+		if connected {
+			retry = 1
+		} else {
+			retry++
+		}
+	}
+}
+
+// session listen on a new connection until it fails or ctx is done,
+// connected reports whether LISTEN succeeded
+func (s *Subscriber) session(ctx context.Context) (connected bool, err error) {
+	ln := s.open()
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer func() {
+		stop()
+		s.mu.Lock()
+		s.current = nil
+		s.mu.Unlock()
+		ln.Close()
+	}()
+
+	s.mu.Lock()
+	s.current = ln
+	err = ln.Listen(s.channels...)
+	s.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+	if !s.emit(ctx, Event{Kind: Resync}) {
+		return true, ctx.Err()
+	}
+	for {
+		channel, payload, err := ln.ReceiveTimeout(subscriberReceiveTimeout)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return true, err
+		}
+		if !s.emit(ctx, Event{Kind: Notify, Channel: channel, Payload: payload}) {
+			return true, ctx.Err()
+		}
+	}
+}
+
+// emit deliver event unless ctx is done first
+func (s *Subscriber) emit(ctx context.Context, event Event) bool {
+	select {
+	case s.events <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,114 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeListener delivers notes until it is broken or closed
+type fakeListener struct {
+	listened chan []string
+	notes    chan Event
+	broken   chan struct{}
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func newFakeListener() *fakeListener {
+	return &fakeListener{listened: make(chan []string, 4), notes: make(chan Event),
+		broken: make(chan struct{}), closed: make(chan struct{})}
+}
+
+func (f *fakeListener) Listen(channels ...string) error {
+	f.listened <- channels
+	return nil
+}
+
+func (f *fakeListener) ReceiveTimeout(time.Duration) (string, string, error) {
+	select {
+	case e := <-f.notes:
+		return e.Channel, e.Payload, nil
+	case <-f.broken:
+		return "", "", errors.New("connection reset by peer")
+	case <-f.closed:
+		return "", "", errors.New("pg: listener is closed")
+	}
+}
+
+func (f *fakeListener) Close() error {
+	f.once.Do(func() { close(f.closed) })
+	return nil
+}
+
+func TestSubscriber(t *testing.T) {
+	listeners := make(chan *fakeListener, 4)
+	sub := newSubscriber(func() listener {
+		ln := newFakeListener()
+		listeners <- ln
+		return ln
+	}, "b_chan", "a_chan", "a_chan")
+	sub.MinBackoff, sub.MaxBackoff = time.Millisecond, time.Millisecond
+	var errs []error
+	sub.OnError = func(err error) { errs = append(errs, err) }
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- sub.Run(ctx) }()
+
+	expect := func(want Event) {
+		t.Helper()
+		select {
+		case got := <-sub.Events():
+			if got != want {
+				t.Fatalf("Inconsistent event: expected: %+v, actual: %+v", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %+v", want)
+		}
+	}
+
+	ln := <-listeners
+	if got, want := <-ln.listened, []string{"a_chan", "b_chan"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Inconsistent channels: expected: %v, actual: %v", want, got)
+	}
+	expect(Event{Kind: Resync})
+	ln.notes <- Event{Channel: "a_chan", Payload: "I1"}
+	expect(Event{Kind: Notify, Channel: "a_chan", Payload: "I1"})
+
+	// channel added while connected is listened immediately
+	if err := sub.Listen("c_chan", "a_chan"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := <-ln.listened, []string{"c_chan"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Inconsistent channels: expected: %v, actual: %v", want, got)
+	}
+
+	// broken connection: reconnect, re-listen all channels, resync
+	close(ln.broken)
+	ln = <-listeners
+	if got, want := <-ln.listened, []string{"a_chan", "b_chan", "c_chan"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Inconsistent channels: expected: %v, actual: %v", want, got)
+	}
+	expect(Event{Kind: Resync})
+	ln.notes <- Event{Channel: "c_chan", Payload: "D2"}
+	expect(Event{Kind: Notify, Channel: "c_chan", Payload: "D2"})
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Inconsistent error: expected: %v, actual: %v", context.Canceled, err)
+	}
+	if _, ok := <-sub.Events(); ok {
+		t.Fatal("events should be closed after Run returns")
+	}
+	select {
+	case <-ln.closed:
+	default:
+		t.Fatal("listener not closed on shutdown")
+	}
+	if len(errs) != 1 {
+		t.Fatalf("Inconsistent error count: expected: %d, actual: %d", 1, len(errs))
+	}
+}
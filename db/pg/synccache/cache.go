@@ -0,0 +1,489 @@
+// Package synccache mirrors a postgres table in memory, kept up to date
+// by triggers sending text or JSON payloads to channel <table>_chan,
+// which could be generated by package notify or command pgnotify.
+// Payloads could also travel over redis (see Transport), published by
+// writers other than postgres, with rows loaded from any store by Loader,
+// or be derived from a logical replication slot without triggers (Logical)
+package synccache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+import gopg "github.com/go-pg/pg"
+import "github.com/Vonng/gopher/db/pg"
+import "github.com/Vonng/gopher/db/pg/notify"
+
+// Action is the kind of a change
+type Action byte
+
+// Change actions, same as trigger payload prefix
+const (
+	Insert Action = 'I'
+	Update Action = 'U'
+	Delete Action = 'D'
+)
+
+// String implements fmt.Stringer
+func (a Action) String() string {
+	switch a {
+	case Insert:
+		return "insert"
+	case Update:
+		return "update"
+	case Delete:
+		return "delete"
+	}
+	return fmt.Sprintf("Action(%d)", byte(a))
+}
+
+// Change is passed to callbacks after it is applied to cache,
+// Old is zero for insert, New is zero for delete
+type Change[K comparable, V any] struct {
+	Action Action
+	Key    K
+	Old    V
+	New    V
+}
+
+// Query tells Loader what to fetch
+type Query[K comparable] struct {
+	DB        *pg.DB
+	Table     string
+	KeyColumn string
+	// Keys : rows to fetch, nil means all rows
+	Keys []K
+}
+
+// Loader fetch rows by query, keyed by key column.
+// keys absent from result are treated as deleted
+type Loader[K comparable, V any] func(ctx context.Context, q Query[K]) (map[K]V, error)
+
+// StructLoader returns a loader scanning rows into struct V with go-pg,
+// key is extracted from each row by keyOf
+func StructLoader[K comparable, V any](keyOf func(row *V) K) Loader[K, V] {
+	return func(ctx context.Context, q Query[K]) (map[K]V, error) {
+		var rows []V
+		db := q.DB.WithContext(ctx)
+		var err error
+		if q.Keys == nil {
+			_, err = db.Query(&rows, `SELECT * FROM ?`, gopg.F(q.Table))
+		} else {
+			_, err = db.Query(&rows, `SELECT * FROM ? WHERE ? = ANY(?)`,
+				gopg.F(q.Table), gopg.F(q.KeyColumn), gopg.Array(q.Keys))
+		}
+		if err != nil {
+			return nil, err
+		}
+		res := make(map[K]V, len(rows))
+		for i := range rows {
+			res[keyOf(&rows[i])] = rows[i]
+		}
+		return res, nil
+	}
+}
+
+/**************************************************************
+* struct: Cache
+**************************************************************/
+
+// Cache is an in-memory copy of a table, safe for concurrent use
+type Cache[K comparable, V any] struct {
+	// Channel : notification channel, <table>_chan by default
+	Channel string
+	// OnError : called with load and notification errors, optional
+	OnError func(error)
+	// DecodeRow : take row image of JSON payload as new value by
+	// encoding/json instead of fetching it, V's json fields should
+	// match column names
+	DecodeRow bool
+	// BatchWindow : max delay of a change before its row is fetched,
+	// changes within the window are fetched by one query. DefaultBatchWindow if 0
+	BatchWindow time.Duration
+	// MaxBatch : max keys per fetch, a full batch is fetched at once,
+	// which bounds memory of pending keys. DefaultMaxBatch if 0
+	MaxBatch int
+	// Transport : where change payloads come from, postgres NOTIFY on
+	// db if nil. Set before Start
+	Transport Transport
+
+	db     *pg.DB
+	table  string
+	column string
+	loader Loader[K, V]
+
+	mu   sync.RWMutex
+	rows map[K]V
+
+	callbackMu sync.Mutex
+	callbacks  []func(Change[K, V])
+
+	// pending, order : keys to fetch in arrival order, only accessed by
+	// follow goroutine. order may hold keys removed from pending since
+	pending map[K]struct{}
+	order   []K
+	// stale : a fetch failed, next flush reloads whole table
+	stale bool
+}
+
+// Batching defaults
+const (
+	DefaultBatchWindow = 50 * time.Millisecond
+	DefaultMaxBatch    = 1000
+)
+
+// New create a cache of table, rows are identified by keyColumn and
+// fetched by loader. Call Start to load and follow changes
+func New[K comparable, V any](db *pg.DB, table, keyColumn string, loader Loader[K, V]) *Cache[K, V] {
+	return &Cache[K, V]{
+		Channel: table + "_chan",
+		db:      db,
+		table:   table,
+		column:  keyColumn,
+		loader:  loader,
+		rows:    map[K]V{},
+		pending: map[K]struct{}{},
+	}
+}
+
+// Start subscribe to changes and load all rows, returns once the first
+// load succeeds. Cache keeps following changes until ctx is done, and
+// reloads whole table after reconnect since notifications may be lost
+func (c *Cache[K, V]) Start(ctx context.Context) error {
+	transport := c.Transport
+	if transport == nil {
+		transport = Notify(c.db)
+	}
+	ready := make(chan struct{})
+	go c.follow(ctx, transport.Subscribe(ctx, c.Channel, c.onError), ready)
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// follow apply events until subscriber stops, ready is closed after first load.
+// keys to fetch are flushed BatchWindow after the first of them arrives.
+// a stale cache is reloaded by the same timer with backoff, even if no
+// more notification comes
+func (c *Cache[K, V]) follow(ctx context.Context, events <-chan pg.Event, ready chan struct{}) {
+	window := c.BatchWindow
+	if window <= 0 {
+		window = DefaultBatchWindow
+	}
+	timer := time.NewTimer(window)
+	timer.Stop()
+	defer timer.Stop()
+	waiting := false
+	// failures : consecutive flushes leaving cache stale
+	failures := 0
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if event.Kind == pg.Resync {
+				c.discard()
+				// retry until loaded: later notifications are meaningless without it
+				for retry := 0; c.reload(ctx) != nil; retry++ {
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(reloadBackoff(retry)):
+					}
+				}
+				c.stale = false
+				if ready != nil {
+					close(ready)
+					ready = nil
+				}
+			} else {
+				c.handle(ctx, event.Payload)
+			}
+		case <-timer.C:
+			waiting = false
+			c.flush(ctx)
+			if c.stale {
+				failures++
+			} else {
+				failures = 0
+			}
+		}
+		if waiting {
+			continue
+		}
+		switch {
+		case c.stale:
+			timer.Reset(reloadBackoff(failures))
+			waiting = true
+		case len(c.order) > 0:
+			timer.Reset(window)
+			waiting = true
+		}
+	}
+}
+
+// reloadBackoff returns delay before retrying a failed reload
+func reloadBackoff(retry int) time.Duration {
+	return min(time.Duration(retry+1)*100*time.Millisecond, 5*time.Second)
+}
+
+// Get returns cached row of key
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	v, ok := c.rows[key]
+	return v, ok
+}
+
+// Len returns number of cached rows
+func (c *Cache[K, V]) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.rows)
+}
+
+// Range calls fn for each row until it returns false, cache is
+// read locked meanwhile so fn should be fast and not block
+func (c *Cache[K, V]) Range(fn func(key K, value V) bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for k, v := range c.rows {
+		if !fn(k, v) {
+			return
+		}
+	}
+}
+
+// OnChange register callback invoked after each change applied,
+// including differences found by full reload
+func (c *Cache[K, V]) OnChange(fn func(Change[K, V])) {
+	c.callbackMu.Lock()
+	defer c.callbackMu.Unlock()
+	c.callbacks = append(c.callbacks, fn)
+}
+
+// reload fetch all rows and replace cache
+func (c *Cache[K, V]) reload(ctx context.Context) error {
+	rows, err := c.loader(ctx, c.query(nil))
+	if err != nil {
+		c.onError(fmt.Errorf("synccache: load %s: %w", c.table, err))
+		return err
+	}
+	c.mu.Lock()
+	old := c.rows
+	c.rows = rows
+	c.mu.Unlock()
+
+	var changes []Change[K, V]
+	for k, v := range rows {
+		if prev, ok := old[k]; !ok {
+			changes = append(changes, Change[K, V]{Action: Insert, Key: k, New: v})
+		} else if !reflect.DeepEqual(prev, v) {
+			changes = append(changes, Change[K, V]{Action: Update, Key: k, Old: prev, New: v})
+		}
+	}
+	for k, v := range old {
+		if _, ok := rows[k]; !ok {
+			changes = append(changes, Change[K, V]{Action: Delete, Key: k, Old: v})
+		}
+	}
+	c.notify(changes)
+	return nil
+}
+
+// handle apply a notification payload, either text <I|U|D><key> or T,
+// or JSON payload of package notify
+func (c *Cache[K, V]) handle(ctx context.Context, payload string) {
+	if notify.IsJSON(payload) {
+		c.handleJSON(ctx, payload)
+		return
+	}
+	if payload == notify.ActionTruncate {
+		c.discard()
+		c.stale = c.reload(ctx) != nil
+		return
+	}
+	if len(payload) < 2 {
+		c.onError(fmt.Errorf("synccache: %s %q: %w", c.Channel, payload, ErrInvalidPayload))
+		return
+	}
+	key, err := parseKey[K](payload[1:])
+	if err != nil {
+		c.onError(err)
+		return
+	}
+	c.apply(ctx, Action(payload[0]), key, nil)
+}
+
+// handleJSON apply a JSON payload, using its row image if DecodeRow is set
+func (c *Cache[K, V]) handleJSON(ctx context.Context, payload string) {
+	p, err := notify.Decode(payload)
+	if err != nil {
+		c.onError(fmt.Errorf("synccache: %s: %w", c.Channel, err))
+		return
+	}
+	if p.Action == notify.ActionTruncate {
+		c.discard()
+		c.stale = c.reload(ctx) != nil
+		return
+	}
+	var key K
+	if err := p.DecodeKey(&key); err != nil {
+		c.onError(fmt.Errorf("synccache: %s key %T: %w", c.Channel, key, err))
+		return
+	}
+	var row *V
+	if c.DecodeRow && p.Row != nil && !p.Fetch {
+		row = new(V)
+		if err := json.Unmarshal(p.Row, row); err != nil {
+			c.onError(fmt.Errorf("synccache: %s row %T: %w", c.Channel, *row, err))
+			row = nil
+		}
+	}
+	c.apply(ctx, Action(p.Action[0]), key, row)
+}
+
+// apply a change of key to cache: insert & update use row if given,
+// otherwise key is queued for batch fetch
+func (c *Cache[K, V]) apply(ctx context.Context, action Action, key K, row *V) {
+	switch action {
+	case Insert, Update:
+		if row == nil {
+			if _, ok := c.pending[key]; !ok {
+				c.pending[key] = struct{}{}
+				c.order = append(c.order, key)
+			}
+			if len(c.order) >= c.maxBatch() {
+				c.flush(ctx)
+			}
+			return
+		}
+		delete(c.pending, key)
+		c.set(key, row)
+	case Delete:
+		// a pending fetch would find nothing anyway
+		delete(c.pending, key)
+		c.set(key, nil)
+	default:
+		c.onError(fmt.Errorf("synccache: %s action %q: %w", c.Channel, byte(action), ErrInvalidPayload))
+	}
+}
+
+// flush fetch pending keys by one query, or reload whole table if
+// a previous fetch failed. a failed fetch marks cache stale
+func (c *Cache[K, V]) flush(ctx context.Context) {
+	if c.stale {
+		c.discard()
+		c.stale = c.reload(ctx) != nil
+		return
+	}
+	keys := make([]K, 0, len(c.pending))
+	for _, key := range c.order {
+		if _, ok := c.pending[key]; ok {
+			keys = append(keys, key)
+			delete(c.pending, key)
+		}
+	}
+	c.discard()
+	if len(keys) == 0 {
+		return
+	}
+	rows, err := c.loader(ctx, c.query(keys))
+	if err != nil {
+		c.onError(fmt.Errorf("synccache: load %d rows of %s: %w", len(keys), c.table, err))
+		c.stale = true
+		return
+	}
+	for _, key := range keys {
+		if v, found := rows[key]; found {
+			c.set(key, &v)
+		} else {
+			// deleted before we fetched it, a delete notification follows
+			c.set(key, nil)
+		}
+	}
+}
+
+// set store row of key, or delete key if row is nil, and notify change
+func (c *Cache[K, V]) set(key K, row *V) {
+	c.setIf(key, row, nil)
+}
+
+// setIf is set only if cond approves current row of key, reports
+// whether cache changed. nil cond always approves
+func (c *Cache[K, V]) setIf(key K, row *V, cond func(prev V, existed bool) bool) bool {
+	var change Change[K, V]
+	c.mu.Lock()
+	prev, existed := c.rows[key]
+	if cond != nil && !cond(prev, existed) {
+		c.mu.Unlock()
+		return false
+	}
+	switch {
+	case row != nil:
+		c.rows[key] = *row
+		change = Change[K, V]{Action: Update, Key: key, Old: prev, New: *row}
+		if !existed {
+			change.Action = Insert
+		}
+	case existed:
+		delete(c.rows, key)
+		change = Change[K, V]{Action: Delete, Key: key, Old: prev}
+	}
+	c.mu.Unlock()
+	if change.Action == 0 {
+		return false
+	}
+	c.notify([]Change[K, V]{change})
+	return true
+}
+
+// discard drop pending keys, e.g. before a full reload
+func (c *Cache[K, V]) discard() {
+	clear(c.pending)
+	c.order = c.order[:0]
+}
+
+// maxBatch returns MaxBatch or default
+func (c *Cache[K, V]) maxBatch() int {
+	if c.MaxBatch > 0 {
+		return c.MaxBatch
+	}
+	return DefaultMaxBatch
+}
+
+// query build loader query of keys
+func (c *Cache[K, V]) query(keys []K) Query[K] {
+	return Query[K]{DB: c.db, Table: c.table, KeyColumn: c.column, Keys: keys}
+}
+
+// notify invoke callbacks with changes
+func (c *Cache[K, V]) notify(changes []Change[K, V]) {
+	if len(changes) == 0 {
+		return
+	}
+	c.callbackMu.Lock()
+	callbacks := c.callbacks
+	c.callbackMu.Unlock()
+	for _, change := range changes {
+		for _, fn := range callbacks {
+			fn(change)
+		}
+	}
+}
+
+// onError report err to OnError if set
+func (c *Cache[K, V]) onError(err error) {
+	if c.OnError != nil {
+		c.OnError(err)
+	}
+}
@@ -0,0 +1,288 @@
+package synccache
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+import "github.com/Vonng/gopher/db/pg"
+
+type user struct {
+	ID   int64
+	Name string
+}
+
+// fakeTable is a loader backed by a map
+type fakeTable map[int64]user
+
+func (t fakeTable) load(ctx context.Context, q Query[int64]) (map[int64]user, error) {
+	res := map[int64]user{}
+	if q.Keys == nil {
+		for k, v := range t {
+			res[k] = v
+		}
+		return res, nil
+	}
+	for _, k := range q.Keys {
+		if v, ok := t[k]; ok {
+			res[k] = v
+		}
+	}
+	return res, nil
+}
+
+func TestCache(t *testing.T) {
+	table := fakeTable{1: {1, "alice"}, 2: {2, "bob"}}
+	c := New[int64, user](nil, "users", "id", table.load)
+	if c.Channel != "users_chan" {
+		t.Fatalf("Inconsistent channel: expected: %s, actual: %s", "users_chan", c.Channel)
+	}
+	var changes []Change[int64, user]
+	c.OnChange(func(change Change[int64, user]) { changes = append(changes, change) })
+	var errs []error
+	c.OnError = func(err error) { errs = append(errs, err) }
+	ctx := context.Background()
+
+	if err := c.reload(ctx); err != nil || c.Len() != 2 || len(changes) != 2 {
+		t.Fatalf("Inconsistent initial load: len %d, changes %d, err %v", c.Len(), len(changes), err)
+	}
+
+	table[3] = user{3, "carol"}
+	table[1] = user{1, "alice2"}
+	delete(table, 2)
+	changes = nil
+	for _, payload := range []string{"I3", "U1", "D2", "D2", "U9"} {
+		c.handle(ctx, payload)
+	}
+	c.flush(ctx)
+	want := []Change[int64, user]{
+		{Action: Delete, Key: 2, Old: user{2, "bob"}},
+		{Action: Insert, Key: 3, New: user{3, "carol"}},
+		{Action: Update, Key: 1, Old: user{1, "alice"}, New: user{1, "alice2"}},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Fatalf("Inconsistent changes: expected: %+v, actual: %+v", want, changes)
+	}
+	if v, ok := c.Get(1); !ok || v.Name != "alice2" {
+		t.Fatalf("Inconsistent row: %+v %v", v, ok)
+	}
+	if _, ok := c.Get(2); ok {
+		t.Fatal("deleted row still cached")
+	}
+
+	// update of a row deleted meanwhile drops it
+	delete(table, 3)
+	c.handle(ctx, "U3")
+	c.flush(ctx)
+	if _, ok := c.Get(3); ok {
+		t.Fatal("vanished row still cached")
+	}
+
+	// reload reports differences accumulated while disconnected
+	table[4] = user{4, "dave"}
+	delete(table, 1)
+	changes = nil
+	c.reload(ctx)
+	if len(changes) != 2 || c.Len() != 1 {
+		t.Fatalf("Inconsistent reload: len %d, changes %+v", c.Len(), changes)
+	}
+
+	// truncate reloads everything
+	delete(table, 4)
+	if c.handle(ctx, "T"); c.Len() != 0 {
+		t.Fatalf("Inconsistent len after truncate: expected: %d, actual: %d", 0, c.Len())
+	}
+	table[4] = user{4, "dave"}
+	c.reload(ctx)
+
+	keys := 0
+	c.Range(func(k int64, v user) bool { keys++; return true })
+	if keys != 1 {
+		t.Fatalf("Inconsistent range count: expected: %d, actual: %d", 1, keys)
+	}
+
+	for _, payload := range []string{"", "X1", "Uabc"} {
+		c.handle(ctx, payload)
+	}
+	if len(errs) != 3 || !errors.Is(errs[0], ErrInvalidPayload) || !errors.Is(errs[1], ErrInvalidPayload) {
+		t.Fatalf("Inconsistent errors: %v", errs)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	if k, err := parseKey[string]("a b"); err != nil || k != "a b" {
+		t.Errorf("parseKey[string] => %q %v", k, err)
+	}
+	if k, err := parseKey[int]("42"); err != nil || k != 42 {
+		t.Errorf("parseKey[int] => %d %v", k, err)
+	}
+	if _, err := parseKey[int32]("99999999999"); err == nil {
+		t.Error("parseKey[int32] should overflow")
+	}
+	if k, err := parseKey[netip.Addr]("10.0.0.1"); err != nil || k.String() != "10.0.0.1" {
+		t.Errorf("parseKey[netip.Addr] => %v %v", k, err)
+	}
+	if _, err := parseKey[float64]("1.5"); !errors.Is(err, ErrUnsupportedKey) {
+		t.Errorf("parseKey[float64] => %v, want %v", err, ErrUnsupportedKey)
+	}
+}
+
+func TestCacheJSON(t *testing.T) {
+	table := fakeTable{1: {1, "alice"}}
+	c := New[int64, user](nil, "users", "id", table.load)
+	var errs []error
+	c.OnError = func(err error) { errs = append(errs, err) }
+	ctx := context.Background()
+	c.reload(ctx)
+
+	// row image is ignored unless DecodeRow
+	c.handle(ctx, `{"v":1,"action":"U","table":"public.users","key":{"id":1},"row":{"ID":1,"Name":"stale"},"txid":1,"ts":"2018-06-01T00:00:00Z"}`)
+	if v, _ := c.Get(1); v.Name != "alice" {
+		t.Fatalf("Inconsistent row: expected: %s, actual: %s", "alice", v.Name)
+	}
+	c.DecodeRow = true
+	c.handle(ctx, `{"v":1,"action":"I","table":"public.users","key":{"id":2},"row":{"ID":2,"Name":"bob"},"txid":2,"ts":"2018-06-01T00:00:00Z"}`)
+	if v, ok := c.Get(2); !ok || v.Name != "bob" {
+		t.Fatalf("row image not applied: %+v", v)
+	}
+	// oversized row falls back to fetching
+	table[3] = user{3, "carol"}
+	c.handle(ctx, `{"v":1,"action":"I","table":"public.users","key":{"id":3},"fetch":true,"txid":3,"ts":"2018-06-01T00:00:00Z"}`)
+	c.flush(ctx)
+	if v, ok := c.Get(3); !ok || v.Name != "carol" {
+		t.Fatalf("row not fetched: %+v", v)
+	}
+	c.handle(ctx, `{"v":1,"action":"D","table":"public.users","key":{"id":2},"txid":4,"ts":"2018-06-01T00:00:00Z"}`)
+	if _, ok := c.Get(2); ok {
+		t.Fatal("deleted row still cached")
+	}
+	c.handle(ctx, `{"v":9,"action":"D"}`)
+	c.handle(ctx, `{"v":1,"action":"D","key":{"id":"x"}}`)
+	if len(errs) != 2 {
+		t.Fatalf("Inconsistent error count: expected: %d, actual: %d (%v)", 2, len(errs), errs)
+	}
+}
+
+func TestCacheBatch(t *testing.T) {
+	table := fakeTable{}
+	var batches [][]int64
+	loader := func(ctx context.Context, q Query[int64]) (map[int64]user, error) {
+		if q.Keys != nil {
+			batches = append(batches, q.Keys)
+		}
+		return table.load(ctx, q)
+	}
+	c := New[int64, user](nil, "users", "id", loader)
+	c.MaxBatch = 3
+	ctx := context.Background()
+
+	// duplicates collapse, a delete cancels pending fetch
+	for _, payload := range []string{"I1", "U1", "U2", "U1", "D2"} {
+		c.handle(ctx, payload)
+	}
+	if len(batches) != 0 {
+		t.Fatalf("fetched before window elapsed: %v", batches)
+	}
+	c.flush(ctx)
+	if want := [][]int64{{1}}; !reflect.DeepEqual(batches, want) {
+		t.Fatalf("Inconsistent batches: expected: %v, actual: %v", want, batches)
+	}
+
+	// full batch is fetched at once
+	batches = nil
+	for id := int64(1); id <= 7; id++ {
+		table[id] = user{id, "u"}
+		c.handle(ctx, "I"+strconv.FormatInt(id, 10))
+	}
+	c.flush(ctx)
+	if want := [][]int64{{1, 2, 3}, {4, 5, 6}, {7}}; !reflect.DeepEqual(batches, want) {
+		t.Fatalf("Inconsistent batches: expected: %v, actual: %v", want, batches)
+	}
+	if c.Len() != 7 {
+		t.Fatalf("Inconsistent len: expected: %d, actual: %d", 7, c.Len())
+	}
+}
+
+func TestCacheFollow(t *testing.T) {
+	table := fakeTable{1: {1, "alice"}}
+	c := New[int64, user](nil, "users", "id", table.load)
+	c.BatchWindow = 10 * time.Millisecond
+	changed := make(chan Change[int64, user], 1)
+	c.OnChange(func(change Change[int64, user]) { changed <- change })
+	events := make(chan pg.Event)
+	ready := make(chan struct{})
+	go c.follow(context.Background(), events, ready)
+	defer close(events)
+
+	events <- pg.Event{Kind: pg.Resync}
+	<-ready
+	<-changed
+
+	table[1] = user{1, "alice2"}
+	start := time.Now()
+	events <- pg.Event{Kind: pg.Notify, Channel: "users_chan", Payload: "U1"}
+	select {
+	case change := <-changed:
+		if change.New.Name != "alice2" || time.Since(start) < c.BatchWindow {
+			t.Fatalf("Inconsistent change: %+v after %v", change, time.Since(start))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending change never flushed")
+	}
+}
+
+func TestCacheRecover(t *testing.T) {
+	table := fakeTable{1: {1, "alice"}, 7: {7, "u"}}
+	var failures atomic.Int32
+	c := New[int64, user](nil, "users", "id", func(ctx context.Context, q Query[int64]) (map[int64]user, error) {
+		if failures.Load() > 0 {
+			failures.Add(-1)
+			return nil, errors.New("connection reset")
+		}
+		return table.load(ctx, q)
+	})
+	c.BatchWindow = 10 * time.Millisecond
+	reloaded := make(chan struct{})
+	c.OnChange(func(change Change[int64, user]) {
+		if change.Key == 7 && change.Action == Delete {
+			close(reloaded)
+		}
+	})
+	events := make(chan pg.Event)
+	ready := make(chan struct{})
+	go c.follow(context.Background(), events, ready)
+	defer close(events)
+	events <- pg.Event{Kind: pg.Resync}
+	<-ready
+
+	// fetch and the first reload fail, then cache recovers by itself
+	// with no further notification
+	delete(table, 7)
+	table[8] = user{8, "u"}
+	failures.Store(2)
+	events <- pg.Event{Kind: pg.Notify, Channel: "users_chan", Payload: "I8"}
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stale cache never reloaded")
+	}
+	if _, ok := c.Get(8); !ok || c.Len() != 2 {
+		t.Fatalf("Inconsistent cache after reload: len %d", c.Len())
+	}
+
+	// reload after truncate is retried as well
+	clear(table)
+	failures.Store(1)
+	events <- pg.Event{Kind: pg.Notify, Channel: "users_chan", Payload: "T"}
+	for deadline := time.Now().Add(5 * time.Second); c.Len() != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("truncated rows still cached: len %d", c.Len())
+		}
+	}
+}
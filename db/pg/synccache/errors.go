@@ -0,0 +1,18 @@
+package synccache
+
+import "errors"
+
+// Error returned by package synccache
+var (
+	// ErrInvalidPayload occurs when a notification is not <I|U|D><key>
+	ErrInvalidPayload = errors.New("notification payload should be <I|U|D><key>")
+
+	// ErrUnsupportedKey occurs when key type can't be parsed from text
+	ErrUnsupportedKey = errors.New("key type should be string, integer or encoding.TextUnmarshaler")
+
+	// ErrReadOnly occurs when publishing to a transport fed by the database itself
+	ErrReadOnly = errors.New("transport is read only")
+
+	// ErrMissingKey occurs when a row change does not carry the key column
+	ErrMissingKey = errors.New("change does not carry key column")
+)
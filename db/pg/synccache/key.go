@@ -0,0 +1,62 @@
+package synccache
+
+import (
+	"encoding"
+	"fmt"
+	"strconv"
+)
+
+// parseKey convert key text from notification into K
+func parseKey[K comparable](s string) (K, error) {
+	var key K
+	var err error
+	switch p := any(&key).(type) {
+	case *string:
+		*p = s
+	case *int:
+		*p, err = strconv.Atoi(s)
+	case *int32:
+		var n int64
+		n, err = strconv.ParseInt(s, 10, 32)
+		*p = int32(n)
+	case *int64:
+		*p, err = strconv.ParseInt(s, 10, 64)
+	case *uint32:
+		var n uint64
+		n, err = strconv.ParseUint(s, 10, 32)
+		*p = uint32(n)
+	case *uint64:
+		*p, err = strconv.ParseUint(s, 10, 64)
+	case encoding.TextUnmarshaler:
+		err = p.UnmarshalText([]byte(s))
+	default:
+		err = ErrUnsupportedKey
+	}
+	if err != nil {
+		return key, fmt.Errorf("synccache: parse key %q as %T: %w", s, key, err)
+	}
+	return key, nil
+}
+
+// formatKey returns key text as postgres would print key column,
+// the inverse of parseKey
+func formatKey[K comparable](key K) (string, error) {
+	switch k := any(key).(type) {
+	case string:
+		return k, nil
+	case int:
+		return strconv.Itoa(k), nil
+	case int32:
+		return strconv.FormatInt(int64(k), 10), nil
+	case int64:
+		return strconv.FormatInt(k, 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(k), 10), nil
+	case uint64:
+		return strconv.FormatUint(k, 10), nil
+	case encoding.TextMarshaler:
+		text, err := k.MarshalText()
+		return string(text), err
+	}
+	return "", fmt.Errorf("synccache: format key %T: %w", key, ErrUnsupportedKey)
+}
@@ -0,0 +1,123 @@
+package synccache
+
+import (
+	"context"
+	"fmt"
+)
+
+import "github.com/Vonng/gopher/db/pg"
+import "github.com/Vonng/gopher/db/pg/logical"
+
+/**************************************************************
+* struct: logicalTransport
+**************************************************************/
+
+// logicalTransport feeds changes of a logical replication slot
+type logicalTransport struct {
+	consumer  *logical.Consumer
+	keyColumn string
+}
+
+// Logical returns a read only transport over consumer, which needs no
+// trigger: changes of table are delivered on channel <table>_chan (as
+// Cache uses by default), table being bare or schema qualified name,
+// in text format with value of keyColumn. Changes retained by the slot
+// survive disconnects, so a Resync is only sent at start, or when the
+// key of a change is missing (e.g. old image lacking keyColumn, see
+// Consumer.ReplicaIdentityFull). A consumer serves one subscriber
+func Logical(consumer *logical.Consumer, keyColumn string) Transport {
+	return logicalTransport{consumer, keyColumn}
+}
+
+func (t logicalTransport) Subscribe(ctx context.Context, channel string, onError func(error)) <-chan Event {
+	events := make(chan Event, transportBuffer)
+	// consumer.OnError is left untouched as the consumer may be shared,
+	// errors go to it and onError both
+	report := func(err error) {
+		if t.consumer.OnError != nil {
+			t.consumer.OnError(err)
+		}
+		if onError != nil && ctx.Err() == nil {
+			onError(err)
+		}
+	}
+	handle := func(ctx context.Context, tx *logical.Tx) error {
+		payloads, err := logicalPayloads(tx, channel, t.keyColumn)
+		if err != nil {
+			report(err)
+			if !emit(ctx, events, Event{Kind: pg.Resync}) {
+				return ctx.Err()
+			}
+			return nil
+		}
+		for _, payload := range payloads {
+			if !emit(ctx, events, Event{Kind: pg.Notify, Channel: channel, Payload: payload}) {
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+	go func() {
+		defer close(events)
+		if !emit(ctx, events, Event{Kind: pg.Resync}) {
+			return
+		}
+		// same loop as Consumer.Run, with errors sent to report
+		batchSize, interval := t.consumer.BatchSize, t.consumer.PollInterval
+		if batchSize <= 0 {
+			batchSize = logical.DefaultBatchSize
+		}
+		if interval <= 0 {
+			interval = logical.DefaultPollInterval
+		}
+		for ctx.Err() == nil {
+			n, err := t.consumer.Poll(ctx, handle)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				report(err)
+			} else if n >= batchSize {
+				continue
+			}
+			sleep(ctx, interval)
+		}
+	}()
+	return events
+}
+
+func (t logicalTransport) Publish(ctx context.Context, channel, payload string) error {
+	return fmt.Errorf("synccache: %s: %w", channel, ErrReadOnly)
+}
+
+// logicalPayloads returns text payloads of changes in tx belonging to
+// channel. An update changing key deletes the old key as well
+func logicalPayloads(tx *logical.Tx, channel, keyColumn string) ([]string, error) {
+	var payloads []string
+	for _, change := range tx.Changes {
+		if channel != change.Relation.Name+"_chan" && channel != change.Table()+"_chan" {
+			continue
+		}
+		switch change.Action {
+		case logical.Truncate:
+			payloads = append(payloads, string(change.Action))
+			continue
+		case logical.Insert, logical.Update:
+			key, ok := change.New.Get(keyColumn)
+			if !ok {
+				return nil, fmt.Errorf("synccache: %s %s: %w", change.Table(), keyColumn, ErrMissingKey)
+			}
+			if old, ok := change.Old.Get(keyColumn); ok && old != key {
+				payloads = append(payloads, string(logical.Delete)+old)
+			}
+			payloads = append(payloads, string(change.Action)+key)
+		case logical.Delete:
+			key, ok := change.Old.Get(keyColumn)
+			if !ok {
+				return nil, fmt.Errorf("synccache: %s %s: %w", change.Table(), keyColumn, ErrMissingKey)
+			}
+			payloads = append(payloads, string(change.Action)+key)
+		}
+	}
+	return payloads, nil
+}
@@ -0,0 +1,369 @@
+package synccache
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+import gopg "github.com/go-pg/pg"
+import "github.com/Vonng/gopher/db/pg"
+
+// Reconcile defaults
+const (
+	DefaultBuckets           = 256
+	DefaultReconcileInterval = 10 * time.Minute
+)
+
+// Drift is the outcome of a reconciliation
+type Drift struct {
+	// Buckets : buckets compared, Mismatched : buckets whose checksum differ
+	Buckets    int
+	Mismatched int
+	// Missing : rows in table but not in cache, Stale : rows differ,
+	// Extra : rows in cache but not in table
+	Missing int
+	Stale   int
+	Extra   int
+	// Repaired : rows fixed in cache, less than drifted rows if they
+	// are changed by notification meanwhile
+	Repaired int
+	Duration time.Duration
+}
+
+// Rows returns number of drifted rows
+func (d Drift) Rows() int {
+	return d.Missing + d.Stale + d.Extra
+}
+
+// ReconcileStats accumulates drift over reconciliations
+type ReconcileStats struct {
+	// Runs : reconciliations done, Failures : reconciliations failed,
+	// Drifted : reconciliations found drift
+	Runs     int64
+	Failures int64
+	Drifted  int64
+	// Rows, Repaired : drifted and repaired rows in total
+	Rows     int64
+	Repaired int64
+	// Last : drift of last successful run
+	Last   Drift
+	LastAt time.Time
+}
+
+// bucketSum is row count and checksum of a bucket
+type bucketSum struct {
+	Rows     int
+	Checksum string
+}
+
+// checksummer computes checksums on table side
+type checksummer interface {
+	// sums returns checksum of each non-empty bucket
+	sums(ctx context.Context, buckets int) (map[int]bucketSum, error)
+	// digests returns row digests of given buckets by key text
+	digests(ctx context.Context, buckets int, which []int) (map[string]string, error)
+}
+
+/**************************************************************
+* struct: Reconciler
+**************************************************************/
+
+// Reconciler verifies a cache against its table and repairs drift
+// (missed notifications, bugs) without full reload.
+//
+// Rows are hashed into buckets by key, each side computes a checksum
+// per bucket from md5 of key text and row text. Row digests are only
+// fetched for buckets differing, and only drifted rows are reloaded.
+// Row text is produced by RowSQL on table and by RowText in cache,
+// which must agree exactly, e.g. RowSQL concat_ws('|', name, email)
+// with RowText u.Name + "|" + u.Email (concat_ws skips NULL, RowSQL
+// must never be NULL itself)
+type Reconciler[K comparable, V any] struct {
+	// Buckets : number of buckets, more buckets fetch fewer digests per
+	// mismatch but transfer more checksums. DefaultBuckets if 0
+	Buckets int
+	// Interval : period of Run, DefaultReconcileInterval if 0
+	Interval time.Duration
+	// OnDrift : called after each reconciliation finding drift, optional
+	OnDrift func(Drift)
+
+	cache   *Cache[K, V]
+	rowText func(V) string
+	table   checksummer
+
+	mu    sync.Mutex
+	stats ReconcileStats
+}
+
+// NewReconciler create a reconciler of cache, rowSQL is evaluated on
+// table rows and rowText on cached values, see Reconciler
+func NewReconciler[K comparable, V any](cache *Cache[K, V], rowSQL string, rowText func(V) string) *Reconciler[K, V] {
+	return newReconciler(cache, sqlChecksummer{db: cache.db, table: cache.table, column: cache.column, row: rowSQL}, rowText)
+}
+
+// newReconciler create a reconciler with given table side
+func newReconciler[K comparable, V any](cache *Cache[K, V], table checksummer, rowText func(V) string) *Reconciler[K, V] {
+	return &Reconciler[K, V]{cache: cache, rowText: rowText, table: table}
+}
+
+// Stats returns accumulated drift statistics
+func (r *Reconciler[K, V]) Stats() ReconcileStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+// Run reconcile every Interval until ctx is done, errors are reported
+// to cache's OnError. Run always returns ctx.Err()
+func (r *Reconciler[K, V]) Run(ctx context.Context) error {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = DefaultReconcileInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if _, err := r.Reconcile(ctx); err != nil && ctx.Err() == nil {
+				r.cache.onError(err)
+			}
+		}
+	}
+}
+
+// Reconcile compare cache with table once and repair drifted rows.
+// Rows changing during reconciliation may be reported as drift,
+// repair never overwrites a row changed by notification meanwhile
+func (r *Reconciler[K, V]) Reconcile(ctx context.Context) (Drift, error) {
+	drift, err := r.reconcile(ctx)
+	r.mu.Lock()
+	r.stats.Runs++
+	if err != nil {
+		r.stats.Failures++
+		r.mu.Unlock()
+		return drift, err
+	}
+	r.stats.Last, r.stats.LastAt = drift, time.Now()
+	if drift.Rows() > 0 {
+		r.stats.Drifted++
+		r.stats.Rows += int64(drift.Rows())
+		r.stats.Repaired += int64(drift.Repaired)
+	}
+	r.mu.Unlock()
+	if drift.Rows() > 0 && r.OnDrift != nil {
+		r.OnDrift(drift)
+	}
+	return drift, nil
+}
+
+// cached is digest of a cached row
+type cached[K comparable] struct {
+	key    K
+	digest string
+}
+
+func (r *Reconciler[K, V]) reconcile(ctx context.Context) (Drift, error) {
+	start := time.Now()
+	n := r.Buckets
+	if n <= 0 {
+		n = DefaultBuckets
+	}
+	drift := Drift{Buckets: n}
+
+	// snapshot cache digests by bucket, then compare with table
+	buckets := make(map[int]map[string]cached[K])
+	var err error
+	r.cache.Range(func(key K, value V) bool {
+		var text string
+		if text, err = formatKey(key); err != nil {
+			return false
+		}
+		b := bucketOf(text, n)
+		if buckets[b] == nil {
+			buckets[b] = map[string]cached[K]{}
+		}
+		buckets[b][text] = cached[K]{key, rowDigest(text, r.rowText(value))}
+		return true
+	})
+	if err != nil {
+		return drift, err
+	}
+	sums, err := r.table.sums(ctx, n)
+	if err != nil {
+		return drift, fmt.Errorf("synccache: checksum %s: %w", r.cache.table, err)
+	}
+	var mismatched []int
+	for b := 0; b < n; b++ {
+		if sums[b] != checksum(buckets[b]) {
+			mismatched = append(mismatched, b)
+		}
+	}
+	drift.Mismatched = len(mismatched)
+	if len(mismatched) == 0 {
+		drift.Duration = time.Since(start)
+		return drift, nil
+	}
+
+	// find drifted rows of mismatched buckets
+	digests, err := r.table.digests(ctx, n, mismatched)
+	if err != nil {
+		return drift, fmt.Errorf("synccache: digest %s: %w", r.cache.table, err)
+	}
+	expect := map[K]string{} // digest of drifted keys in cache, "" if absent
+	var keys []K
+	for _, b := range mismatched {
+		for text, row := range buckets[b] {
+			if digest, ok := digests[text]; !ok {
+				drift.Extra++
+			} else if digest != row.digest {
+				drift.Stale++
+			} else {
+				continue
+			}
+			expect[row.key] = row.digest
+			keys = append(keys, row.key)
+		}
+	}
+	for text := range digests {
+		if _, ok := buckets[bucketOf(text, n)][text]; ok {
+			continue
+		}
+		key, err := parseKey[K](text)
+		if err != nil {
+			return drift, err
+		}
+		drift.Missing++
+		expect[key] = ""
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		// rows changed between snapshot and checksum, nothing drifted
+		drift.Duration = time.Since(start)
+		return drift, nil
+	}
+
+	// reload drifted rows, unless changed since snapshot
+	rows, err := r.cache.loader(ctx, r.cache.query(keys))
+	if err != nil {
+		return drift, fmt.Errorf("synccache: load %d rows of %s: %w", len(keys), r.cache.table, err)
+	}
+	for _, key := range keys {
+		unchanged := func(prev V, existed bool) bool {
+			if !existed {
+				return expect[key] == ""
+			}
+			text, _ := formatKey(key)
+			return expect[key] == rowDigest(text, r.rowText(prev))
+		}
+		var row *V
+		if v, found := rows[key]; found {
+			row = &v
+		}
+		if r.cache.setIf(key, row, unchanged) {
+			drift.Repaired++
+		}
+	}
+	drift.Duration = time.Since(start)
+	return drift, nil
+}
+
+/**************************************************************
+* hashing, must agree with sqlChecksummer
+**************************************************************/
+
+// bucketOf returns bucket of key text: first 32 bits of md5 mod n
+func bucketOf(key string, n int) int {
+	sum := md5.Sum([]byte(key))
+	return int(binary.BigEndian.Uint32(sum[:4]) % uint32(n))
+}
+
+// rowDigest returns md5 hex of key text and row text separated by \x1f
+func rowDigest(key, row string) string {
+	sum := md5.Sum([]byte(key + "\x1f" + row))
+	return hex.EncodeToString(sum[:])
+}
+
+// checksum returns bucket sum of row digests: md5 hex of sorted digests
+func checksum[K comparable](rows map[string]cached[K]) bucketSum {
+	if len(rows) == 0 {
+		return bucketSum{}
+	}
+	digests := make([]string, 0, len(rows))
+	for _, row := range rows {
+		digests = append(digests, row.digest)
+	}
+	slices.Sort(digests)
+	sum := md5.Sum([]byte(strings.Join(digests, "")))
+	return bucketSum{Rows: len(rows), Checksum: hex.EncodeToString(sum[:])}
+}
+
+/**************************************************************
+* struct: sqlChecksummer
+**************************************************************/
+
+// sqlChecksummer computes checksums in postgres
+type sqlChecksummer struct {
+	db     *pg.DB
+	table  string
+	column string
+	row    string
+}
+
+// digestSQL selects key text, bucket and digest of each row
+const digestSQL = `SELECT ?::text AS key,
+  ('x' || left(md5(?::text), 8))::bit(32)::bigint % ? AS bucket,
+  md5(?::text || chr(31) || (?)) AS digest
+FROM ?`
+
+// params of digestSQL
+func (s sqlChecksummer) params(buckets int) []interface{} {
+	column := gopg.F(s.column)
+	return []interface{}{column, column, buckets, column, gopg.Q(s.row), gopg.F(s.table)}
+}
+
+func (s sqlChecksummer) sums(ctx context.Context, buckets int) (map[int]bucketSum, error) {
+	var rows []struct {
+		Bucket   int
+		Rows     int
+		Checksum string
+	}
+	_, err := s.db.WithContext(ctx).Query(&rows, `SELECT bucket, count(*) AS rows,
+  md5(string_agg(digest, '' ORDER BY digest COLLATE "C")) AS checksum
+FROM (`+digestSQL+`) d GROUP BY bucket`, s.params(buckets)...)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[int]bucketSum, len(rows))
+	for _, row := range rows {
+		res[row.Bucket] = bucketSum{Rows: row.Rows, Checksum: row.Checksum}
+	}
+	return res, nil
+}
+
+func (s sqlChecksummer) digests(ctx context.Context, buckets int, which []int) (map[string]string, error) {
+	var rows []struct {
+		Key    string
+		Digest string
+	}
+	_, err := s.db.WithContext(ctx).Query(&rows, `SELECT key, digest FROM (`+digestSQL+`) d WHERE bucket = ANY(?)`,
+		append(s.params(buckets), gopg.Array(which))...)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]string, len(rows))
+	for _, row := range rows {
+		res[row.Key] = row.Digest
+	}
+	return res, nil
+}
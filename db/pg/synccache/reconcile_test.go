@@ -0,0 +1,154 @@
+package synccache
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// userText is row text of user in reconciler tests
+func userText(u user) string {
+	return u.Name
+}
+
+// fakeChecksummer computes table side from fakeTable in go,
+// counting digests fetched
+type fakeChecksummer struct {
+	table   fakeTable
+	fetched int
+}
+
+func (f *fakeChecksummer) rows(buckets int) map[int]map[string]cached[int64] {
+	res := map[int]map[string]cached[int64]{}
+	for k, v := range f.table {
+		text := strconv.FormatInt(k, 10)
+		b := bucketOf(text, buckets)
+		if res[b] == nil {
+			res[b] = map[string]cached[int64]{}
+		}
+		res[b][text] = cached[int64]{k, rowDigest(text, userText(v))}
+	}
+	return res
+}
+
+func (f *fakeChecksummer) sums(ctx context.Context, buckets int) (map[int]bucketSum, error) {
+	res := map[int]bucketSum{}
+	for b, rows := range f.rows(buckets) {
+		res[b] = checksum(rows)
+	}
+	return res, nil
+}
+
+func (f *fakeChecksummer) digests(ctx context.Context, buckets int, which []int) (map[string]string, error) {
+	res := map[string]string{}
+	for b, rows := range f.rows(buckets) {
+		if slices.Contains(which, b) {
+			for text, row := range rows {
+				res[text] = row.digest
+				f.fetched++
+			}
+		}
+	}
+	return res, nil
+}
+
+func TestReconcile(t *testing.T) {
+	table := fakeTable{}
+	for id := int64(1); id <= 100; id++ {
+		table[id] = user{id, "u" + strconv.FormatInt(id, 10)}
+	}
+	var loaded [][]int64
+	loader := func(ctx context.Context, q Query[int64]) (map[int64]user, error) {
+		if q.Keys != nil {
+			keys := slices.Clone(q.Keys)
+			slices.Sort(keys)
+			loaded = append(loaded, keys)
+		}
+		return table.load(ctx, q)
+	}
+	c := New[int64, user](nil, "users", "id", loader)
+	ctx := context.Background()
+	c.reload(ctx)
+
+	sums := &fakeChecksummer{table: table}
+	r := newReconciler(c, sums, userText)
+	r.Buckets = 16
+	var drifts []Drift
+	r.OnDrift = func(d Drift) { drifts = append(drifts, d) }
+
+	if d, err := r.Reconcile(ctx); err != nil || d.Mismatched != 0 || sums.fetched != 0 {
+		t.Fatalf("consistent cache => %+v %v, fetched %d", d, err, sums.fetched)
+	}
+
+	// changes missed by cache
+	table[3] = user{3, "changed"}
+	delete(table, 5)
+	table[101] = user{101, "new"}
+	d, err := r.Reconcile(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Missing != 1 || d.Stale != 1 || d.Extra != 1 || d.Repaired != 3 || d.Mismatched > 3 {
+		t.Fatalf("Inconsistent drift: %+v", d)
+	}
+	if want := [][]int64{{3, 5, 101}}; !slices.EqualFunc(loaded, want, slices.Equal[[]int64]) {
+		t.Fatalf("Inconsistent loaded keys: expected: %v, actual: %v", want, loaded)
+	}
+	if sums.fetched >= len(table) {
+		t.Fatalf("fetched %d digests, all buckets were fetched", sums.fetched)
+	}
+	if v, _ := c.Get(3); v.Name != "changed" {
+		t.Fatalf("Inconsistent row: expected: %s, actual: %s", "changed", v.Name)
+	}
+	if _, ok := c.Get(5); ok || c.Len() != 100 {
+		t.Fatalf("Inconsistent cache: len %d", c.Len())
+	}
+	if d, _ := r.Reconcile(ctx); d.Mismatched != 0 {
+		t.Fatalf("drift not repaired: %+v", d)
+	}
+
+	stats := r.Stats()
+	if stats.Runs != 3 || stats.Drifted != 1 || stats.Rows != 3 || stats.Repaired != 3 || len(drifts) != 1 {
+		t.Fatalf("Inconsistent stats: %+v", stats)
+	}
+}
+
+func TestReconcileConcurrentChange(t *testing.T) {
+	table := fakeTable{1: {1, "alice"}}
+	c := New[int64, user](nil, "users", "id", nil)
+	c.loader = func(ctx context.Context, q Query[int64]) (map[int64]user, error) {
+		res, _ := table.load(ctx, q)
+		if q.Keys != nil {
+			// a notification applies a newer row while reconciler loads
+			c.set(1, &user{1, "newest"})
+		}
+		return res, nil
+	}
+	ctx := context.Background()
+	c.reload(ctx)
+	table[1] = user{1, "newer"}
+
+	r := newReconciler(c, &fakeChecksummer{table: table}, userText)
+	d, err := r.Reconcile(ctx)
+	if err != nil || d.Stale != 1 || d.Repaired != 0 {
+		t.Fatalf("Inconsistent drift: %+v %v", d, err)
+	}
+	if v, _ := c.Get(1); v.Name != "newest" {
+		t.Fatalf("repair overwrote newer row: %s", v.Name)
+	}
+}
+
+func TestFormatKey(t *testing.T) {
+	for _, key := range []string{"", "a b", "42"} {
+		if text, err := formatKey(key); err != nil || text != key {
+			t.Errorf("formatKey(%q) => %q %v", key, text, err)
+		}
+	}
+	if text, _ := formatKey(int32(-7)); text != "-7" {
+		t.Errorf("Inconsistent key text: expected: %s, actual: %s", "-7", text)
+	}
+	if _, err := formatKey(1.5); err == nil {
+		t.Error("formatKey(float64) should fail")
+	}
+}
@@ -0,0 +1,290 @@
+package synccache
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+import "github.com/go-redis/redis"
+import "github.com/Vonng/gopher/db/pg"
+
+// Redis transport settings
+const (
+	// DefaultStreamMaxLen : approximate entries kept in a stream
+	DefaultStreamMaxLen = 100000
+	// StreamPayloadField : stream entry field holding the payload
+	StreamPayloadField = "payload"
+
+	redisMinBackoff     = 100 * time.Millisecond
+	redisMaxBackoff     = 5 * time.Second
+	redisReceiveTimeout = time.Minute
+	// redisStreamBlock bounds a blocking read, thus delay of stopping
+	redisStreamBlock = 5 * time.Second
+	redisStreamCount = 256
+)
+
+/**************************************************************
+* struct: pubsubTransport
+**************************************************************/
+
+// pubsub is what pubsubTransport needs from *redis.PubSub
+type pubsub interface {
+	ReceiveTimeout(timeout time.Duration) (interface{}, error)
+	Ping(payload ...string) error
+	Close() error
+}
+
+// pubsubTransport is redis Pub/Sub
+type pubsubTransport struct {
+	subscribe func(channel string) pubsub
+	publish   func(channel, payload string) error
+}
+
+// RedisPubSub returns a transport over redis Pub/Sub. Like NOTIFY,
+// messages are lost while disconnected, client resubscribes by itself
+// and a Resync follows each (re)subscription
+func RedisPubSub(client redis.UniversalClient) Transport {
+	return pubsubTransport{
+		subscribe: func(channel string) pubsub { return client.Subscribe(channel) },
+		publish: func(channel, payload string) error {
+			return client.Publish(channel, payload).Err()
+		},
+	}
+}
+
+func (t pubsubTransport) Subscribe(ctx context.Context, channel string, onError func(error)) <-chan Event {
+	events := make(chan Event, transportBuffer)
+	ps := t.subscribe(channel)
+	stop := context.AfterFunc(ctx, func() { ps.Close() })
+	go func() {
+		defer close(events)
+		defer stop()
+		defer ps.Close()
+		for retry := 0; ctx.Err() == nil; {
+			msg, err := ps.ReceiveTimeout(redisReceiveTimeout)
+			var netErr net.Error
+			if err != nil && errors.As(err, &netErr) && netErr.Timeout() {
+				// idle: make sure connection is alive, reconnect if not
+				err = ps.Ping()
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				if onError != nil {
+					onError(err)
+				}
+				retry++
+				sleep(ctx, redisBackoff(retry))
+				continue
+			}
+			retry = 0
+			switch m := msg.(type) {
+			case *redis.Subscription:
+				// messages published before (re)subscription are lost
+				if m.Kind == "subscribe" && !emit(ctx, events, Event{Kind: pg.Resync}) {
+					return
+				}
+			case *redis.Message:
+				if !emit(ctx, events, Event{Kind: pg.Notify, Channel: m.Channel, Payload: m.Payload}) {
+					return
+				}
+			}
+		}
+	}()
+	return events
+}
+
+func (t pubsubTransport) Publish(ctx context.Context, channel, payload string) error {
+	return t.publish(channel, payload)
+}
+
+/**************************************************************
+* struct: streamTransport
+**************************************************************/
+
+// stream is what streamTransport needs from a redis stream
+type stream interface {
+	// add append payload to stream
+	add(stream, payload string) error
+	// read entries after id, waiting at most block for new entries
+	read(stream, after string, block time.Duration) ([]redis.XMessage, error)
+	// first, last returns id of oldest and newest entry, "" if empty
+	first(stream string) (string, error)
+	last(stream string) (string, error)
+}
+
+// streamTransport is redis Streams, channel is used as stream key
+type streamTransport struct {
+	stream stream
+}
+
+// RedisStream returns a transport over redis Streams, keeping about
+// maxLen entries (DefaultStreamMaxLen if 0). Subscribers track position
+// in stream and resume after errors without loss, a Resync is only
+// needed at start or when entries not read yet are trimmed
+func RedisStream(client redis.UniversalClient, maxLen int64) Transport {
+	if maxLen <= 0 {
+		maxLen = DefaultStreamMaxLen
+	}
+	return streamTransport{redisStream{client, maxLen}}
+}
+
+func (t streamTransport) Subscribe(ctx context.Context, channel string, onError func(error)) <-chan Event {
+	events := make(chan Event, transportBuffer)
+	go func() {
+		defer close(events)
+		report := func(err error) {
+			if onError != nil && ctx.Err() == nil {
+				onError(err)
+			}
+		}
+		var last string
+		gap := true
+		for retry := 0; ctx.Err() == nil; {
+			if retry > 0 {
+				sleep(ctx, redisBackoff(retry))
+			}
+			if gap {
+				// start from newest entry, earlier ones are covered by reload
+				id, err := t.stream.last(channel)
+				if err != nil {
+					report(err)
+					retry++
+					continue
+				}
+				last, gap = id, false
+				if last == "" {
+					// empty stream: first entry added is taken as a gap too
+					last = "0-0"
+				}
+				if !emit(ctx, events, Event{Kind: pg.Resync}) {
+					return
+				}
+			}
+			prev := last
+			messages, err := t.stream.read(channel, last, redisStreamBlock)
+			if err != nil {
+				report(err)
+				retry++
+			} else {
+				retry = 0
+				for _, m := range messages {
+					last = m.ID
+					payload, _ := m.Values[StreamPayloadField].(string)
+					if !emit(ctx, events, Event{Kind: pg.Notify, Channel: channel, Payload: payload}) {
+						return
+					}
+				}
+			}
+			// entries after prev may be trimmed before they are read, which
+			// is ruled out only if the oldest entry retained is not later
+			// than the one right after prev. ids are not contiguous, so a
+			// reader lagging about maxLen behind resyncs even without loss
+			first, err := t.stream.first(channel)
+			if err != nil {
+				report(err)
+			}
+			gap = err != nil || first != "" && compareID(first, nextID(prev)) > 0
+		}
+	}()
+	return events
+}
+
+func (t streamTransport) Publish(ctx context.Context, channel, payload string) error {
+	return t.stream.add(channel, payload)
+}
+
+// redisStream implements stream with go-redis
+type redisStream struct {
+	client redis.UniversalClient
+	maxLen int64
+}
+
+func (s redisStream) add(stream, payload string) error {
+	return s.client.XAdd(&redis.XAddArgs{
+		Stream:       stream,
+		MaxLenApprox: s.maxLen,
+		Values:       map[string]interface{}{StreamPayloadField: payload},
+	}).Err()
+}
+
+func (s redisStream) read(stream, after string, block time.Duration) ([]redis.XMessage, error) {
+	streams, err := s.client.XRead(&redis.XReadArgs{
+		Streams: []string{stream, after},
+		Count:   redisStreamCount,
+		Block:   block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil || len(streams) == 0 {
+		return nil, err
+	}
+	return streams[0].Messages, nil
+}
+
+func (s redisStream) first(stream string) (string, error) {
+	return s.edge(s.client.XRangeN(stream, "-", "+", 1).Result())
+}
+
+func (s redisStream) last(stream string) (string, error) {
+	return s.edge(s.client.XRevRangeN(stream, "+", "-", 1).Result())
+}
+
+// edge returns id of the only message if any
+func (s redisStream) edge(messages []redis.XMessage, err error) (string, error) {
+	if err != nil || len(messages) == 0 {
+		return "", err
+	}
+	return messages[0].ID, nil
+}
+
+/**************************************************************
+* helpers
+**************************************************************/
+
+// compareID compare stream entry ids <ms>-<seq> numerically
+func compareID(a, b string) int {
+	am, as := splitID(a)
+	bm, bs := splitID(b)
+	if am != bm {
+		return cmp.Compare(am, bm)
+	}
+	return cmp.Compare(as, bs)
+}
+
+// splitID split stream entry id, malformed parts are 0
+func splitID(id string) (ms, seq uint64) {
+	m, s, _ := strings.Cut(id, "-")
+	ms, _ = strconv.ParseUint(m, 10, 64)
+	seq, _ = strconv.ParseUint(s, 10, 64)
+	return ms, seq
+}
+
+// nextID returns the smallest id after id
+func nextID(id string) string {
+	ms, seq := splitID(id)
+	return strconv.FormatUint(ms, 10) + "-" + strconv.FormatUint(seq+1, 10)
+}
+
+// redisBackoff returns delay before retry, doubling from redisMinBackoff
+func redisBackoff(retry int) time.Duration {
+	d := redisMinBackoff << min(retry-1, 10)
+	return min(d, redisMaxBackoff)
+}
+
+// sleep wait d unless ctx is done first
+func sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
@@ -0,0 +1,65 @@
+package synccache
+
+import (
+	"context"
+)
+
+import "github.com/Vonng/gopher/db/pg"
+
+// Event is delivered by Transport: Notify carries a change payload,
+// Resync tells that changes may have been lost and state should reload
+type Event = pg.Event
+
+// Transport carries change payloads between writers and caches.
+// Payloads are text or JSON formats of package notify, whoever the
+// writer is: a postgres trigger or application code calling Publish
+type Transport interface {
+	// Subscribe deliver events of channel until ctx is done, then the
+	// channel is closed. The first event is Resync, and so is any event
+	// following a gap in delivery. Errors are reported to onError if
+	// not nil, transport recovers by itself
+	Subscribe(ctx context.Context, channel string, onError func(error)) <-chan Event
+	// Publish send a payload to subscribers of channel
+	Publish(ctx context.Context, channel, payload string) error
+}
+
+// transportBuffer is the capacity of event channels
+const transportBuffer = 64
+
+/**************************************************************
+* struct: notifyTransport
+**************************************************************/
+
+// notifyTransport is postgres LISTEN/NOTIFY
+type notifyTransport struct {
+	db *pg.DB
+}
+
+// Notify returns a transport over postgres LISTEN/NOTIFY of db, which
+// is used by Cache if no Transport given. Notifications are lost while
+// disconnected, a Resync follows each reconnect
+func Notify(db *pg.DB) Transport {
+	return notifyTransport{db}
+}
+
+func (t notifyTransport) Subscribe(ctx context.Context, channel string, onError func(error)) <-chan Event {
+	sub := pg.NewSubscriber(t.db, channel)
+	sub.OnError = onError
+	go sub.Run(ctx)
+	return sub.Events()
+}
+
+func (t notifyTransport) Publish(ctx context.Context, channel, payload string) error {
+	_, err := t.db.DB.WithContext(ctx).Exec(`SELECT pg_notify(?, ?)`, channel, payload)
+	return err
+}
+
+// emit deliver event unless ctx is done first
+func emit(ctx context.Context, events chan<- Event, event Event) bool {
+	select {
+	case events <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
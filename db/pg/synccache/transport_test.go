@@ -0,0 +1,391 @@
+package synccache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+import "github.com/go-redis/redis"
+import "github.com/Vonng/gopher/db/pg"
+import "github.com/Vonng/gopher/db/pg/logical"
+
+// timeoutError is a net.Error timeout
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// fakePubSub replays scripted receive results
+type fakePubSub struct {
+	mu       sync.Mutex
+	received []interface{} // message or error
+	pings    int
+	closed   chan struct{}
+}
+
+func (f *fakePubSub) ReceiveTimeout(timeout time.Duration) (interface{}, error) {
+	f.mu.Lock()
+	if len(f.received) == 0 {
+		f.mu.Unlock()
+		<-f.closed
+		return nil, errors.New("redis: client is closed")
+	}
+	next := f.received[0]
+	f.received = f.received[1:]
+	f.mu.Unlock()
+	if err, ok := next.(error); ok {
+		return nil, err
+	}
+	return next, nil
+}
+
+func (f *fakePubSub) Ping(payload ...string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pings++
+	return nil
+}
+
+func (f *fakePubSub) Close() error {
+	select {
+	case <-f.closed:
+	default:
+		close(f.closed)
+	}
+	return nil
+}
+
+// collect read n events or fail after timeout
+func collect(t *testing.T, events <-chan Event, n int) []Event {
+	t.Helper()
+	var res []Event
+	for len(res) < n {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatalf("events closed after %v", res)
+			}
+			res = append(res, e)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting events, got %v", res)
+		}
+	}
+	return res
+}
+
+func TestRedisPubSub(t *testing.T) {
+	ps := &fakePubSub{closed: make(chan struct{}), received: []interface{}{
+		&redis.Subscription{Kind: "subscribe", Channel: "users_chan", Count: 1},
+		&redis.Message{Channel: "users_chan", Payload: "I1"},
+		timeoutError{},
+		errors.New("connection reset"),
+		// client reconnected and resubscribed
+		&redis.Subscription{Kind: "subscribe", Channel: "users_chan", Count: 1},
+		&redis.Pong{},
+		&redis.Message{Channel: "users_chan", Payload: "D1"},
+	}}
+	var published []string
+	transport := pubsubTransport{
+		subscribe: func(channel string) pubsub { return ps },
+		publish: func(channel, payload string) error {
+			published = append(published, channel+":"+payload)
+			return nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var errs []error
+	events := transport.Subscribe(ctx, "users_chan", func(err error) { errs = append(errs, err) })
+
+	want := []Event{
+		{Kind: pg.Resync},
+		{Kind: pg.Notify, Channel: "users_chan", Payload: "I1"},
+		{Kind: pg.Resync},
+		{Kind: pg.Notify, Channel: "users_chan", Payload: "D1"},
+	}
+	if got := collect(t, events, len(want)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Inconsistent events: expected: %v, actual: %v", want, got)
+	}
+	cancel()
+	if _, ok := <-events; ok {
+		t.Fatal("events not closed after cancel")
+	}
+	if len(errs) != 1 || ps.pings != 1 {
+		t.Fatalf("Inconsistent errors %v, pings %d", errs, ps.pings)
+	}
+
+	transport.Publish(ctx, "users_chan", "U1")
+	if want := []string{"users_chan:U1"}; !reflect.DeepEqual(published, want) {
+		t.Fatalf("Inconsistent published: expected: %v, actual: %v", want, published)
+	}
+}
+
+// fakeStream is an in-memory stream, read fails while err is set
+type fakeStream struct {
+	mu      sync.Mutex
+	entries []redis.XMessage
+	seq     int
+	err     error
+}
+
+func (f *fakeStream) add(stream, payload string) error {
+	f.burst(0, payload)
+	return nil
+}
+
+// burst add payloads then keep only the newest n entries (all if 0) at once
+func (f *fakeStream) burst(n int, payloads ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, payload := range payloads {
+		f.seq++
+		f.entries = append(f.entries, redis.XMessage{
+			ID:     "1-" + strconv.Itoa(f.seq),
+			Values: map[string]interface{}{StreamPayloadField: payload},
+		})
+	}
+	if n > 0 {
+		f.entries = f.entries[len(f.entries)-n:]
+	}
+}
+
+// trim keep only the newest n entries
+func (f *fakeStream) trim(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries = f.entries[len(f.entries)-n:]
+}
+
+func (f *fakeStream) fail(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+func (f *fakeStream) read(stream, after string, block time.Duration) ([]redis.XMessage, error) {
+	f.mu.Lock()
+	if err := f.err; err != nil {
+		f.err = nil
+		f.mu.Unlock()
+		return nil, err
+	}
+	var res []redis.XMessage
+	for _, e := range f.entries {
+		if compareID(e.ID, after) > 0 {
+			res = append(res, e)
+		}
+	}
+	f.mu.Unlock()
+	if len(res) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	return res, nil
+}
+
+func (f *fakeStream) first(stream string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.entries) == 0 {
+		return "", nil
+	}
+	return f.entries[0].ID, nil
+}
+
+func (f *fakeStream) last(stream string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.entries) == 0 {
+		return "", nil
+	}
+	return f.entries[len(f.entries)-1].ID, nil
+}
+
+func TestRedisStream(t *testing.T) {
+	s := &fakeStream{}
+	transport := streamTransport{s}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// entries before subscription are covered by the initial resync
+	transport.Publish(ctx, "users_chan", "I0")
+	events := transport.Subscribe(ctx, "users_chan", nil)
+	if e := collect(t, events, 1); e[0].Kind != pg.Resync {
+		t.Fatalf("Inconsistent first event: %v", e[0])
+	}
+
+	transport.Publish(ctx, "users_chan", "I1")
+	if e := collect(t, events, 1); e[0].Payload != "I1" {
+		t.Fatalf("Inconsistent payload: expected: %s, actual: %s", "I1", e[0].Payload)
+	}
+
+	// error while entries are retained: resume without loss
+	s.fail(errors.New("connection reset"))
+	transport.Publish(ctx, "users_chan", "U1")
+	transport.Publish(ctx, "users_chan", "U2")
+	e := collect(t, events, 2)
+	if e[0].Payload != "U1" || e[1].Payload != "U2" {
+		t.Fatalf("Inconsistent events after error: %v", e)
+	}
+
+	// unread entries trimmed during error: resync
+	s.fail(errors.New("connection reset"))
+	transport.Publish(ctx, "users_chan", "D1")
+	transport.Publish(ctx, "users_chan", "D2")
+	s.trim(1)
+	if e := collect(t, events, 1); e[0].Kind != pg.Resync {
+		t.Fatalf("trimmed entries not followed by resync: %v", e[0])
+	}
+
+	// unread entries trimmed between two successful reads: resync
+	transport.Publish(ctx, "users_chan", "I2")
+	if e := collect(t, events, 1); e[0].Payload != "I2" {
+		t.Fatalf("Inconsistent payload: expected: %s, actual: %s", "I2", e[0].Payload)
+	}
+	// I4 may be read before the check, or skipped as covered by resync
+	s.burst(1, "I3", "I4")
+	if e = collect(t, events, 1); e[0].Payload == "I4" {
+		e = collect(t, events, 1)
+	}
+	if e[0].Kind != pg.Resync {
+		t.Fatalf("entries trimmed between reads not followed by resync: %v", e[0])
+	}
+}
+
+func TestCompareID(t *testing.T) {
+	table := []struct {
+		a, b string
+		cmp  int
+	}{
+		{"1-0", "1-0", 0},
+		{"1-2", "1-10", -1},
+		{"10-0", "9-99", 1},
+		{"0-0", "1526919030474-55", -1},
+	}
+	for _, tt := range table {
+		if c := compareID(tt.a, tt.b); c != tt.cmp {
+			t.Errorf("Inconsistent compareID(%s, %s): expected: %d, actual: %d", tt.a, tt.b, tt.cmp, c)
+		}
+	}
+}
+
+// chanTransport is an in-process transport
+type chanTransport struct {
+	events chan Event
+}
+
+func (t chanTransport) Subscribe(ctx context.Context, channel string, onError func(error)) <-chan Event {
+	return t.events
+}
+
+func (t chanTransport) Publish(ctx context.Context, channel, payload string) error {
+	t.events <- Event{Kind: pg.Notify, Channel: channel, Payload: payload}
+	return nil
+}
+
+func TestCacheTransport(t *testing.T) {
+	table := fakeTable{1: {1, "alice"}}
+	c := New[int64, user](nil, "users", "id", table.load)
+	c.BatchWindow = time.Millisecond
+	transport := chanTransport{make(chan Event, 4)}
+	c.Transport = transport
+	changed := make(chan Change[int64, user], 4)
+	c.OnChange(func(change Change[int64, user]) { changed <- change })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transport.events <- Event{Kind: pg.Resync}
+	if err := c.Start(ctx); err != nil || c.Len() != 1 {
+		t.Fatalf("Start => %v, len %d", err, c.Len())
+	}
+	<-changed
+
+	table[2] = user{2, "bob"}
+	transport.Publish(ctx, c.Channel, "I2")
+	select {
+	case change := <-changed:
+		if change.Key != 2 || change.Action != Insert {
+			t.Fatalf("Inconsistent change: %+v", change)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("published change not applied")
+	}
+}
+
+func TestLogicalPayloads(t *testing.T) {
+	users := &logical.Relation{Namespace: "public", Name: "users"}
+	orders := &logical.Relation{Namespace: "public", Name: "orders"}
+	row := func(id string) logical.Row {
+		return logical.Row{{Name: "id", Key: true, Value: []byte(id)}, {Name: "name", Value: []byte("alice")}}
+	}
+	tx := &logical.Tx{Changes: []logical.Change{
+		{Action: logical.Insert, Relation: users, New: row("1")},
+		{Action: logical.Insert, Relation: orders, New: row("9")},
+		{Action: logical.Update, Relation: users, New: row("2")},
+		{Action: logical.Update, Relation: users, Old: row("2"), New: row("3")},
+		{Action: logical.Delete, Relation: users, Old: row("3")[:1]},
+		{Action: logical.Truncate, Relation: users},
+	}}
+	expected := []string{"I1", "U2", "D2", "U3", "D3", "T"}
+	for _, channel := range []string{"users_chan", "public.users_chan"} {
+		payloads, err := logicalPayloads(tx, channel, "id")
+		if err != nil || !reflect.DeepEqual(payloads, expected) {
+			t.Errorf("Inconsistent payloads of %s: expected: %v, actual: %v %v", channel, expected, payloads, err)
+		}
+	}
+
+	// old image without key column: caller resyncs
+	tx.Changes = []logical.Change{{Action: logical.Delete, Relation: users, Old: row("3")[1:]}}
+	if _, err := logicalPayloads(tx, "users_chan", "id"); !errors.Is(err, ErrMissingKey) {
+		t.Errorf("Inconsistent error: expected: %v, actual: %v", ErrMissingKey, err)
+	}
+	if err := Logical(nil, "id").Publish(context.Background(), "users_chan", "I1"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Inconsistent error: expected: %v, actual: %v", ErrReadOnly, err)
+	}
+}
+
+func TestLogicalSubscribe(t *testing.T) {
+	// nothing listens on port 1, every poll fails
+	consumer := logical.NewConsumer(&pg.DB{DB: pg.NewPg("postgres://bob@127.0.0.1:1/app")}, "users_slot", "users_pub")
+	consumer.PollInterval = 10 * time.Millisecond
+	shared := make(chan error, 16)
+	consumer.OnError = func(err error) {
+		select {
+		case shared <- err:
+		default:
+		}
+	}
+	original := reflect.ValueOf(consumer.OnError).Pointer()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	subscribed := make(chan error, 16)
+	events := Logical(consumer, "id").Subscribe(ctx, "users_chan", func(err error) {
+		select {
+		case subscribed <- err:
+		default:
+		}
+	})
+	if e := collect(t, events, 1); e[0].Kind != pg.Resync {
+		t.Fatalf("Inconsistent first event: expected: %v, actual: %v", pg.Resync, e[0].Kind)
+	}
+	for name, errs := range map[string]chan error{"consumer": shared, "subscriber": subscribed} {
+		select {
+		case err := <-errs:
+			if !strings.Contains(err.Error(), "users_slot") {
+				t.Errorf("Inconsistent %s error: %v", name, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("poll failure not reported to %s", name)
+		}
+	}
+	if reflect.ValueOf(consumer.OnError).Pointer() != original {
+		t.Fatal("Subscribe should not replace consumer.OnError")
+	}
+	cancel()
+	for range events {
+	}
+}
@@ -0,0 +1,287 @@
+package pg
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"hash"
+	"os"
+	"path/filepath"
+)
+
+import "golang.org/x/crypto/pbkdf2"
+
+// defaultSSLFile returns ~/.postgresql/name like libpq
+func defaultSSLFile(name string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".postgresql", name)
+}
+
+// fileExists reports whether path is an existing regular file
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// tlsConfig build tls config according to sslmode:
+//
+//	disable           no tls
+//	allow             plain-text first, tls without verification if server
+//	                  rejects plain-text session
+//	prefer            tls without verification first, plain-text if server
+//	                  refuses ssl or tls session fails
+//	require           tls without verification, unless root cert exists,
+//	                  then same as verify-ca
+//	verify-ca         verify server certificate chain against root cert
+//	verify-full       verify-ca plus server host name matching the host
+//	                  dialed, see hostTLS
+//
+// client certificate is sent when sslcert & sslkey are present
+func (cfg Config) tlsConfig() (*tls.Config, error) {
+	invalid := func(part, value string, err error) error {
+		return &URLError{URL: cfg.Addr(), Part: part, Value: value, Err: err}
+	}
+	if cfg.SSLMode == "" || cfg.SSLMode == "disable" {
+		return nil, nil
+	}
+	config := &tls.Config{}
+
+	// client certificate: explicit file must exist, default file is optional
+	certFile, keyFile := cfg.SSLCert, cfg.SSLKey
+	if certFile == "" {
+		if certFile = defaultSSLFile("postgresql.crt"); !fileExists(certFile) {
+			certFile = ""
+		}
+	}
+	if keyFile == "" {
+		keyFile = defaultSSLFile("postgresql.key")
+	}
+	if certFile != "" {
+		cert, err := loadKeyPair(certFile, keyFile, cfg.SSLPassword)
+		if err != nil {
+			return nil, invalid("sslcert", certFile, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	// root certificate: system pool, explicit file, or default file
+	mode, rootFile := cfg.SSLMode, cfg.SSLRootCert
+	if rootFile == "" {
+		if rootFile = defaultSSLFile("root.crt"); !fileExists(rootFile) {
+			rootFile = ""
+		}
+	}
+	if mode == "require" && rootFile != "" {
+		mode = "verify-ca"
+	}
+	switch mode {
+	case "allow", "prefer", "require":
+		config.InsecureSkipVerify = true
+		return config, nil
+	}
+	if rootFile == "" {
+		return nil, invalid("sslrootcert", cfg.SSLRootCert, ErrNoRootCert)
+	}
+	if rootFile != "system" {
+		data, err := os.ReadFile(rootFile)
+		if err != nil {
+			return nil, invalid("sslrootcert", rootFile, err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, invalid("sslrootcert", rootFile, errors.New("no certificate found"))
+		}
+	}
+
+	// verify-full is what crypto/tls does, with ServerName set per host
+	if mode == "verify-full" {
+		return config, nil
+	}
+
+	// verify-ca checks chain only
+	roots := config.RootCAs
+	config.InsecureSkipVerify = true
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		return verifyChain(rawCerts, roots)
+	}
+	return config, nil
+}
+
+// hostTLS returns config for dialing ep: a copy with ServerName of ep
+// if host name is verified (verify-full), config itself otherwise
+func hostTLS(config *tls.Config, ep Endpoint) *tls.Config {
+	if config.InsecureSkipVerify {
+		return config
+	}
+	config = config.Clone()
+	config.ServerName = ep.Host
+	return config
+}
+
+// verifyChain verify certificate chain against roots (nil for system pool)
+func verifyChain(rawCerts [][]byte, roots *x509.CertPool) error {
+	if len(rawCerts) == 0 {
+		return errors.New("pg: server sent no certificate")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs[i] = cert
+	}
+	opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(opts)
+	return err
+}
+
+// loadKeyPair load client certificate and key, the key could be
+// encrypted by password: PKCS#8 with PBES2 (openssl default) or legacy PEM
+func loadKeyPair(certFile, keyFile, password string) (tls.Certificate, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return tls.Certificate{}, errors.New("no PEM data found in " + keyFile)
+	}
+	encrypted := block.Type == "ENCRYPTED PRIVATE KEY"
+	if encrypted || x509.IsEncryptedPEMBlock(block) {
+		if password == "" {
+			return tls.Certificate{}, errors.New("key " + keyFile + " is encrypted, sslpassword required")
+		}
+		var der []byte
+		if encrypted {
+			der, err = decryptPKCS8(block.Bytes, []byte(password))
+			block.Type = "PRIVATE KEY"
+		} else {
+			der, err = x509.DecryptPEMBlock(block, []byte(password))
+		}
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		keyPEM = pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der})
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+/**************************************************************
+* PKCS#8 encrypted private key
+**************************************************************/
+
+var (
+	oidPBES2      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// encryptedPrivateKeyInfo, pbes2Params, pbkdf2Params are RFC 8018 structures
+type encryptedPrivateKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Data      []byte
+}
+
+type pbes2Params struct {
+	KDF    pkix.AlgorithmIdentifier
+	Cipher pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	PRF        pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// decryptPKCS8 decrypt EncryptedPrivateKeyInfo into PrivateKeyInfo DER.
+// only PBES2 with PBKDF2 (HMAC-SHA1 or SHA256) and AES-CBC is supported,
+// which covers keys written by openssl 1.1+ without legacy options
+func decryptPKCS8(der, password []byte) ([]byte, error) {
+	unsupported := errors.New("unsupported PKCS#8 key encryption, convert key with openssl pkcs8 -topk8 -v2 aes256")
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, unsupported
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+	if !params.KDF.Algorithm.Equal(oidPBKDF2) {
+		return nil, unsupported
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KDF.Parameters.FullBytes, &kdf); err != nil {
+		return nil, err
+	}
+	var prf func() hash.Hash
+	switch {
+	case kdf.PRF.Algorithm == nil, kdf.PRF.Algorithm.Equal(oidHMACSHA1):
+		prf = sha1.New
+	case kdf.PRF.Algorithm.Equal(oidHMACSHA256):
+		prf = sha256.New
+	default:
+		return nil, unsupported
+	}
+	var keyLen int
+	switch {
+	case params.Cipher.Algorithm.Equal(oidAES128CBC):
+		keyLen = 16
+	case params.Cipher.Algorithm.Equal(oidAES192CBC):
+		keyLen = 24
+	case params.Cipher.Algorithm.Equal(oidAES256CBC):
+		keyLen = 32
+	default:
+		return nil, unsupported
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.Cipher.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(pbkdf2.Key(password, kdf.Salt, kdf.Iterations, keyLen, prf))
+	if err != nil {
+		return nil, err
+	}
+	data := info.Data
+	if len(iv) != block.BlockSize() || len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return nil, errors.New("malformed PKCS#8 encrypted key")
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+	// strip PKCS#7 padding, a wrong password almost always breaks it
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > block.BlockSize() {
+		return nil, x509.IncorrectPasswordError
+	}
+	for _, b := range plain[len(plain)-pad:] {
+		if int(b) != pad {
+			return nil, x509.IncorrectPasswordError
+		}
+	}
+	return plain[:len(plain)-pad], nil
+}
@@ -0,0 +1,285 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+import "github.com/go-pg/pg"
+import "github.com/go-pg/pg/orm"
+
+// IsolationLevel of a transaction, empty means server default
+type IsolationLevel string
+
+// Transaction isolation levels
+const (
+	ReadCommitted  IsolationLevel = "READ COMMITTED"
+	RepeatableRead IsolationLevel = "REPEATABLE READ"
+	Serializable   IsolationLevel = "SERIALIZABLE"
+)
+
+// Transaction retry defaults
+const (
+	DefaultTxRetries    = 5
+	DefaultTxMinBackoff = 10 * time.Millisecond
+	DefaultTxMaxBackoff = time.Second
+)
+
+// TxOptions controls WithTx, nil means server default isolation,
+// read write, and retry with default backoff
+type TxOptions struct {
+	Isolation IsolationLevel
+	ReadOnly  bool
+	// Deferrable : only meaningful for serializable read only transaction
+	Deferrable bool
+	// MaxRetries : retries on serialization failure or deadlock,
+	// DefaultTxRetries if 0, negative disables retry
+	MaxRetries int
+	// MinBackoff, MaxBackoff : bounds of exponential backoff with jitter
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// begin returns statement setting transaction characteristics, "" if none
+func (o *TxOptions) begin() string {
+	if o == nil {
+		return ""
+	}
+	var modes []string
+	if o.Isolation != "" {
+		modes = append(modes, "ISOLATION LEVEL "+string(o.Isolation))
+	}
+	if o.ReadOnly {
+		modes = append(modes, "READ ONLY")
+	}
+	if o.Deferrable {
+		modes = append(modes, "DEFERRABLE")
+	}
+	if len(modes) == 0 {
+		return ""
+	}
+	return "SET TRANSACTION " + strings.Join(modes, " ")
+}
+
+// withDefaults fill retry settings
+func (o *TxOptions) withDefaults() TxOptions {
+	var opts TxOptions
+	if o != nil {
+		opts = *o
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = DefaultTxRetries
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultTxMinBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultTxMaxBackoff
+	}
+	opts.MaxBackoff = max(opts.MaxBackoff, opts.MinBackoff)
+	return opts
+}
+
+// backoff returns sleep before given retry (starting from 1): a random
+// duration in [d/2, d] where d = min * 2^(retry-1) capped by max
+func backoff(min, max time.Duration, retry int) time.Duration {
+	d := max
+	if retry < 32 && min<<(retry-1) < max {
+		d = min << (retry - 1)
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+/**************************************************************
+* struct: Tx
+**************************************************************/
+
+// Tx is a transaction, or a savepoint inside one. it embeds *pg.Tx
+// so all go-pg methods are available directly
+type Tx struct {
+	*pg.Tx
+	ctx context.Context
+	ops txOps
+	// savepoints : number of savepoints created in this transaction
+	savepoints *int
+}
+
+// Context returns context carrying this transaction, passing it
+// to WithTx makes a nested transaction using savepoint
+func (tx *Tx) Context() context.Context {
+	return tx.ctx
+}
+
+// txOps is what WithTx needs from a transaction
+type txOps interface {
+	Exec(query interface{}, params ...interface{}) (orm.Result, error)
+	Commit() error
+	Rollback() error
+}
+
+// pgTxOps adapts *pg.Tx to txOps
+type pgTxOps struct{ tx *pg.Tx }
+
+func (o pgTxOps) Exec(query interface{}, params ...interface{}) (orm.Result, error) {
+	return o.tx.Exec(query, params...)
+}
+func (o pgTxOps) Commit() error   { return o.tx.Commit() }
+func (o pgTxOps) Rollback() error { return o.tx.Rollback() }
+
+// txKey is the context key of *Tx
+type txKey struct{}
+
+// TxFromContext returns the transaction carried by ctx, if any
+func TxFromContext(ctx context.Context) (*Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(*Tx)
+	return tx, ok
+}
+
+/**************************************************************
+* WithTx
+**************************************************************/
+
+// WithTx run fn in a transaction of db: commit if fn returns nil,
+// rollback if fn returns error or panics (panic is re-raised).
+//
+// If ctx already carries a transaction (i.e. it is tx.Context()),
+// fn runs in a savepoint of it instead, and opts is ignored.
+//
+// The whole transaction is retried with backoff if it fails with
+// serialization failure (40001) or deadlock (40P01), so fn may run
+// more than once and should not have side effects outside database
+func (db *DB) WithTx(ctx context.Context, opts *TxOptions, fn func(tx *Tx) error) error {
+	return withTx(ctx, db.begin, opts, fn)
+}
+
+// WithTx is like DB.WithTx, using transaction in ctx or Default instance
+func WithTx(ctx context.Context, opts *TxOptions, fn func(tx *Tx) error) error {
+	if _, ok := TxFromContext(ctx); ok {
+		return withTx(ctx, nil, opts, fn)
+	}
+	db, err := Default()
+	if err != nil {
+		return err
+	}
+	return db.WithTx(ctx, opts, fn)
+}
+
+// begin start a go-pg transaction bound to ctx
+func (db *DB) begin(ctx context.Context) (*Tx, error) {
+	tx, err := db.DB.WithContext(ctx).Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, ops: pgTxOps{tx}}, nil
+}
+
+// withTx implements WithTx, begin is only called for outermost transaction
+func withTx(ctx context.Context, begin func(context.Context) (*Tx, error), opts *TxOptions, fn func(tx *Tx) error) error {
+	if parent, ok := TxFromContext(ctx); ok {
+		return savepoint(parent, fn)
+	}
+	options := opts.withDefaults()
+	for retry := 0; ; retry++ {
+		if retry > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff(options.MinBackoff, options.MaxBackoff, retry)):
+			}
+		}
+		err := runTx(ctx, begin, opts.begin(), fn)
+		if err == nil || !IsRetryable(err) || retry >= options.MaxRetries {
+			return err
+		}
+	}
+}
+
+// runTx run fn in a new transaction once
+func runTx(ctx context.Context, begin func(context.Context) (*Tx, error), setup string, fn func(tx *Tx) error) (err error) {
+	tx, err := begin(ctx)
+	if err != nil {
+		return err
+	}
+	tx.ctx = context.WithValue(ctx, txKey{}, tx)
+	tx.savepoints = new(int)
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.ops.Rollback()
+			panic(r)
+		}
+		if err != nil {
+			tx.ops.Rollback()
+		}
+	}()
+	if setup != "" {
+		if _, err := tx.ops.Exec(setup); err != nil {
+			return err
+		}
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.ops.Commit()
+}
+
+// savepoint run fn inside a savepoint of parent: release on success,
+// rollback to it on error or panic, leaving parent usable
+func savepoint(parent *Tx, fn func(tx *Tx) error) (err error) {
+	*parent.savepoints++
+	name := fmt.Sprintf("sp_%d", *parent.savepoints)
+	if _, err := parent.ops.Exec("SAVEPOINT " + name); err != nil {
+		return err
+	}
+	tx := &Tx{Tx: parent.Tx, ops: parent.ops, savepoints: parent.savepoints}
+	tx.ctx = context.WithValue(parent.ctx, txKey{}, tx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			parent.ops.Exec("ROLLBACK TO SAVEPOINT " + name)
+			panic(r)
+		}
+		if err != nil {
+			parent.ops.Exec("ROLLBACK TO SAVEPOINT " + name)
+		}
+	}()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	_, err = parent.ops.Exec("RELEASE SAVEPOINT " + name)
+	return err
+}
+
+/**************************************************************
+* error classification
+**************************************************************/
+
+// SQLSTATE codes worth retrying a transaction
+const (
+	SerializationFailure = "40001"
+	DeadlockDetected     = "40P01"
+)
+
+// SQLState returns SQLSTATE code of a postgres error in err's chain,
+// or "" if err is not from server
+func SQLState(err error) string {
+	var pgErr pg.Error
+	if errors.As(err, &pgErr) {
+		return pgErr.Field('C')
+	}
+	return ""
+}
+
+// IsRetryable reports whether a transaction failed with err could
+// succeed if retried from the beginning
+func IsRetryable(err error) bool {
+	switch SQLState(err) {
+	case SerializationFailure, DeadlockDetected:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+import "github.com/go-pg/pg/orm"
+
+// fakeError is a server error with given SQLSTATE
+type fakeError string
+
+func (e fakeError) Error() string { return "ERROR #" + string(e) }
+func (e fakeError) Field(field byte) string {
+	if field == 'C' {
+		return string(e)
+	}
+	return ""
+}
+func (e fakeError) IntegrityViolation() bool { return false }
+
+// fakeTx records statements, commit fails with commitErrs in turn
+type fakeTx struct {
+	log        *[]string
+	commitErrs *[]error
+}
+
+func (f fakeTx) Exec(query interface{}, params ...interface{}) (orm.Result, error) {
+	*f.log = append(*f.log, query.(string))
+	return nil, nil
+}
+func (f fakeTx) Commit() error {
+	*f.log = append(*f.log, "COMMIT")
+	if len(*f.commitErrs) > 0 {
+		err := (*f.commitErrs)[0]
+		*f.commitErrs = (*f.commitErrs)[1:]
+		return err
+	}
+	return nil
+}
+func (f fakeTx) Rollback() error {
+	*f.log = append(*f.log, "ROLLBACK")
+	return nil
+}
+
+// fakeBegin returns a begin func recording into log
+func fakeBegin(log *[]string, commitErrs ...error) func(context.Context) (*Tx, error) {
+	return func(context.Context) (*Tx, error) {
+		*log = append(*log, "BEGIN")
+		return &Tx{ops: fakeTx{log, &commitErrs}}, nil
+	}
+}
+
+func TestWithTxCommitRollback(t *testing.T) {
+	var log []string
+	opts := &TxOptions{Isolation: Serializable, ReadOnly: true, Deferrable: true}
+	err := withTx(context.Background(), fakeBegin(&log), opts, func(tx *Tx) error {
+		tx.ops.Exec("SELECT 1")
+		return nil
+	})
+	want := []string{"BEGIN", "SET TRANSACTION ISOLATION LEVEL SERIALIZABLE READ ONLY DEFERRABLE", "SELECT 1", "COMMIT"}
+	if err != nil || !reflect.DeepEqual(log, want) {
+		t.Fatalf("Inconsistent statements: expected: %v, actual: %v (%v)", want, log, err)
+	}
+
+	log = nil
+	boom := errors.New("boom")
+	if err := withTx(context.Background(), fakeBegin(&log), nil, func(tx *Tx) error { return boom }); err != boom {
+		t.Fatalf("error not returned: %v", err)
+	}
+	if want := []string{"BEGIN", "ROLLBACK"}; !reflect.DeepEqual(log, want) {
+		t.Fatalf("Inconsistent statements: expected: %v, actual: %v", want, log)
+	}
+
+	log = nil
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Fatalf("panic not re-raised: %v", r)
+			}
+		}()
+		withTx(context.Background(), fakeBegin(&log), nil, func(tx *Tx) error { panic("boom") })
+	}()
+	if want := []string{"BEGIN", "ROLLBACK"}; !reflect.DeepEqual(log, want) {
+		t.Fatalf("Inconsistent statements: expected: %v, actual: %v", want, log)
+	}
+}
+
+func TestWithTxSavepoint(t *testing.T) {
+	var log []string
+	err := withTx(context.Background(), fakeBegin(&log), nil, func(tx *Tx) error {
+		if _, ok := TxFromContext(tx.Context()); !ok {
+			t.Fatal("tx not found in its context")
+		}
+		withTx(tx.Context(), nil, nil, func(tx *Tx) error {
+			return withTx(tx.Context(), nil, nil, func(*Tx) error { return nil })
+		})
+		err := withTx(tx.Context(), nil, nil, func(*Tx) error { return errors.New("inner") })
+		if err == nil {
+			t.Fatal("inner error swallowed")
+		}
+		return nil
+	})
+	want := []string{"BEGIN", "SAVEPOINT sp_1", "SAVEPOINT sp_2", "RELEASE SAVEPOINT sp_2",
+		"RELEASE SAVEPOINT sp_1", "SAVEPOINT sp_3", "ROLLBACK TO SAVEPOINT sp_3", "COMMIT"}
+	if err != nil || !reflect.DeepEqual(log, want) {
+		t.Fatalf("Inconsistent statements: expected: %v, actual: %v (%v)", want, log, err)
+	}
+}
+
+func TestWithTxRetry(t *testing.T) {
+	var log []string
+	opts := &TxOptions{MinBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	runs := 0
+	begin := fakeBegin(&log, fakeError(SerializationFailure), fmt.Errorf("commit: %w", fakeError(DeadlockDetected)))
+	if err := withTx(context.Background(), begin, opts, func(*Tx) error { runs++; return nil }); err != nil || runs != 3 {
+		t.Fatalf("Inconsistent runs: expected: %d, actual: %d (%v)", 3, runs, err)
+	}
+
+	// non-retryable errors and exhausted retries are returned
+	runs = 0
+	uniqueViolation := fakeError("23505")
+	if err := withTx(context.Background(), fakeBegin(&log), opts, func(*Tx) error { runs++; return uniqueViolation }); err != uniqueViolation || runs != 1 {
+		t.Fatalf("Inconsistent runs: expected: %d, actual: %d (%v)", 1, runs, err)
+	}
+	runs = 0
+	opts.MaxRetries = 2
+	if err := withTx(context.Background(), fakeBegin(&log), opts, func(*Tx) error {
+		runs++
+		return fakeError(SerializationFailure)
+	}); !IsRetryable(err) || runs != 3 {
+		t.Fatalf("Inconsistent runs: expected: %d, actual: %d (%v)", 3, runs, err)
+	}
+	runs = 0
+	opts.MaxRetries = -1
+	withTx(context.Background(), fakeBegin(&log), opts, func(*Tx) error { runs++; return fakeError(SerializationFailure) })
+	if runs != 1 {
+		t.Fatalf("Inconsistent runs: expected: %d, actual: %d", 1, runs)
+	}
+
+	// context cancellation stops retrying
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	opts.MaxRetries = 0
+	if err := withTx(ctx, fakeBegin(&log), opts, func(*Tx) error { return fakeError(DeadlockDetected) }); err != context.Canceled {
+		t.Fatalf("Inconsistent error: expected: %v, actual: %v", context.Canceled, err)
+	}
+}
+
+func TestTxBackoff(t *testing.T) {
+	opts := (&TxOptions{MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}).withDefaults()
+	table := []struct {
+		retry int
+		max   time.Duration
+	}{
+		{1, 10 * time.Millisecond}, {2, 20 * time.Millisecond}, {3, 40 * time.Millisecond},
+		{4, 50 * time.Millisecond}, {100, 50 * time.Millisecond},
+	}
+	for _, tt := range table {
+		for i := 0; i < 100; i++ {
+			if d := backoff(opts.MinBackoff, opts.MaxBackoff, tt.retry); d < tt.max/2 || d > tt.max {
+				t.Fatalf("Inconsistent backoff of retry %d: expected: [%v, %v], actual: %v", tt.retry, tt.max/2, tt.max, d)
+			}
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package redis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+import "github.com/go-redis/redis"
+
+// DefaultURL is used when neither url nor ENV:REDIS is given
+const DefaultURL = "redis://localhost:6379"
+
+// Default ports of redis server and sentinel
+const (
+	DefaultPort         = 6379
+	DefaultSentinelPort = 26379
+)
+
+// Mode tells how servers in Config.Addrs are used
+type Mode int
+
+// Deployment modes
+const (
+	// Standalone : a single server, only the first addr is used
+	Standalone Mode = iota
+	// Sentinel : addrs are sentinels monitoring MasterName, the client
+	// follows master on failover
+	Sentinel
+	// Cluster : addrs are seed nodes of a redis cluster
+	Cluster
+)
+
+// String implements fmt.Stringer
+func (m Mode) String() string {
+	switch m {
+	case Sentinel:
+		return "sentinel"
+	case Cluster:
+		return "cluster"
+	}
+	return "standalone"
+}
+
+// Config describes how to connect to redis
+type Config struct {
+	// Mode : Standalone (default), Sentinel or Cluster
+	Mode Mode
+	// Addrs : host:port of server, sentinels or cluster seed nodes,
+	// localhost with default port of mode if empty
+	Addrs []string
+	// MasterName : master monitored by sentinels, required by Sentinel mode
+	MasterName string
+	// Password : AUTH password, empty means no auth
+	Password string
+	// DB : database number, must be 0 in Cluster mode
+	DB int
+
+	// TLS : connect with tls, server certificate is verified against
+	// TLSCA or system pool unless TLSSkipVerify
+	TLS           bool
+	TLSSkipVerify bool
+	TLSCA         string
+	// TLSCert, TLSKey : client certificate & key files, optional
+	TLSCert string
+	TLSKey  string
+
+	// DialTimeout : timeout of establishing connection, go-redis default is 5s
+	DialTimeout time.Duration
+	// ReadTimeout, WriteTimeout : socket timeouts, go-redis default is 3s
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// PoolSize : max connections per node, go-redis default is 10 per CPU
+	PoolSize int
+	// MinIdleConns : idle connections kept open in advance
+	MinIdleConns int
+	// MaxConnAge : connections older than this are closed, 0 means never
+	MaxConnAge time.Duration
+	// PoolTimeout : max wait for a free connection, go-redis default is ReadTimeout + 1s
+	PoolTimeout time.Duration
+	// IdleTimeout : idle connections older than this are closed, go-redis default is 5m
+	IdleTimeout time.Duration
+	// IdleCheckFrequency : how often idle connections are reaped
+	IdleCheckFrequency time.Duration
+	// MaxRetries : retries of failed command on network error, 0 means no retry
+	MaxRetries int
+	// MinRetryBackoff, MaxRetryBackoff : bounds of exponential backoff between retries
+	MinRetryBackoff time.Duration
+	MaxRetryBackoff time.Duration
+
+	// ReadOnly : Cluster mode only, send read commands to replicas
+	ReadOnly bool
+	// RouteByLatency, RouteRandomly : Cluster mode only, route read
+	// commands to the closest or a random node, implies ReadOnly
+	RouteByLatency bool
+	RouteRandomly  bool
+}
+
+// ParseURL parse redis url in any form:
+//
+//	redis://:password@host:6379/0?dial_timeout=3s&pool_size=20
+//	rediss://host:6380/0?tls_ca=/etc/redis/ca.crt
+//	redis+sentinel://:password@s1:26379,s2:26379/mymaster/0
+//	redis+cluster://:password@n1:7000,n2:7001?route_by_latency=true
+//
+// rediss and rediss+ schemes enable tls. Only the password of userinfo
+// is used. returns *URLError telling which part is invalid
+func ParseURL(redisURL string) (*Config, error) {
+	invalid := func(part, value string, err error) error {
+		return &URLError{URL: redact(redisURL), Part: part, Value: value, Err: err}
+	}
+	cfg := &Config{}
+	i := strings.Index(redisURL, "://")
+	if i < 0 {
+		return nil, invalid("scheme", redisURL, ErrInvalidScheme)
+	}
+	scheme, rest := redisURL[:i], redisURL[i+3:]
+	switch scheme {
+	case "redis", "rediss":
+	case "redis+sentinel", "rediss+sentinel":
+		cfg.Mode = Sentinel
+	case "redis+cluster", "rediss+cluster":
+		cfg.Mode = Cluster
+	default:
+		return nil, invalid("scheme", scheme, ErrInvalidScheme)
+	}
+	cfg.TLS = strings.HasPrefix(scheme, "rediss")
+
+	// query
+	var query url.Values
+	if i := strings.IndexByte(rest, '?'); i >= 0 {
+		var err error
+		if query, err = url.ParseQuery(rest[i+1:]); err != nil {
+			return nil, invalid("query", redact(rest[i+1:]), err)
+		}
+		rest = rest[:i]
+	}
+
+	// path: [/master][/db]
+	var path []string
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		if p := strings.Trim(rest[i+1:], "/"); p != "" {
+			path = strings.Split(p, "/")
+		}
+		rest = rest[:i]
+	}
+	if cfg.Mode == Sentinel {
+		if len(path) == 0 {
+			return nil, invalid("master", "", ErrNoMaster)
+		}
+		cfg.MasterName, path = path[0], path[1:]
+	}
+	switch {
+	case len(path) > 1:
+		return nil, invalid("path", strings.Join(path, "/"), ErrInvalidParam)
+	case len(path) == 1:
+		db, err := strconv.Atoi(path[0])
+		if err != nil || db < 0 || cfg.Mode == Cluster && db != 0 {
+			return nil, invalid("db", path[0], ErrInvalidParam)
+		}
+		cfg.DB = db
+	}
+
+	// password
+	if i := strings.LastIndexByte(rest, '@'); i >= 0 {
+		if _, password, ok := strings.Cut(rest[:i], ":"); ok {
+			var err error
+			if cfg.Password, err = url.PathUnescape(password); err != nil {
+				return nil, invalid("password", "xxxxx", err)
+			}
+		}
+		rest = rest[i+1:]
+	}
+
+	// addrs
+	if rest != "" {
+		for _, addr := range strings.Split(rest, ",") {
+			addr, err := withPort(addr, cfg.Mode)
+			if err != nil {
+				return nil, invalid("addr", addr, err)
+			}
+			cfg.Addrs = append(cfg.Addrs, addr)
+		}
+	}
+
+	for key, values := range query {
+		if err := cfg.set(key, values[len(values)-1]); err != nil {
+			return nil, invalid(key, values[len(values)-1], err)
+		}
+	}
+	return cfg, nil
+}
+
+// set apply a query parameter
+func (cfg *Config) set(key, value string) (err error) {
+	switch key {
+	case "db":
+		if cfg.DB, err = parseCount(value); err == nil && cfg.Mode == Cluster && cfg.DB != 0 {
+			err = ErrInvalidParam
+		}
+	case "password":
+		cfg.Password = value
+	case "master_name":
+		cfg.MasterName = value
+	case "tls_skip_verify":
+		cfg.TLSSkipVerify, err = parseBool(value)
+	case "tls_ca":
+		cfg.TLSCA = value
+	case "tls_cert":
+		cfg.TLSCert = value
+	case "tls_key":
+		cfg.TLSKey = value
+	case "dial_timeout":
+		cfg.DialTimeout, err = parseDuration(value)
+	case "read_timeout":
+		cfg.ReadTimeout, err = parseDuration(value)
+	case "write_timeout":
+		cfg.WriteTimeout, err = parseDuration(value)
+	case "pool_size":
+		cfg.PoolSize, err = parseCount(value)
+	case "min_idle_conns":
+		cfg.MinIdleConns, err = parseCount(value)
+	case "max_conn_age":
+		cfg.MaxConnAge, err = parseDuration(value)
+	case "pool_timeout":
+		cfg.PoolTimeout, err = parseDuration(value)
+	case "idle_timeout":
+		cfg.IdleTimeout, err = parseDuration(value)
+	case "idle_check_frequency":
+		cfg.IdleCheckFrequency, err = parseDuration(value)
+	case "max_retries":
+		cfg.MaxRetries, err = parseCount(value)
+	case "min_retry_backoff":
+		cfg.MinRetryBackoff, err = parseDuration(value)
+	case "max_retry_backoff":
+		cfg.MaxRetryBackoff, err = parseDuration(value)
+	case "read_only":
+		cfg.ReadOnly, err = parseBool(value)
+	case "route_by_latency":
+		cfg.RouteByLatency, err = parseBool(value)
+	case "route_randomly":
+		cfg.RouteRandomly, err = parseBool(value)
+	default:
+		return ErrUnknownParam
+	}
+	return err
+}
+
+// withPort validate host[:port], adding default port of mode if absent
+func withPort(addr string, mode Mode) (string, error) {
+	port := DefaultPort
+	if mode == Sentinel {
+		port = DefaultSentinelPort
+	}
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		// no port: bare host or [ipv6]
+		host = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+		if host == "" || strings.ContainsAny(host, "[]/ ") {
+			return addr, ErrInvalidAddr
+		}
+		return net.JoinHostPort(host, strconv.Itoa(port)), nil
+	}
+	if port, err = strconv.Atoi(p); err != nil || port <= 0 || port > 65535 || host == "" {
+		return addr, ErrInvalidAddr
+	}
+	return net.JoinHostPort(host, p), nil
+}
+
+// parseCount parse non-negative integer
+func parseCount(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, ErrInvalidParam
+	}
+	return n, nil
+}
+
+// parseDuration parse go duration like 3s, or bare integer seconds
+func parseDuration(s string) (time.Duration, error) {
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, ErrInvalidParam
+	}
+	return d, nil
+}
+
+// parseBool parse boolean like strconv.ParseBool
+func parseBool(s string) (bool, error) {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, ErrInvalidParam
+	}
+	return b, nil
+}
+
+var (
+	redactURL   = regexp.MustCompile(`^([a-z+]+://[^:/@]*:)[^@/]*@`)
+	redactQuery = regexp.MustCompile(`((?:^|[?&])password=)[^&]*`)
+)
+
+// redact hides password in url or its query for error messages
+func redact(s string) string {
+	s = redactURL.ReplaceAllString(s, "${1}xxxxx@")
+	return redactQuery.ReplaceAllString(s, "${1}xxxxx")
+}
+
+// withDefaults returns a copy of config with addrs filled and validated
+func (cfg Config) withDefaults() (Config, error) {
+	invalid := func(part, value string, err error) error {
+		return &URLError{URL: cfg.Addr(), Part: part, Value: value, Err: err}
+	}
+	if len(cfg.Addrs) == 0 {
+		cfg.Addrs = []string{"localhost"}
+	}
+	addrs := make([]string, len(cfg.Addrs))
+	for i, addr := range cfg.Addrs {
+		var err error
+		if addrs[i], err = withPort(addr, cfg.Mode); err != nil {
+			return cfg, invalid("addr", addr, err)
+		}
+	}
+	cfg.Addrs = addrs
+	switch {
+	case cfg.Mode == Sentinel && cfg.MasterName == "":
+		return cfg, invalid("master", "", ErrNoMaster)
+	case cfg.Mode == Cluster && cfg.DB != 0, cfg.DB < 0:
+		return cfg, invalid("db", strconv.Itoa(cfg.DB), ErrInvalidParam)
+	case cfg.Mode < Standalone || cfg.Mode > Cluster:
+		return cfg, invalid("mode", strconv.Itoa(int(cfg.Mode)), ErrInvalidParam)
+	}
+	return cfg, nil
+}
+
+// Addr returns comma separated addresses
+func (cfg Config) Addr() string {
+	return strings.Join(cfg.Addrs, ",")
+}
+
+// tlsConfig build tls config if TLS is enabled
+func (cfg Config) tlsConfig() (*tls.Config, error) {
+	if !cfg.TLS {
+		return nil, nil
+	}
+	config := &tls.Config{InsecureSkipVerify: cfg.TLSSkipVerify, MinVersion: tls.VersionTLS12}
+	if cfg.Mode == Standalone {
+		config.ServerName, _, _ = net.SplitHostPort(cfg.Addrs[0])
+	}
+	if cfg.TLSCA != "" {
+		pem, err := os.ReadFile(cfg.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("redis: tls_ca: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("redis: tls_ca %s: no certificate found", cfg.TLSCA)
+		}
+	}
+	if cfg.TLSCert != "" || cfg.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("redis: tls_cert: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// client create go-redis client of mode, defaults should be filled
+func (cfg Config) client() (redis.UniversalClient, error) {
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.Mode {
+	case Sentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:         cfg.MasterName,
+			SentinelAddrs:      cfg.Addrs,
+			Password:           cfg.Password,
+			DB:                 cfg.DB,
+			TLSConfig:          tlsConfig,
+			DialTimeout:        cfg.DialTimeout,
+			ReadTimeout:        cfg.ReadTimeout,
+			WriteTimeout:       cfg.WriteTimeout,
+			PoolSize:           cfg.PoolSize,
+			MinIdleConns:       cfg.MinIdleConns,
+			MaxConnAge:         cfg.MaxConnAge,
+			PoolTimeout:        cfg.PoolTimeout,
+			IdleTimeout:        cfg.IdleTimeout,
+			IdleCheckFrequency: cfg.IdleCheckFrequency,
+			MaxRetries:         cfg.MaxRetries,
+			MinRetryBackoff:    cfg.MinRetryBackoff,
+			MaxRetryBackoff:    cfg.MaxRetryBackoff,
+		}), nil
+	case Cluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:              cfg.Addrs,
+			ReadOnly:           cfg.ReadOnly,
+			RouteByLatency:     cfg.RouteByLatency,
+			RouteRandomly:      cfg.RouteRandomly,
+			Password:           cfg.Password,
+			TLSConfig:          tlsConfig,
+			DialTimeout:        cfg.DialTimeout,
+			ReadTimeout:        cfg.ReadTimeout,
+			WriteTimeout:       cfg.WriteTimeout,
+			PoolSize:           cfg.PoolSize,
+			MinIdleConns:       cfg.MinIdleConns,
+			MaxConnAge:         cfg.MaxConnAge,
+			PoolTimeout:        cfg.PoolTimeout,
+			IdleTimeout:        cfg.IdleTimeout,
+			IdleCheckFrequency: cfg.IdleCheckFrequency,
+			MaxRetries:         cfg.MaxRetries,
+			MinRetryBackoff:    cfg.MinRetryBackoff,
+			MaxRetryBackoff:    cfg.MaxRetryBackoff,
+		}), nil
+	}
+	return redis.NewClient(&redis.Options{
+		Addr:               cfg.Addrs[0],
+		Password:           cfg.Password,
+		DB:                 cfg.DB,
+		TLSConfig:          tlsConfig,
+		DialTimeout:        cfg.DialTimeout,
+		ReadTimeout:        cfg.ReadTimeout,
+		WriteTimeout:       cfg.WriteTimeout,
+		PoolSize:           cfg.PoolSize,
+		MinIdleConns:       cfg.MinIdleConns,
+		MaxConnAge:         cfg.MaxConnAge,
+		PoolTimeout:        cfg.PoolTimeout,
+		IdleTimeout:        cfg.IdleTimeout,
+		IdleCheckFrequency: cfg.IdleCheckFrequency,
+		MaxRetries:         cfg.MaxRetries,
+		MinRetryBackoff:    cfg.MinRetryBackoff,
+		MaxRetryBackoff:    cfg.MaxRetryBackoff,
+	}), nil
+}
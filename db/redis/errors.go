@@ -0,0 +1,54 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error returned by package redis
+var (
+	// ErrInvalidScheme occurs when url scheme is not one of redis, rediss,
+	// redis+sentinel, rediss+sentinel, redis+cluster or rediss+cluster
+	ErrInvalidScheme = errors.New("scheme should be redis, rediss, redis+sentinel or redis+cluster")
+
+	// ErrInvalidAddr occurs when an address is not host:port
+	ErrInvalidAddr = errors.New("address should be host:port")
+
+	// ErrUnknownParam occurs when url contains unsupported query parameter
+	ErrUnknownParam = errors.New("unknown parameter")
+
+	// ErrInvalidParam occurs when parameter value is malformed or unsupported
+	ErrInvalidParam = errors.New("invalid parameter value")
+
+	// ErrNoMaster occurs when sentinel url lacks master name
+	ErrNoMaster = errors.New("sentinel master name is required")
+
+	// ErrLockHeld occurs when TryLock finds lock held by another owner
+	ErrLockHeld = errors.New("lock is held by another owner")
+
+	// ErrLockNotHeld occurs when unlocking a lock which expired,
+	// or was released already
+	ErrLockNotHeld = errors.New("lock is not held")
+)
+
+// URLError describes which part of a redis url is invalid
+type URLError struct {
+	// URL : the url with password redacted
+	URL string
+	// Part : scheme, addr, db, master or parameter name
+	Part string
+	// Value : the offending value
+	Value string
+	// Err : underlying reason
+	Err error
+}
+
+// Error implements error
+func (e *URLError) Error() string {
+	return fmt.Sprintf("redis: invalid %s %q in %q: %v", e.Part, e.Value, e.URL, e.Err)
+}
+
+// Unwrap returns underlying reason
+func (e *URLError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,84 @@
+package redis
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+import "github.com/go-redis/redis"
+
+// Health is a snapshot of server reachability and pool saturation,
+// suitable for readiness probes and dashboards
+type Health struct {
+	// Mode : standalone, sentinel or cluster
+	Mode string `json:"mode"`
+	// Latency : round trip time of the probe command
+	Latency time.Duration `json:"latency"`
+	// ServerVersion : e.g. 4.0.9, empty if server is unreachable
+	ServerVersion string `json:"server_version"`
+	// Role : master or slave, of the server probed
+	Role string `json:"role"`
+	// PoolSize : max connections allowed in pool (per node in cluster)
+	PoolSize int `json:"pool_size"`
+	// TotalConns, IdleConns : connections in pool, total - idle are in use
+	TotalConns uint32 `json:"total_conns"`
+	IdleConns  uint32 `json:"idle_conns"`
+	// StaleConns : connections closed by idle timeout or max age so far
+	StaleConns uint32 `json:"stale_conns"`
+	// Hits, Misses : times an idle connection was / wasn't available
+	Hits   uint32 `json:"hits"`
+	Misses uint32 `json:"misses"`
+	// Timeouts : times waiting for a free connection exceeded PoolTimeout
+	Timeouts uint32 `json:"timeouts"`
+}
+
+// Saturation returns ratio of connections in use to pool size
+func (h *Health) Saturation() float64 {
+	if h.PoolSize == 0 {
+		return 0
+	}
+	return float64(h.TotalConns-h.IdleConns) / float64(h.PoolSize)
+}
+
+// Health query server info as a ping and collect pool stats.
+// the returned Health is always filled with pool stats,
+// error is non-nil if server is unreachable
+func (c *Client) Health(ctx context.Context) (*Health, error) {
+	h := &Health{Mode: c.config.Mode.String(), PoolSize: c.config.PoolSize}
+	start := time.Now()
+	var info string
+	err := do(ctx, func() (err error) {
+		// go-redis sends only the first section given, default sections
+		// include both server and replication
+		info, err = c.UniversalClient.Info().Result()
+		return err
+	})
+	h.Latency = time.Since(start)
+	h.ServerVersion, h.Role = infoField(info, "redis_version"), infoField(info, "role")
+
+	var stats *redis.PoolStats
+	switch client := c.UniversalClient.(type) {
+	case *redis.Client:
+		stats = client.PoolStats()
+		h.PoolSize = client.Options().PoolSize
+	case *redis.ClusterClient:
+		stats = client.PoolStats()
+		h.PoolSize = client.Options().PoolSize
+	}
+	if stats != nil {
+		h.TotalConns, h.IdleConns, h.StaleConns = stats.TotalConns, stats.IdleConns, stats.StaleConns
+		h.Hits, h.Misses, h.Timeouts = stats.Hits, stats.Misses, stats.Timeouts
+	}
+	return h, err
+}
+
+// infoField returns value of field in INFO output
+func infoField(info, field string) string {
+	for _, line := range strings.Split(info, "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), field+":"); ok {
+			return value
+		}
+	}
+	return ""
+}
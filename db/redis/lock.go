@@ -0,0 +1,230 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	mrand "math/rand/v2"
+	"sync"
+	"time"
+)
+
+import "github.com/go-redis/redis"
+
+// Lock defaults
+const (
+	DefaultLockTTL   = 10 * time.Second
+	DefaultLockRetry = 100 * time.Millisecond
+)
+
+// LockOptions tunes a lock, zero values mean defaults
+type LockOptions struct {
+	// TTL : lease of lock, extended every TTL/3 while held. A crashed
+	// owner blocks others for at most TTL. DefaultLockTTL if 0
+	TTL time.Duration
+	// RetryInterval : average wait between acquire attempts, jittered.
+	// DefaultLockRetry if 0
+	RetryInterval time.Duration
+}
+
+// lock scripts, KEYS[1] is lock key, KEYS[2] is fencing counter key
+var (
+	// acquireScript returns new fencing token, or 0 if held by others
+	acquireScript = redis.NewScript(`
+if redis.call('set', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+  return redis.call('incr', KEYS[2])
+end
+return 0`)
+
+	// extendScript returns 1 if lease is extended, 0 if not owner
+	extendScript = redis.NewScript(`
+if redis.call('get', KEYS[1]) == ARGV[1] then
+  return redis.call('pexpire', KEYS[1], ARGV[2])
+end
+return 0`)
+
+	// releaseScript returns 1 if released, 0 if not owner
+	releaseScript = redis.NewScript(`
+if redis.call('get', KEYS[1]) == ARGV[1] then
+  return redis.call('del', KEYS[1])
+end
+return 0`)
+)
+
+/**************************************************************
+* struct: Lock
+**************************************************************/
+
+// Lock is a held distributed lock, its lease is extended automatically
+// until Unlock or lost.
+//
+// Mutual exclusion relies on lease timing, a paused owner (GC, network
+// partition) may still act after lease expired and another owner took
+// over. Pass Fence to the protected resource and let it reject tokens
+// smaller than the largest seen, so stale owners can't do harm.
+//
+// Lock key and fencing counter key <name>:fence must be in the same
+// slot in Cluster mode, use a hash tag like {job}:lock as name
+type Lock struct {
+	name  string
+	token string
+	fence int64
+	ttl   time.Duration
+	run   func(script *redis.Script, keys []string, args ...interface{}) *redis.Cmd
+
+	lost     chan struct{}
+	lostOnce sync.Once
+	stop     context.CancelFunc
+	done     chan struct{}
+}
+
+// Lock acquire lock of name, retrying until ctx is done
+func (c *Client) Lock(ctx context.Context, name string, opts *LockOptions) (*Lock, error) {
+	opts = opts.withDefaults()
+	for {
+		l, err := c.TryLock(ctx, name, opts)
+		if !errors.Is(err, ErrLockHeld) {
+			return l, err
+		}
+		// jitter in [0.5, 1.5) of interval avoids contenders in lockstep
+		wait := opts.RetryInterval/2 + mrand.N(opts.RetryInterval)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("redis: lock %s: %w", name, ctx.Err())
+		case <-time.After(wait):
+		}
+	}
+}
+
+// TryLock acquire lock of name once, returns ErrLockHeld if held by others
+func (c *Client) TryLock(ctx context.Context, name string, opts *LockOptions) (*Lock, error) {
+	opts = opts.withDefaults()
+	l := &Lock{
+		name:  name,
+		token: newToken(),
+		ttl:   opts.TTL,
+		lost:  make(chan struct{}),
+		done:  make(chan struct{}),
+		run: func(script *redis.Script, keys []string, args ...interface{}) *redis.Cmd {
+			return script.Run(c.UniversalClient, keys, args...)
+		},
+	}
+	err := do(ctx, func() (err error) {
+		l.fence, err = l.run(acquireScript, l.keys(), l.token, milliseconds(l.ttl)).Int64()
+		return err
+	})
+	if err != nil {
+		// the attempt may have succeeded anyway, lease expires by itself
+		return nil, fmt.Errorf("redis: lock %s: %w", name, err)
+	}
+	if l.fence == 0 {
+		return nil, fmt.Errorf("redis: lock %s: %w", name, ErrLockHeld)
+	}
+	keepCtx, stop := context.WithCancel(context.Background())
+	l.stop = stop
+	go l.keep(keepCtx)
+	return l, nil
+}
+
+// Name returns lock name
+func (l *Lock) Name() string {
+	return l.name
+}
+
+// Fence returns fencing token of this acquisition, it increases
+// monotonically across acquisitions of the same name
+func (l *Lock) Fence() int64 {
+	return l.fence
+}
+
+// Lost is closed when lease could not be extended in time or the
+// lock was taken over, the owner should stop working on the resource.
+// It is closed by Unlock as well
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Unlock stop extending lease and release lock if still owned,
+// returns ErrLockNotHeld if lock was lost or released already
+func (l *Lock) Unlock(ctx context.Context) error {
+	l.stop()
+	<-l.done
+	var released int64
+	err := do(ctx, func() (err error) {
+		released, err = l.run(releaseScript, l.keys()[:1], l.token).Int64()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("redis: unlock %s: %w", l.name, err)
+	}
+	l.markLost()
+	if released == 0 {
+		return fmt.Errorf("redis: unlock %s: %w", l.name, ErrLockNotHeld)
+	}
+	return nil
+}
+
+// keep extend lease every ttl/3 until stopped or lost. Failed
+// extensions are retried until the current lease expires
+func (l *Lock) keep(ctx context.Context) {
+	defer close(l.done)
+	expire := time.Now().Add(l.ttl)
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		start := time.Now()
+		extended, err := l.run(extendScript, l.keys()[:1], l.token, milliseconds(l.ttl)).Int64()
+		switch {
+		case err == nil && extended == 1:
+			// lease counts from when the request was sent
+			expire = start.Add(l.ttl)
+		case err == nil, time.Now().After(expire):
+			l.markLost()
+			return
+		}
+	}
+}
+
+// markLost close lost channel once
+func (l *Lock) markLost() {
+	l.lostOnce.Do(func() { close(l.lost) })
+}
+
+// keys returns lock key and fencing counter key
+func (l *Lock) keys() []string {
+	return []string{l.name, l.name + ":fence"}
+}
+
+// withDefaults returns a copy of options with zero fields filled
+func (opts *LockOptions) withDefaults() *LockOptions {
+	res := LockOptions{}
+	if opts != nil {
+		res = *opts
+	}
+	if res.TTL <= 0 {
+		res.TTL = DefaultLockTTL
+	}
+	if res.RetryInterval <= 0 {
+		res.RetryInterval = DefaultLockRetry
+	}
+	return &res
+}
+
+// newToken returns a random owner token
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// milliseconds returns d in whole milliseconds, at least 1
+func milliseconds(d time.Duration) int64 {
+	return max(int64(d/time.Millisecond), 1)
+}
@@ -0,0 +1,261 @@
+package redis
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+import "github.com/go-redis/redis"
+
+// standIn is an in-process redis, only scripting of lock scripts is
+// implemented, other commands panic on the nil embedded client
+type standIn struct {
+	redis.UniversalClient
+
+	mu      sync.Mutex
+	values  map[string]string
+	expires map[string]time.Time
+	scripts map[string]string // sha1 -> source
+	down    bool
+}
+
+func newStandIn() *standIn {
+	return &standIn{values: map[string]string{}, expires: map[string]time.Time{}, scripts: map[string]string{}}
+}
+
+// client returns a Client backed by stand-in
+func (s *standIn) client() *Client {
+	return &Client{UniversalClient: s}
+}
+
+// get returns unexpired value of key, caller holds mu
+func (s *standIn) get(key string) (string, bool) {
+	if at, ok := s.expires[key]; ok && !time.Now().Before(at) {
+		delete(s.values, key)
+		delete(s.expires, key)
+	}
+	v, ok := s.values[key]
+	return v, ok
+}
+
+// set put value of key, overwriting others' token to simulate takeover
+func (s *standIn) set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+}
+
+func (s *standIn) setDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+}
+
+func (s *standIn) Eval(script string, keys []string, args ...interface{}) *redis.Cmd {
+	sum := sha1.Sum([]byte(script))
+	sha := hex.EncodeToString(sum[:])
+	s.mu.Lock()
+	s.scripts[sha] = script
+	s.mu.Unlock()
+	return s.EvalSha(sha, keys, args...)
+}
+
+func (s *standIn) EvalSha(sha string, keys []string, args ...interface{}) *redis.Cmd {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return redis.NewCmdResult(nil, errors.New("dial tcp: connection refused"))
+	}
+	if _, ok := s.scripts[sha]; !ok {
+		return redis.NewCmdResult(nil, errors.New("NOSCRIPT No matching script. Please use EVAL."))
+	}
+	token := args[0].(string)
+	var ttl time.Duration
+	if len(args) > 1 {
+		ttl = time.Duration(args[1].(int64)) * time.Millisecond
+	}
+	current, exists := s.get(keys[0])
+	switch sha {
+	case acquireScript.Hash():
+		if exists {
+			return redis.NewCmdResult(int64(0), nil)
+		}
+		s.values[keys[0]], s.expires[keys[0]] = token, time.Now().Add(ttl)
+		fence, _ := strconv.ParseInt(s.values[keys[1]], 10, 64)
+		s.values[keys[1]] = strconv.FormatInt(fence+1, 10)
+		return redis.NewCmdResult(fence+1, nil)
+	case extendScript.Hash():
+		if !exists || current != token {
+			return redis.NewCmdResult(int64(0), nil)
+		}
+		s.expires[keys[0]] = time.Now().Add(ttl)
+		return redis.NewCmdResult(int64(1), nil)
+	case releaseScript.Hash():
+		if !exists || current != token {
+			return redis.NewCmdResult(int64(0), nil)
+		}
+		delete(s.values, keys[0])
+		delete(s.expires, keys[0])
+		return redis.NewCmdResult(int64(1), nil)
+	}
+	return redis.NewCmdResult(nil, errors.New("ERR unknown script"))
+}
+
+func (s *standIn) ScriptExists(hashes ...string) *redis.BoolSliceCmd {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]bool, len(hashes))
+	for i, h := range hashes {
+		_, res[i] = s.scripts[h]
+	}
+	return redis.NewBoolSliceResult(res, nil)
+}
+
+func (s *standIn) ScriptLoad(script string) *redis.StringCmd {
+	sum := sha1.Sum([]byte(script))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts[hex.EncodeToString(sum[:])] = script
+	return redis.NewStringResult(hex.EncodeToString(sum[:]), nil)
+}
+
+func TestTryLock(t *testing.T) {
+	c := newStandIn().client()
+	ctx := context.Background()
+
+	a, err := c.TryLock(ctx, "job", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Fence() != 1 || a.Name() != "job" {
+		t.Fatalf("Inconsistent lock: fence %d, name %s", a.Fence(), a.Name())
+	}
+	if _, err := c.TryLock(ctx, "job", nil); !errors.Is(err, ErrLockHeld) {
+		t.Fatalf("TryLock of held lock => %v, want %v", err, ErrLockHeld)
+	}
+	if other, err := c.TryLock(ctx, "other", nil); err != nil || other.Fence() != 1 {
+		t.Fatalf("TryLock of other name => %v", err)
+	}
+	if err := a.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Unlock(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("second Unlock => %v, want %v", err, ErrLockNotHeld)
+	}
+	select {
+	case <-a.Lost():
+	default:
+		t.Fatal("Lost not closed by Unlock")
+	}
+
+	// fencing token increases across acquisitions
+	b, err := c.TryLock(ctx, "job", nil)
+	if err != nil || b.Fence() != 2 {
+		t.Fatalf("Inconsistent fence: expected: %d, actual: %d (%v)", 2, b.Fence(), err)
+	}
+	b.Unlock(ctx)
+}
+
+func TestLockRetry(t *testing.T) {
+	c := newStandIn().client()
+	ctx := context.Background()
+	opts := &LockOptions{RetryInterval: 5 * time.Millisecond}
+	a, err := c.Lock(ctx, "job", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// waiting for a held lock gives up with ctx
+	short, cancel := context.WithTimeout(ctx, 30*time.Millisecond)
+	defer cancel()
+	if _, err := c.Lock(short, "job", opts); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Lock of held lock => %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	acquired := make(chan *Lock)
+	go func() {
+		b, err := c.Lock(ctx, "job", opts)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- b
+	}()
+	time.Sleep(20 * time.Millisecond)
+	a.Unlock(ctx)
+	select {
+	case b := <-acquired:
+		if b == nil || b.Fence() <= a.Fence() {
+			t.Fatalf("Inconsistent fence after retry: %v", b)
+		}
+		b.Unlock(ctx)
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiter never acquired released lock")
+	}
+}
+
+func TestLockExtend(t *testing.T) {
+	s := newStandIn()
+	c := s.client()
+	ctx := context.Background()
+	opts := &LockOptions{TTL: 90 * time.Millisecond}
+
+	// lease is extended beyond TTL while held
+	a, err := c.TryLock(ctx, "job", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(3 * opts.TTL)
+	if _, err := c.TryLock(ctx, "job", opts); !errors.Is(err, ErrLockHeld) {
+		t.Fatalf("lease not extended: TryLock => %v", err)
+	}
+	select {
+	case <-a.Lost():
+		t.Fatal("lock lost while extended")
+	default:
+	}
+
+	// taken over: lost at next extension
+	s.set("job", "intruder")
+	select {
+	case <-a.Lost():
+	case <-time.After(5 * time.Second):
+		t.Fatal("takeover not detected")
+	}
+	if err := a.Unlock(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("Unlock of lost lock => %v, want %v", err, ErrLockNotHeld)
+	}
+}
+
+func TestLockOutage(t *testing.T) {
+	s := newStandIn()
+	c := s.client()
+	ctx := context.Background()
+	a, err := c.TryLock(ctx, "job", &LockOptions{TTL: 60 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// extension keeps failing: lost once lease surely expired
+	s.setDown(true)
+	start := time.Now()
+	select {
+	case <-a.Lost():
+		if time.Since(start) < 30*time.Millisecond {
+			t.Fatalf("lost too early: %v", time.Since(start))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("outage not detected")
+	}
+	if _, err := c.TryLock(ctx, "job", nil); err == nil {
+		t.Fatal("TryLock during outage should fail")
+	}
+	s.setDown(false)
+	if b, err := c.TryLock(ctx, "job", nil); err != nil || b.Fence() != 2 {
+		t.Fatalf("TryLock after expiry => %v", err)
+	}
+}
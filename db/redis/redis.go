@@ -1,14 +1,123 @@
 package redis
 
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
 import "github.com/go-redis/redis"
+import "github.com/Vonng/gopher/atomic"
+
+// Client is a redis client of any Mode, it embeds redis.UniversalClient
+// so all go-redis commands are available directly, and itself is a
+// redis.UniversalClient as well
+type Client struct {
+	redis.UniversalClient
+	config Config
+}
+
+// Open create a client with given config and verify it by PING.
+// Nothing happens at import time, client only exists after Open succeed
+func Open(ctx context.Context, cfg Config) (*Client, error) {
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	client, err := cfg.client()
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{UniversalClient: client, config: cfg}
+	if err := c.ping(ctx); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("redis: connect %s %s: %w", cfg.Mode, cfg.Addr(), err)
+	}
+	return c, nil
+}
+
+// OpenURL is equivalent to ParseURL then Open
+func OpenURL(ctx context.Context, redisURL string) (*Client, error) {
+	cfg, err := ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return Open(ctx, *cfg)
+}
+
+// Config returns config with defaults filled
+func (c *Client) Config() Config {
+	return c.config
+}
 
-// Redis: Global redis instance will init when ENV:REDIS IS SET
-var Redis *redis.Client
+// ping issue PING to check server is reachable, go-redis v6 commands
+// ignore context, so ctx only bounds the wait
+func (c *Client) ping(ctx context.Context) error {
+	return do(ctx, func() error { return c.UniversalClient.Ping().Err() })
+}
 
+// do run fn, returns early with ctx.Err() if ctx is done first
+func do(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// NewRedis will create a new redis client from redis url
+// returns nil on error
+//
+// Deprecated: use Open or OpenURL, which report errors and verify connection
 func NewRedis(redisURL string) *redis.Client {
-	if redisOpt, err := redis.ParseURL(redisURL); err != nil {
+	redisOpt, err := redis.ParseURL(redisURL)
+	if err != nil {
 		return nil
-	} else {
-		return redis.NewClient(redisOpt)
 	}
+	return redis.NewClient(redisOpt)
+}
+
+/**************************************************************
+* default instance
+**************************************************************/
+
+var (
+	defaultClient atomic.Pointer[Client]
+	defaultOnce   atomic.OnceErr
+)
+
+// Default returns the process wide instance, opened on first call
+// from ENV:REDIS or DefaultURL. a failed open will be retried on
+// next call. Only code calling Default pays for the connection
+func Default() (*Client, error) {
+	err := defaultOnce.Do(func() error {
+		if defaultClient.Load() != nil {
+			return nil
+		}
+		redisURL := DefaultURL
+		if envURL := os.Getenv("REDIS"); envURL != "" {
+			redisURL = envURL
+		}
+		client, err := OpenURL(context.Background(), redisURL)
+		if err != nil {
+			return err
+		}
+		defaultClient.Store(client)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return defaultClient.Load(), nil
+}
+
+// SetDefault replace the instance returned by Default
+func SetDefault(c *Client) {
+	defaultClient.Store(c)
 }
@@ -1,9 +1,11 @@
 package main
 
-import "sync"
+import "context"
+import "sort"
 import "strings"
-import "github.com/go-pg/pg"
 import . "github.com/Vonng/gopher/db/pg"
+import "github.com/Vonng/gopher/db/pg/synccache"
+import "github.com/Vonng/gopher/atomic"
 import log "github.com/Sirupsen/logrus"
 
 type User struct {
@@ -11,47 +13,52 @@ type User struct {
 	Name string
 }
 
-var Users sync.Map // Users 内部数据缓存
+var Pg *DB                               // Pg 数据库连接池
+var Users *synccache.Cache[string, User] // Users 内部数据缓存，与users表自动同步
 
-func LoadAllUser() {
-	var users []User
-	Pg.Query(&users, `SELECT ID,name FROM users;`)
-	for _, user := range users {
-		Users.Store(user.ID, user)
-	}
-}
+var userLoader atomic.Group[string, User] // userLoader 合并对同一ID的并发回源查询
 
-func LoadUser(id string) {
-	user := User{ID: id}
-	Pg.Select(&user)
-	Users.Store(user.ID, user)
+// GetUser 从缓存读取用户，未命中时回源数据库；同一ID的并发请求只会查询一次
+func GetUser(id string) (User, error) {
+	if user, ok := Users.Get(id); ok {
+		return user, nil
+	}
+	user, err, _ := userLoader.Do(id, func() (User, error) {
+		user := User{ID: id}
+		err := Pg.Select(&user)
+		return user, err
+	})
+	return user, err
 }
 
 func PrintUsers() string {
 	var buf []string
-	Users.Range(func(key, value interface{}) bool {
-		buf = append(buf, key.(string));
+	Users.Range(func(id string, user User) bool {
+		buf = append(buf, id)
 		return true
 	})
+	sort.Strings(buf)
 	return strings.Join(buf, ",")
 }
 
-// ListenUserChange 会监听PostgreSQL users数据表中的变动通知
-func ListenUserChange() {
-	go func(c <-chan *pg.Notification) {
-		for notify := range c {
-			action, id := notify.Payload[0], notify.Payload[1:]
-			switch action {
-			case 'I':
-				fallthrough
-			case 'U':
-				LoadUser(id);
-			case 'D':
-				Users.Delete(id)
-			}
-			log.Infof("[NOTIFY] Action:%c ID:%s Users: %s", action, id, PrintUsers())
-		}
-	}(Pg.Listen("users_chan").Channel())
+// SyncUsers 全量加载users表并监听变动通知，断线重连后自动全量重载，并定期校验修复
+func SyncUsers(ctx context.Context) error {
+	Users = synccache.New[string, User](Pg, "users", "id",
+		synccache.StructLoader[string, User](func(user *User) string { return user.ID }))
+	Users.OnError = func(err error) { log.Warn(err) }
+	Users.OnChange(func(change synccache.Change[string, User]) {
+		log.Infof("[NOTIFY] Action:%s ID:%s Users: %s", change.Action, change.Key, PrintUsers())
+	})
+	if err := Users.Start(ctx); err != nil {
+		return err
+	}
+	// 定期按桶校验缓存与users表，只重新加载不一致的行
+	reconciler := synccache.NewReconciler(Users, `coalesce(name, '')`, func(user User) string { return user.Name })
+	reconciler.OnDrift = func(drift synccache.Drift) {
+		log.Warnf("[DRIFT] users: %d missing, %d stale, %d extra, %d repaired", drift.Missing, drift.Stale, drift.Extra, drift.Repaired)
+	}
+	go reconciler.Run(ctx)
+	return nil
 }
 
 // MakeSomeChange 会向数据库写入一些变更
@@ -66,10 +73,15 @@ func MakeSomeChange() {
 }
 
 func main() {
-	Pg = NewPg("postgres://localhost:5432/postgres")
+	var err error
+	ctx := context.Background()
+	if Pg, err = OpenURL(ctx, "postgres://localhost:5432/postgres"); err != nil {
+		log.Fatal(err)
+	}
 	Pg.Exec(`TRUNCATE TABLE users;`)
-	LoadAllUser()
-	ListenUserChange()
+	if err = SyncUsers(ctx); err != nil {
+		log.Fatal(err)
+	}
 	MakeSomeChange()
 	<-make(chan struct{})
 }
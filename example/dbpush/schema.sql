@@ -1,12 +1,3 @@
--- 通知触发器
-CREATE OR REPLACE FUNCTION notify_change() RETURNS TRIGGER AS $$
-BEGIN
-  IF    (TG_OP = 'INSERT') THEN PERFORM pg_notify(TG_RELNAME || '_chan', 'I' || NEW.id); RETURN NEW;
-  ELSIF (TG_OP = 'UPDATE') THEN PERFORM pg_notify(TG_RELNAME || '_chan', 'U' || NEW.id); RETURN NEW;
-  ELSIF (TG_OP = 'DELETE') THEN PERFORM pg_notify(TG_RELNAME || '_chan', 'D' || OLD.id); RETURN OLD;
-  END IF;
-END; $$ LANGUAGE plpgsql SECURITY DEFINER;
-
 -- 用户表
 CREATE TABLE users (
   id   TEXT,
@@ -14,6 +5,27 @@ CREATE TABLE users (
   PRIMARY KEY (id)
 );
 
---
-CREATE TRIGGER t_user_notify AFTER INSERT OR UPDATE OR DELETE ON users
-FOR EACH ROW EXECUTE PROCEDURE notify_change();
\ No newline at end of file
+-- 变更通知触发器，由 pgnotify -table users -truncate sql 生成
+DROP TRIGGER IF EXISTS "users_notify_change" ON "users";
+DROP TRIGGER IF EXISTS "users_notify_update" ON "users";
+DROP TRIGGER IF EXISTS "users_notify_truncate" ON "users";
+CREATE OR REPLACE FUNCTION "users_notify_change"() RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_OP = 'TRUNCATE' THEN
+    PERFORM pg_notify('users_chan', 'T');
+  ELSIF TG_OP = 'INSERT' THEN
+    PERFORM pg_notify('users_chan', CASE WHEN octet_length('I' || NEW."id"::text) <= 7999 THEN 'I' || NEW."id"::text ELSE 'T' END);
+  ELSIF TG_OP = 'DELETE' THEN
+    PERFORM pg_notify('users_chan', CASE WHEN octet_length('D' || OLD."id"::text) <= 7999 THEN 'D' || OLD."id"::text ELSE 'T' END);
+  ELSIF ROW(OLD."id") IS DISTINCT FROM ROW(NEW."id") THEN
+    PERFORM pg_notify('users_chan', CASE WHEN octet_length('D' || OLD."id"::text) <= 7999 THEN 'D' || OLD."id"::text ELSE 'T' END);
+    PERFORM pg_notify('users_chan', CASE WHEN octet_length('I' || NEW."id"::text) <= 7999 THEN 'I' || NEW."id"::text ELSE 'T' END);
+  ELSE
+    PERFORM pg_notify('users_chan', CASE WHEN octet_length('U' || NEW."id"::text) <= 7999 THEN 'U' || NEW."id"::text ELSE 'T' END);
+  END IF;
+  RETURN NULL;
+END; $$ LANGUAGE plpgsql;
+CREATE TRIGGER "users_notify_change" AFTER INSERT OR UPDATE OR DELETE ON "users"
+FOR EACH ROW EXECUTE PROCEDURE "users_notify_change"();
+CREATE TRIGGER "users_notify_truncate" AFTER TRUNCATE ON "users"
+FOR EACH STATEMENT EXECUTE PROCEDURE "users_notify_change"();